@@ -5,22 +5,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
-	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/leaanthony/clir"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
 type ApigeeProxies struct {
-	Proxies []ApigeeApi `json:"proxies"`
+	Proxies       []ApigeeApi `json:"proxies"`
+	NextPageToken string      `json:"nextPageToken"`
 }
 
 type ApigeeApi struct {
@@ -68,6 +71,45 @@ type ApigeeFlags struct {
 	Token       string `name:"token" description:"The Google access token to call Apigee with."`
 	ApiName     string `name:"api" description:"A specific Apigee API."`
 	Environment string `name:"environment" description:"A specific Apigee environment."`
+	Prune       bool   `name:"prune" description:"If API Hub APIs and deployments created by apimsync whose source no longer exists should be removed."`
+	PruneSource string `name:"prunesource" description:"Only prune API Hub APIs and deployments created from this source platform, e.g. azure."`
+	Protected   string `name:"protected" description:"Comma-separated API and deployment names that are never removed by pruning."`
+	Refresh     bool   `name:"refresh" description:"If APIs that are unchanged since the last sync should still be compared with API Hub."`
+	Workspace   string `name:"workspace" description:"The workspace the files are in, a directory or e.g. s3://bucket/prefix, default is APIMSYNC_WORKSPACE or src/main."`
+}
+
+type apigeePlatform struct {
+	flags ApigeeFlags
+}
+
+func init() {
+	registerPlatform(PlatformRegistration{
+		Name:        "apigee",
+		Description: "Apigee",
+		New:         newApigeePlatform,
+		Commands:    apigeeCommands,
+	})
+}
+
+func newApigeePlatform(options PlatformOptions) Platform {
+	flags := ApigeeFlags{Project: os.Getenv("APIGEE_PROJECT"), Region: os.Getenv("APIGEE_REGION")}
+	flags.ApiName = options.ApiName
+	flags.Workspace = options.Workspace
+	return &apigeePlatform{flags: flags}
+}
+
+func (p *apigeePlatform) Status() PlatformStatus {
+	return apigeeStatus(&p.flags)
+}
+
+func apigeeCommands(cli *clir.Cli) {
+	apigeeCommand := cli.NewSubCommand("apigee", "Functions for Apigee.")
+	apigeeApisCommand := apigeeCommand.NewSubCommand("apis", "Functions for Apigee API resources.")
+	apigeeApisCommand.NewSubCommandFunction("export", "Exports Apigee APIs from a given project.", locked("apigee apis export", snapshotted("apigee apis export", apigeeExport)))
+	apigeeApisCommand.NewSubCommandFunction("import", "Imports APIs to an Apigee project.", locked("apigee apis import", apigeeImport))
+	apigeeApisCommand.NewSubCommandFunction("clean", "Removes all of the Apigee APIs from a given project.", locked("apigee apis clean", apigeeClean))
+	apigeeTestCommand := apigeeCommand.NewSubCommand("test", "Local test commands.")
+	apigeeTestCommand.NewSubCommandFunction("init", "Initializes local test data for an environment.", locked("apigee test init", initApigeeTest))
 }
 
 func apigeeStatus(flags *ApigeeFlags) PlatformStatus {
@@ -95,24 +137,11 @@ func apigeeStatus(flags *ApigeeFlags) PlatformStatus {
 			}
 		}
 	}
-	req, _ := http.NewRequest(http.MethodGet, "https://apigee.googleapis.com/v1/organizations/"+flags.Project+"/apis?includeRevisions=true", nil)
-	req.Header.Add("Authorization", "Bearer "+flags.Token)
-
-	var apis ApigeeProxies
-	resp, err := http.DefaultClient.Do(req)
+	apis, err := getApigeeApis(context.Background(), flags.Project, flags.Token)
 	if err == nil {
-		body, err := io.ReadAll(resp.Body)
-		if err == nil {
-			json.Unmarshal(body, &apis)
-		}
-
-		if resp.StatusCode == 200 {
-			status.Connected = true
-			status.Message = "Connected to Apigee, " + strconv.Itoa(len(apis.Proxies)) + " APIs found in project " + flags.Project + "."
-		} else {
-			status.Connected = false
-			status.Message = resp.Status
-		}
+		status.Connected = true
+		status.Apis = len(apis.Proxies)
+		status.Message = "Connected to Apigee, " + strconv.Itoa(len(apis.Proxies)) + " APIs found in project " + flags.Project + "."
 	} else {
 		status.Connected = false
 		status.Message = err.Error()
@@ -121,29 +150,32 @@ func apigeeStatus(flags *ApigeeFlags) PlatformStatus {
 	return status
 }
 
-func apigeeExport(flags *ApigeeFlags) error {
+func apigeeExport(flags *ApigeeFlags) (err error) {
+	result := newStageResult("export", "apigee")
+	ctx, span := startStage(context.Background(), "export", "apigee")
+	defer func() { span.endStage(result, err) }()
+
 	if flags.Project == "" {
-		fmt.Println("No project given, cannot export Apigee APIs.")
-		return nil
+		return errors.New("no project given, cannot export Apigee APIs")
+	}
+
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("Exporting Apigee APIs for project " + flags.Project + "...")
-	var baseDir = "src/main/apigee/apiproxies"
+	var baseDir = "apigee/apiproxies"
 
 	var environment ApigeeEnvironment
 	if flags.Environment != "" {
-		// Create dir if it does not exist
-		os.MkdirAll("src/main/apigee/environments/"+flags.Environment, 0755)
-
-		// Open deployments.json file
-		deploymentsFile, err := os.Open("src/main/apigee/environments/" + flags.Environment + "/deployments.json")
+		// Read deployments.json file
+		byteValue, err := storage.ReadFile("apigee/environments/" + flags.Environment + "/deployments.json")
 		if err != nil {
 			environment = ApigeeEnvironment{Proxies: []ApigeeEnvironmentProxy{}, SharedFlows: []ApigeeEnvironmentProxy{}}
 		} else {
-			byteValue, _ := io.ReadAll(deploymentsFile)
 			json.Unmarshal(byteValue, &environment)
 		}
-		defer deploymentsFile.Close()
 	}
 
 	if flags.Token == "" {
@@ -164,30 +196,24 @@ func apigeeExport(flags *ApigeeFlags) error {
 		}
 	}
 
-	apis := getApigeeApis(flags.Project, flags.Token)
+	apis, err := getApigeeApis(ctx, flags.Project, flags.Token)
+	if err != nil {
+		return errors.New("could not list Apigee APIs: " + err.Error())
+	}
 	// apisOutput, _ := json.Marshal(apis)
 	// fmt.Println(string(apisOutput))
 
 	if apis.Proxies != nil {
-		os.MkdirAll(baseDir, 0755)
 		for _, api := range apis.Proxies {
-			if flags.ApiName == "" || flags.ApiName == api.Name {
+			if (flags.ApiName == "" || flags.ApiName == api.Name) && apiIncluded(api.Name) {
 				fmt.Println("Exporting " + api.Name + "...")
-				bundle := getApigeeApiBundle(flags.Project, api.Name, api.Revision[0], flags.Token)
-
-				if bundle != nil {
-					err := os.WriteFile(baseDir+"/"+api.Name+".zip", bundle, 0644)
-					if err != nil {
-						panic(err)
-					}
-
-					// extract zip file
-					unzipApigeeBundle(baseDir, api.Name)
-
-					err = os.Remove(baseDir + "/" + api.Name + ".zip")
-					if err != nil {
-						panic(err)
-					}
+				apiCtx, apiSpan := result.startApi(ctx, api.Name)
+				err := exportApigeeApi(apiCtx, flags, storage, baseDir, api)
+				apiSpan.end(err)
+				if err != nil {
+					result.fail(api.Name, err)
+				} else {
+					result.ok(api.Name)
 
 					// add to deployments.json if not already there
 					foundProxy := false
@@ -207,21 +233,43 @@ func apigeeExport(flags *ApigeeFlags) error {
 		if flags.Environment != "" {
 			// write deployments.json
 			bytes, _ := json.MarshalIndent(environment, "", "  ")
-			os.WriteFile("src/main/apigee/environments/"+flags.Environment+"/deployments.json", bytes, 0644)
+			storage.WriteFile("apigee/environments/"+flags.Environment+"/deployments.json", bytes)
 		}
 	}
 
-	return nil
+	return printStageResult(result, result.Err())
 }
 
-func apigeeImport(flags *ApigeeFlags) error {
+// exportApigeeApi downloads the bundle of the first revision of a proxy and extracts it to baseDir.
+func exportApigeeApi(ctx context.Context, flags *ApigeeFlags, storage Storage, baseDir string, api ApigeeApi) error {
+	if len(api.Revision) == 0 {
+		return errors.New("the proxy has no revisions")
+	}
+	bundle, err := getApigeeApiBundle(ctx, flags.Project, api.Name, api.Revision[0], flags.Token)
+	if err != nil {
+		return errors.New("could not get bundle: " + err.Error())
+	}
+
+	// extract zip file
+	return unzipApigeeBundle(storage, baseDir, api.Name, bundle)
+}
+
+func apigeeImport(flags *ApigeeFlags) (err error) {
+	result := newStageResult("import", "apigee")
+	ctx, span := startStage(context.Background(), "import", "apigee")
+	defer func() { span.endStage(result, err) }()
+
 	if flags.Project == "" {
-		fmt.Println("No project given.")
-		return nil
+		return errors.New("no project given")
+	}
+
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("Importing Apigee APIs to project " + flags.Project + "...")
-	var baseDir = "src/main/apigee/apiproxies"
+	var baseDir = "apigee/apiproxies"
 	if flags.Token == "" {
 		var token *oauth2.Token
 		scopes := []string{
@@ -240,30 +288,40 @@ func apigeeImport(flags *ApigeeFlags) error {
 		}
 	}
 
-	apis, err := os.ReadDir(baseDir)
-	if err == nil {
-		for _, e := range apis {
-			if flags.ApiName == "" || flags.ApiName == e.Name() {
-				fmt.Println("Importing " + e.Name() + "...")
-				os.Chdir(baseDir + "/" + e.Name())
-				zipApigeeBundle(e.Name())
-				err := createApigeeApi(flags.Project, flags.Token, e.Name())
-				if err != nil {
-					fmt.Println("Error importing Apigee API: " + err.Error())
-				}
-				os.Remove(e.Name() + ".zip")
-				os.Chdir("../../../../..")
+	apis, err := storage.ReadDir(baseDir)
+	if err != nil {
+		return errors.New("could not read exported Apigee APIs: " + err.Error())
+	}
+	for _, e := range apis {
+		if flags.ApiName == "" || flags.ApiName == e.Name() {
+			fmt.Println("Importing " + e.Name() + "...")
+			apiCtx, apiSpan := result.startApi(ctx, e.Name())
+			err := importApigeeApi(apiCtx, flags, storage, baseDir, e.Name())
+			apiSpan.end(err)
+			if err != nil {
+				result.fail(e.Name(), err)
+			} else {
+				result.ok(e.Name())
 			}
 		}
 	}
 
-	return nil
+	return printStageResult(result, result.Err())
+}
+
+// importApigeeApi zips the exported proxy and imports it as a new revision.
+func importApigeeApi(ctx context.Context, flags *ApigeeFlags, storage Storage, baseDir string, name string) error {
+	bundle, err := zipApigeeBundle(storage, baseDir+"/"+name)
+	if err != nil {
+		return errors.New("could not zip bundle: " + err.Error())
+	}
+
+	return createApigeeApi(ctx, flags.Project, flags.Token, name, bundle)
 }
 
 func apigeeClean(flags *ApigeeFlags) error {
 	if flags.Project == "" {
-		fmt.Println("No project given.")
-		return nil
+		return errors.New("no project given")
 	}
 
 	fmt.Println("Removing all Apigee APIs for project " + flags.Project + "...")
@@ -286,176 +344,188 @@ func apigeeClean(flags *ApigeeFlags) error {
 		}
 	}
 
-	apis := getApigeeApis(flags.Project, flags.Token)
+	apis, err := getApigeeApis(context.Background(), flags.Project, flags.Token)
+	if err != nil {
+		return errors.New("could not list Apigee APIs: " + err.Error())
+	}
+	var errs []error
 	for _, api := range apis.Proxies {
 		if flags.ApiName == "" || flags.ApiName == api.Name {
 			fmt.Println("Deleting " + api.Name + "...")
-			deleteApigeeApi(flags.Project, flags.Token, api.Name)
+			if err := deleteApigeeApi(context.Background(), flags.Project, flags.Token, api.Name); err != nil {
+				errs = append(errs, errors.New(api.Name+": "+err.Error()))
+			}
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-func getApigeeApis(org string, token string) ApigeeProxies {
+func getApigeeApis(ctx context.Context, org string, token string) (ApigeeProxies, error) {
 	var apis ApigeeProxies
-	req, _ := http.NewRequest(http.MethodGet, "https://apigee.googleapis.com/v1/organizations/"+org+"/apis?includeRevisions=true", nil)
-	req.Header.Add("Authorization", "Bearer "+token)
+	pageToken := ""
+	seen := map[string]bool{}
+
+	// read pages until there is no nextPageToken
+	for {
+		query := url.Values{"includeRevisions": {"true"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, apigeeUrl()+"/v1/organizations/"+org+"/apis?"+query.Encode(), nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return apis, err
+		}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil {
 		body, err := io.ReadAll(resp.Body)
-		if err == nil {
-			json.Unmarshal(body, &apis)
-			//fmt.Println(string(body))
+		resp.Body.Close()
+		if err != nil {
+			return apis, err
 		}
+		if resp.StatusCode != 200 {
+			return apis, errors.New(resp.Status)
+		}
+
+		var page ApigeeProxies
+		json.Unmarshal(body, &page)
+		apis.Proxies = append(apis.Proxies, page.Proxies...)
+		if page.NextPageToken == "" {
+			break
+		}
+		// a repeated token would read the same pages forever
+		if seen[page.NextPageToken] {
+			return apis, errors.New("Apigee returned the page token " + page.NextPageToken + " twice")
+		}
+		seen[page.NextPageToken] = true
+		pageToken = page.NextPageToken
 	}
 
-	return apis
+	return apis, nil
 }
 
-func getApigeeApiBundle(org string, api string, revision string, token string) []byte {
-	var bundle []byte
-
-	req, _ := http.NewRequest(http.MethodGet, "https://apigee.googleapis.com/v1/organizations/"+org+"/apis/"+api+"/revisions/"+revision+"?format=bundle", nil)
+func getApigeeApiBundle(ctx context.Context, org string, api string, revision string, token string) ([]byte, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, apigeeUrl()+"/v1/organizations/"+org+"/apis/"+api+"/revisions/"+revision+"?format=bundle", nil)
 	req.Header.Add("Authorization", "Bearer "+token)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil {
-		bundle, _ = io.ReadAll(resp.Body)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bundle, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
 	}
 
-	return bundle
+	return bundle, nil
 }
 
-func unzipApigeeBundle(basePath string, name string) {
-	zipBaseBath := basePath + "/" + name
-	os.Mkdir(basePath+"/"+name, 0755)
-	archive, err := zip.OpenReader(basePath + "/" + name + ".zip")
+// unzipApigeeBundle extracts the bundle of a proxy to the directory name in basePath.
+func unzipApigeeBundle(storage Storage, basePath string, name string, bundle []byte) error {
+	archive, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer archive.Close()
 
 	for _, f := range archive.File {
-		filePath := filepath.Join(zipBaseBath, f.Name)
+		filePath := path.Clean(f.Name)
 
-		if !strings.HasPrefix(filePath, filepath.Clean(zipBaseBath)+string(os.PathSeparator)) {
-			fmt.Println("invalid file path")
-			return
+		if path.IsAbs(filePath) || filePath == ".." || strings.HasPrefix(filePath, "../") || strings.Contains(f.Name, "\\") {
+			return errors.New("invalid file path " + f.Name + " in bundle")
 		}
 		if f.FileInfo().IsDir() {
-			os.MkdirAll(filePath, os.ModePerm)
 			continue
 		}
 
-		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
-			panic(err)
-		}
-
-		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			panic(err)
-		}
-
 		fileInArchive, err := f.Open()
 		if err != nil {
-			panic(err)
-		}
-
-		if _, err := io.Copy(dstFile, fileInArchive); err != nil {
-			panic(err)
+			return err
 		}
-
-		dstFile.Close()
+		data, err := io.ReadAll(fileInArchive)
 		fileInArchive.Close()
-	}
-}
-
-func zipApigeeBundle(name string) {
-	file, err := os.Create(name + ".zip")
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
-
-	w := zip.NewWriter(file)
-	defer w.Close()
-
-	walker := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return nil
-		}
-		file, err := os.Open(path)
-		if err != nil {
+
+		if err := storage.WriteFile(basePath+"/"+name+"/"+filePath, data); err != nil {
 			return err
 		}
-		defer file.Close()
+	}
+
+	return nil
+}
 
-		// Ensure that `path` is not absolute; it should not start with "/".
-		// This snippet happens to work because I don't use
-		// absolute paths, but ensure your real-world code
-		// transforms path into a zip-root relative path.
-		f, err := w.Create(path)
+// zipApigeeBundle zips the apiproxy directory in dir, with paths relative to dir.
+func zipApigeeBundle(storage Storage, dir string) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	w := zip.NewWriter(buffer)
+
+	walker := func(name string) error {
+		data, err := storage.ReadFile(name)
 		if err != nil {
 			return err
 		}
 
-		_, err = io.Copy(f, file)
+		f, err := w.Create(strings.TrimPrefix(name, dir+"/"))
 		if err != nil {
 			return err
 		}
 
-		return nil
+		_, err = f.Write(data)
+		return err
 	}
 
-	err = filepath.Walk("apiproxy", walker)
-	if err != nil {
-		panic(err)
+	if err := walkStorage(storage, dir+"/apiproxy", walker); err != nil {
+		w.Close()
+		return nil, err
 	}
+	err := w.Close()
+	return buffer.Bytes(), err
 }
 
-func deleteApigeeApi(org string, token string, api string) {
-	req, _ := http.NewRequest(http.MethodDelete, "https://apigee.googleapis.com/v1/organizations/"+org+"/apis/"+api, nil)
+func deleteApigeeApi(ctx context.Context, org string, token string, api string) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodDelete, apigeeUrl()+"/v1/organizations/"+org+"/apis/"+api, nil)
 	req.Header.Add("Authorization", "Bearer "+token)
 
-	_, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		fmt.Println("Error deleting Apigee API: " + err.Error())
+		return err
 	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New(resp.Status)
+	}
+	return nil
 }
 
-func createApigeeApi(org string, token string, name string) error {
-
-	fileDir, _ := os.Getwd()
-	fileName := name + ".zip"
-	filePath := path.Join(fileDir, fileName)
-
-	file, _ := os.Open(filePath)
-	defer file.Close()
-
-	//fmt.Println(filepath.Base(file.Name()))
-
+func createApigeeApi(ctx context.Context, org string, token string, name string, bundle []byte) error {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
-	part, _ := writer.CreateFormFile("file", filepath.Base(file.Name()))
-	io.Copy(part, file)
+	part, _ := writer.CreateFormFile("file", name+".zip")
+	part.Write(bundle)
 	writer.Close()
 
-	r, _ := http.NewRequest(http.MethodPost, "https://apigee.googleapis.com/v1/organizations/"+org+"/apis?name="+name+"&action=import", body)
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, apigeeUrl()+"/v1/organizations/"+org+"/apis?name="+name+"&action=import", body)
 	r.Header.Add("Content-Type", writer.FormDataContentType())
 	r.Header.Add("Authorization", "Bearer "+token)
-	client := &http.Client{}
-	resp, err := client.Do(r)
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		fmt.Println("Error creating Apigee API: " + resp.Status)
+		respBody, _ := io.ReadAll(resp.Body)
+		return errors.New("could not create Apigee API: " + resp.Status + " " + string(respBody))
 	}
 
-	return err
+	return nil
 }
 
 func initApigeeTest(flags *ApigeeFlags) error {
@@ -481,23 +551,23 @@ func initApigeeTest(flags *ApigeeFlags) error {
 	app := ApigeeDeveloperApp{DeveloperEmail: developer.Email, Name: "test_app", DisplayName: "Test App", ApiProducts: []string{"test_product"}, ExpiryType: "never"}
 	apps := []ApigeeDeveloperApp{app}
 
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+
 	// load environment deployments.json
 	var environment ApigeeEnvironment
-	deploymentsFile, err := os.Open("src/main/apigee/environments/" + flags.Environment + "/deployments.json")
+	byteValue, err := storage.ReadFile("apigee/environments/" + flags.Environment + "/deployments.json")
 	if err != nil {
 		environment = ApigeeEnvironment{Proxies: []ApigeeEnvironmentProxy{}, SharedFlows: []ApigeeEnvironmentProxy{}}
 	} else {
-		byteValue, _ := io.ReadAll(deploymentsFile)
 		json.Unmarshal(byteValue, &environment)
 	}
-	defer deploymentsFile.Close()
-
-	// create test directory
-	os.MkdirAll("src/main/apigee/tests/"+flags.Environment, 0755)
 
 	// write developers
 	bytes, _ := json.MarshalIndent(developers, "", "  ")
-	os.WriteFile("src/main/apigee/tests/"+flags.Environment+"/developers.json", bytes, 0644)
+	storage.WriteFile("apigee/tests/"+flags.Environment+"/developers.json", bytes)
 
 	for _, proxy := range environment.Proxies {
 		products[0].Proxies = append(products[0].Proxies, proxy.Name)
@@ -505,11 +575,11 @@ func initApigeeTest(flags *ApigeeFlags) error {
 
 	// write products
 	bytes, _ = json.MarshalIndent(products, "", "  ")
-	os.WriteFile("src/main/apigee/tests/"+flags.Environment+"/products.json", bytes, 0644)
+	storage.WriteFile("apigee/tests/"+flags.Environment+"/products.json", bytes)
 
 	// write apps
 	bytes, _ = json.MarshalIndent(apps, "", "  ")
-	os.WriteFile("src/main/apigee/tests/"+flags.Environment+"/developerapps.json", bytes, 0644)
+	storage.WriteFile("apigee/tests/"+flags.Environment+"/developerapps.json", bytes)
 
 	return nil
 }
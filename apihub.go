@@ -5,20 +5,26 @@ import (
 	"context"
 	b64 "encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/leaanthony/clir"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
 type HubApis struct {
-	Apis []HubApi `json:"apis"`
+	Apis          []HubApi `json:"apis"`
+	NextPageToken string   `json:"nextPageToken"`
 }
 
 type HubApi struct {
@@ -28,6 +34,8 @@ type HubApi struct {
 	Documentation *HubApiDocumentation `json:"documentation,omitempty"`
 	Owner         *HubApiOwner         `json:"owner,omitempty"`
 	Versions      *[]string            `json:"versions,omitempty"`
+
+	Attributes map[string]HubAttributeValues `json:"attributes,omitempty"`
 }
 
 type HubApiDocumentation struct {
@@ -40,7 +48,8 @@ type HubApiOwner struct {
 }
 
 type HubApiDeployments struct {
-	Deployments []HubApiDeployment `json:"deployments"`
+	Deployments   []HubApiDeployment `json:"deployments"`
+	NextPageToken string             `json:"nextPageToken"`
 }
 
 type HubApiDeployment struct {
@@ -52,6 +61,8 @@ type HubApiDeployment struct {
 	ResourceUri    string              `json:"resourceUri"`
 	Endpoints      []string            `json:"endpoints"`
 	ApiVersions    []string            `json:"apiVersions"`
+
+	Attributes map[string]HubAttributeValues `json:"attributes,omitempty"`
 }
 
 type HubApiVersion struct {
@@ -78,6 +89,23 @@ type HubAttributeValue struct {
 	Immutable   bool   `json:"immutable"`
 }
 
+type HubAttributeValues struct {
+	Attribute    string           `json:"attribute,omitempty"`
+	StringValues *HubStringValues `json:"stringValues,omitempty"`
+}
+
+type HubStringValues struct {
+	Values []string `json:"values"`
+}
+
+// The API Hub attributes apimsync uses to tag the APIs and deployments it owns with their source.
+const (
+	apiHubApiSourcePlatformAttribute        = "apimsync-api-source-platform"
+	apiHubApiSourceUriAttribute             = "apimsync-api-source-uri"
+	apiHubDeploymentSourcePlatformAttribute = "apimsync-deployment-source-platform"
+	apiHubDeploymentSourceUriAttribute      = "apimsync-deployment-source-uri"
+)
+
 type HubApiVersionSpec struct {
 	Name          string              `json:"name"`
 	DisplayName   string              `json:"displayName"`
@@ -91,6 +119,82 @@ type HubContents struct {
 	Contents string `json:"contents"`
 }
 
+type HubApiVersions struct {
+	Versions      []HubApiVersion `json:"versions"`
+	NextPageToken string          `json:"nextPageToken"`
+}
+
+type HubApiVersionSpecs struct {
+	Specs         []HubApiVersionSpec `json:"specs"`
+	NextPageToken string              `json:"nextPageToken"`
+}
+
+// HubApiModel holds all of the API Hub resources that belong to one API.
+type HubApiModel struct {
+	Api         HubApi
+	Versions    []HubApiVersion
+	Deployments []HubApiDeployment
+	Specs       []HubApiVersionSpec
+}
+
+type apiHubPlatform struct {
+	flags ApigeeFlags
+}
+
+func init() {
+	registerPlatform(PlatformRegistration{
+		Name:        "apihub",
+		Description: "Apigee API Hub",
+		New:         newApiHubPlatform,
+		Commands:    apiHubCommands,
+		Target:      true,
+	})
+}
+
+func newApiHubPlatform(options PlatformOptions) Platform {
+	flags := ApigeeFlags{Project: os.Getenv("APIGEE_PROJECT"), Region: os.Getenv("APIGEE_REGION")}
+	flags.ApiName = options.ApiName
+	flags.Prune = options.Prune
+	flags.PruneSource = options.PruneSource
+	flags.Refresh = options.Refresh
+	flags.Workspace = options.Workspace
+	flags.Protected = strings.Join(append(options.Protected, os.Getenv("APIHUB_PROTECTED")), ",")
+	return &apiHubPlatform{flags: flags}
+}
+
+func (p *apiHubPlatform) Status() PlatformStatus {
+	return apiHubStatus(&p.flags)
+}
+
+func (p *apiHubPlatform) Onramp(ctx context.Context) (StageResult, error) {
+	return apiHubOnramp(ctx, &p.flags)
+}
+
+func (p *apiHubPlatform) Import(ctx context.Context) (StageResult, error) {
+	return apiHubImport(ctx, &p.flags)
+}
+
+func (p *apiHubPlatform) Clean() error {
+	return apiHubClean(&p.flags)
+}
+
+func (p *apiHubPlatform) Plan(ctx context.Context) (SyncPlan, error) {
+	return apiHubPlan(ctx, &p.flags)
+}
+
+func (p *apiHubPlatform) Apply(ctx context.Context, plan SyncPlan) error {
+	return apiHubApply(ctx, &p.flags, plan)
+}
+
+func apiHubCommands(cli *clir.Cli) {
+	apiHubCommand := cli.NewSubCommand("apihub", "Functions for Apigee API Hub.")
+	apiHubApisCommand := apiHubCommand.NewSubCommand("apis", "Functions for API Hub API resources.")
+	apiHubApisCommand.NewSubCommandFunction("onramp", "Onramps APIs from general to API Hub.", locked("apihub apis onramp", apiHubOnrampMin))
+	apiHubApisCommand.NewSubCommandFunction("import", "Imports APIs to API Hub.", locked("apihub apis import", apiHubImportMin))
+	apiHubApisCommand.NewSubCommandFunction("clean", "Removes all APIs from API Hub.", locked("apihub apis clean", apiHubClean))
+	apiHubApisCommand.NewSubCommandFunction("cleanlocal", "Removes all API Hub APIs from local storage.", locked("apihub apis cleanlocal", apiHubCleanLocal))
+}
+
 func apiHubStatus(flags *ApigeeFlags) PlatformStatus {
 	var status PlatformStatus
 	if flags.Project == "" {
@@ -120,24 +224,11 @@ func apiHubStatus(flags *ApigeeFlags) PlatformStatus {
 			}
 		}
 	}
-	req, _ := http.NewRequest(http.MethodGet, "https://apihub.googleapis.com/v1/projects/"+flags.Project+"/locations/"+flags.Region+"/apis", nil)
-	req.Header.Add("Authorization", "Bearer "+flags.Token)
-
-	var apis HubApis
-	resp, err := http.DefaultClient.Do(req)
+	apis, err := getApiHubApis(context.Background(), flags.Project, flags.Region, flags.Token)
 	if err == nil {
-		body, err := io.ReadAll(resp.Body)
-		if err == nil {
-			json.Unmarshal(body, &apis)
-		}
-
-		if resp.StatusCode == 200 {
-			status.Connected = true
-			status.Message = "Connected to API Hub, " + strconv.Itoa(len(apis.Apis)) + " APIs found in project " + flags.Project + " and region " + flags.Region + "."
-		} else {
-			status.Connected = false
-			status.Message = resp.Status
-		}
+		status.Connected = true
+		status.Apis = len(apis.Apis)
+		status.Message = "Connected to API Hub, " + strconv.Itoa(len(apis.Apis)) + " APIs found in project " + flags.Project + " and region " + flags.Region + "."
 	} else {
 		status.Connected = false
 		status.Message = err.Error()
@@ -146,16 +237,26 @@ func apiHubStatus(flags *ApigeeFlags) PlatformStatus {
 	return status
 }
 
-func apiHubOnramp(flags *ApigeeFlags) error {
-	generalBaseDir := "src/main/general/apiproxies"
-	baseDir := "src/main/apihub/apiproxies"
+func apiHubOnrampMin(flags *ApigeeFlags) error {
+	return printStageResult(apiHubOnramp(context.Background(), flags))
+}
+
+func apiHubOnramp(ctx context.Context, flags *ApigeeFlags) (result StageResult, err error) {
+	ctx, span := startStage(ctx, "onramp", "apihub")
+	defer func() { span.endStage(result, err) }()
+
+	generalBaseDir := "general/apiproxies"
+	result = newStageResult("onramp", "apihub")
 
 	if flags.Project == "" {
-		fmt.Println("No project given.")
-		return nil
+		return result, errors.New("no project given")
 	} else if flags.Region == "" {
-		fmt.Println("No region given.")
-		return nil
+		return result, errors.New("no region given")
+	}
+
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return result, err
 	}
 
 	if flags.Token == "" {
@@ -176,152 +277,212 @@ func apiHubOnramp(flags *ApigeeFlags) error {
 		}
 	}
 
-	entries, err := os.ReadDir(generalBaseDir)
+	entries, err := storage.ReadDir(generalBaseDir)
 	if err != nil {
-		log.Fatal(err)
+		return result, errors.New("could not read general APIs: " + err.Error())
 	}
 
 	for _, e := range entries {
 		if flags.ApiName == "" || flags.ApiName == e.Name() {
 			fmt.Println(e.Name())
 
-			var generalApi GeneralApi
-			apiFile, err := os.Open(generalBaseDir + "/" + e.Name() + "/" + e.Name() + ".json")
+			_, apiSpan := result.startApi(ctx, e.Name())
+			written, err := onrampApiHubApi(flags, storage, generalBaseDir, e.Name())
+			apiSpan.end(err)
 			if err != nil {
-				log.Fatal(err)
-				return err
-			} else {
-				byteValue, _ := io.ReadAll(apiFile)
-				json.Unmarshal(byteValue, &generalApi)
+				result.fail(e.Name(), err)
+			} else if written {
+				result.ok(e.Name())
 			}
-			defer apiFile.Close()
+		}
+	}
 
-			if generalApi.Name != "" {
-				os.MkdirAll(baseDir+"/"+e.Name(), 0755)
+	return result, result.Err()
+}
 
-				var apiName = e.Name()
+// onrampApiHubApi writes the API Hub resources of a general API, it returns false if the API has nothing to onramp.
+func onrampApiHubApi(flags *ApigeeFlags, storage Storage, generalBaseDir string, apiName string) (bool, error) {
+	baseDir := "apihub/apiproxies"
 
-				// create API
-				var hubApi HubApi
-				hubApi.Name = "projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + apiName
-				hubApi.DisplayName = generalApi.DisplayName
-				hubApi.Description = generalApi.Description
-				if generalApi.DocumentationUrl != "" {
-					var doc HubApiDocumentation
-					doc.ExternalUri = generalApi.DocumentationUrl
-					hubApi.Documentation = &doc
-				}
+	model, err := apiHubModelFromGeneral(flags, storage, generalBaseDir, apiName)
+	if err != nil {
+		return false, err
+	}
+	if model.Api.Name == "" {
+		return false, nil
+	}
 
-				if generalApi.OwnerName != "" {
-					var owner HubApiOwner
-					owner.DisplayName = generalApi.OwnerName
-					owner.Email = generalApi.OwnerEmail
-					hubApi.Owner = &owner
-				}
+	var errs []error
+	bytes, _ := json.MarshalIndent(model.Api, "", "  ")
+	errs = append(errs, storage.WriteFile(baseDir+"/"+apiName+"/"+apiName+".json", bytes))
 
-				bytes, _ := json.MarshalIndent(hubApi, "", "  ")
-				os.WriteFile(baseDir+"/"+apiName+"/"+apiName+".json", bytes, 0644)
-
-				var apiVersions map[string][]HubApiDeployment = make(map[string][]HubApiDeployment)
-
-				// read all files
-				fileEntries, _ := os.ReadDir(generalBaseDir + "/" + e.Name())
-				for _, f := range fileEntries {
-					if strings.HasSuffix(f.Name(), "-aws.json") || strings.HasSuffix(f.Name(), "-azure.json") {
-						fmt.Println(f.Name())
-						apiVersionName := strings.ReplaceAll(f.Name(), "-aws.json", "")
-						apiVersionName = strings.ReplaceAll(apiVersionName, "-azure.json", "")
-
-						// create deployment
-						var generalDeploymentApi GeneralApi
-						apiFile, err := os.Open(generalBaseDir + "/" + apiName + "/" + f.Name())
-						if err != nil {
-							log.Fatal(err)
-							return err
-						} else {
-							byteValue, _ := io.ReadAll(apiFile)
-							json.Unmarshal(byteValue, &generalDeploymentApi)
-						}
-						defer apiFile.Close()
-
-						if generalDeploymentApi.Name != "" {
-							fmt.Println(generalDeploymentApi.Name)
-
-							// create deployment
-							var hubApiDeployment HubApiDeployment
-							hubApiDeployment.Name = "projects/" + flags.Project + "/locations/" + flags.Region + "/deployments/" + generalDeploymentApi.Name
-							hubApiDeployment.DisplayName = generalDeploymentApi.DisplayName
-							hubApiDeployment.Description = generalDeploymentApi.Description
-							hubApiDeployment.Documentation.ExternalUri = generalDeploymentApi.DocumentationUrl
-							hubApiDeployment.DeploymentType.Attribute = "projects/" + flags.Project + "/locations/" + flags.Region + "/attributes/system-deployment-type"
-							apiDeploymentType := HubAttributeValue{Id: generalDeploymentApi.PlatformId, DisplayName: generalDeploymentApi.PlatformName, Description: generalDeploymentApi.PlatformName, Immutable: true}
-							hubApiDeployment.DeploymentType.EnumValues.Values = append(hubApiDeployment.DeploymentType.EnumValues.Values, apiDeploymentType)
-							hubApiDeployment.ResourceUri = generalDeploymentApi.PlatformResourceUri
-							hubApiDeployment.Endpoints = append(hubApiDeployment.Endpoints, generalDeploymentApi.GatewayUrl)
-							hubApiDeployment.ApiVersions = append(hubApiDeployment.ApiVersions, generalDeploymentApi.Version)
-							bytes, _ := json.MarshalIndent(hubApiDeployment, "", "  ")
-							os.WriteFile(baseDir+"/"+apiName+"/"+generalDeploymentApi.Name+".json", bytes, 0644)
-
-							// record deployment for version
-							_, ok := apiVersions[apiVersionName]
-							if ok {
-								apiVersions[apiVersionName] = append(apiVersions[apiVersionName], hubApiDeployment)
-							} else {
-								apiVersions[apiVersionName] = []HubApiDeployment{hubApiDeployment}
-							}
-
-							// create API spec, if available
-							b, err := os.ReadFile(generalBaseDir + "/" + apiName + "/" + generalDeploymentApi.Name + "-oas.json")
-							if err == nil {
-								// we have a spec file
-								var hubApiVersionSpec HubApiVersionSpec
-								hubApiVersionSpec.Name = "projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + apiName + "/versions/" + apiVersionName + "/specs/" + generalDeploymentApi.Name
-								hubApiVersionSpec.DisplayName = generalDeploymentApi.DisplayName + " (" + generalDeploymentApi.PlatformName + ")"
-								apiSpecType := HubAttributeValue{Id: "openapi", DisplayName: "OpenAPI Spec", Description: "OpenAPI Spec", Immutable: true}
-								hubApiVersionSpec.SpecType.EnumValues.Values = append(hubApiVersionSpec.SpecType.EnumValues.Values, apiSpecType)
-								hubApiVersionSpec.Contents.MimeType = "application/json"
-								hubApiVersionSpec.Contents.Contents = b64.StdEncoding.EncodeToString(b)
-								hubApiVersionSpec.Documentation.ExternalUri = generalApi.DocumentationUrl
-								bytes, _ = json.MarshalIndent(hubApiVersionSpec, "", "  ")
-								os.WriteFile(baseDir+"/"+apiName+"/"+generalDeploymentApi.Name+"-oas.json", bytes, 0644)
-							}
-						}
-					}
-				}
+	for _, hubApiDeployment := range model.Deployments {
+		deploymentName := hubApiDeployment.Name[strings.LastIndex(hubApiDeployment.Name, "/")+1:]
+		bytes, _ := json.MarshalIndent(hubApiDeployment, "", "  ")
+		errs = append(errs, storage.WriteFile(baseDir+"/"+apiName+"/"+deploymentName+".json", bytes))
+	}
 
-				for k, v := range apiVersions {
-					// create API version
-					var hubApiVersion HubApiVersion
-					hubApiVersion.Name = "projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + apiName + "/versions/" + k
-					hubApiVersion.DisplayName = v[0].DisplayName
-					hubApiVersion.Description = generalApi.Description
-					hubApiVersion.Documentation.ExternalUri = generalApi.DocumentationUrl
+	for _, hubApiVersionSpec := range model.Specs {
+		specName := hubApiVersionSpec.Name[strings.LastIndex(hubApiVersionSpec.Name, "/")+1:]
+		bytes, _ := json.MarshalIndent(hubApiVersionSpec, "", "  ")
+		errs = append(errs, storage.WriteFile(baseDir+"/"+apiName+"/"+specName+"-oas.json", bytes))
+	}
 
-					for _, d := range v {
-						hubApiVersion.Deployments = append(hubApiVersion.Deployments, d.Name)
-					}
+	for _, hubApiVersion := range model.Versions {
+		versionName := hubApiVersion.Name[strings.LastIndex(hubApiVersion.Name, "/")+1:]
+		bytes, _ := json.MarshalIndent(hubApiVersion, "", "  ")
+		// versions get a suffix, since a version can have the same name as its API
+		errs = append(errs, storage.WriteFile(baseDir+"/"+apiName+"/"+versionName+"-version.json", bytes))
+	}
+
+	return true, errors.Join(errs...)
+}
+
+// apiHubModelFromGeneral maps a general API and its platform deployments to the API Hub resources
+// that should exist for it.
+func apiHubModelFromGeneral(flags *ApigeeFlags, storage Storage, generalBaseDir string, apiName string) (HubApiModel, error) {
+	var model HubApiModel
+
+	var generalApi GeneralApi
+	byteValue, err := storage.ReadFile(generalBaseDir + "/" + apiName + "/" + apiName + ".json")
+	if err != nil {
+		return model, err
+	} else {
+		json.Unmarshal(byteValue, &generalApi)
+	}
 
-					bytes, _ := json.MarshalIndent(hubApiVersion, "", "  ")
-					os.WriteFile(baseDir+"/"+apiName+"/"+k+".json", bytes, 0644)
+	if generalApi.Name == "" {
+		return model, nil
+	}
+
+	// create API
+	var hubApi HubApi
+	hubApi.Name = "projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + apiName
+	hubApi.DisplayName = generalApi.DisplayName
+	hubApi.Description = generalApi.Description
+	if generalApi.DocumentationUrl != "" {
+		var doc HubApiDocumentation
+		doc.ExternalUri = generalApi.DocumentationUrl
+		hubApi.Documentation = &doc
+	}
+
+	if generalApi.OwnerName != "" {
+		var owner HubApiOwner
+		owner.DisplayName = generalApi.OwnerName
+		owner.Email = generalApi.OwnerEmail
+		hubApi.Owner = &owner
+	}
+	hubApi.Attributes = map[string]HubAttributeValues{}
+	model.Api = hubApi
+
+	var apiVersions map[string][]HubApiDeployment = make(map[string][]HubApiDeployment)
+	var apiVersionNames []string
+
+	// read all files
+	fileEntries, _ := storage.ReadDir(generalBaseDir + "/" + apiName)
+	for _, f := range fileEntries {
+		if strings.HasSuffix(f.Name(), "-aws.json") || strings.HasSuffix(f.Name(), "-azure.json") {
+			// create deployment
+			var generalDeploymentApi GeneralApi
+			byteValue, err := storage.ReadFile(generalBaseDir + "/" + apiName + "/" + f.Name())
+			if err != nil {
+				return model, err
+			} else {
+				json.Unmarshal(byteValue, &generalDeploymentApi)
+			}
+
+			if generalDeploymentApi.Name != "" {
+				// the deployments of the same version in several source instances share the version
+				apiVersionName := generalApiVersionName(generalDeploymentApi, generalPlatformName(f.Name()))
+
+				// create deployment
+				var hubApiDeployment HubApiDeployment
+				hubApiDeployment.Name = "projects/" + flags.Project + "/locations/" + flags.Region + "/deployments/" + generalDeploymentApi.Name
+				hubApiDeployment.DisplayName = generalDeploymentApi.DisplayName
+				hubApiDeployment.Description = generalDeploymentApi.Description
+				hubApiDeployment.Documentation.ExternalUri = generalDeploymentApi.DocumentationUrl
+				hubApiDeployment.DeploymentType.Attribute = "projects/" + flags.Project + "/locations/" + flags.Region + "/attributes/system-deployment-type"
+				apiDeploymentType := HubAttributeValue{Id: generalDeploymentApi.PlatformId, DisplayName: generalDeploymentApi.PlatformName, Description: generalDeploymentApi.PlatformName, Immutable: true}
+				hubApiDeployment.DeploymentType.EnumValues.Values = append(hubApiDeployment.DeploymentType.EnumValues.Values, apiDeploymentType)
+				hubApiDeployment.ResourceUri = generalDeploymentApi.PlatformResourceUri
+				hubApiDeployment.Endpoints = append(hubApiDeployment.Endpoints, generalDeploymentApi.GatewayUrl)
+				hubApiDeployment.ApiVersions = append(hubApiDeployment.ApiVersions, generalDeploymentApi.Version)
+				hubApiDeployment.Attributes = map[string]HubAttributeValues{}
+				setApiHubSourceAttribute(flags, hubApiDeployment.Attributes, apiHubDeploymentSourcePlatformAttribute, generalDeploymentApi.PlatformId)
+				setApiHubSourceAttribute(flags, hubApiDeployment.Attributes, apiHubDeploymentSourceUriAttribute, generalDeploymentApi.PlatformResourceUri)
+				model.Deployments = append(model.Deployments, hubApiDeployment)
+
+				// tag the API with all of the sources it is deployed from
+				setApiHubSourceAttribute(flags, model.Api.Attributes, apiHubApiSourcePlatformAttribute, generalDeploymentApi.PlatformId)
+				setApiHubSourceAttribute(flags, model.Api.Attributes, apiHubApiSourceUriAttribute, generalDeploymentApi.PlatformResourceUri)
+
+				// record deployment for version
+				_, ok := apiVersions[apiVersionName]
+				if ok {
+					apiVersions[apiVersionName] = append(apiVersions[apiVersionName], hubApiDeployment)
+				} else {
+					apiVersions[apiVersionName] = []HubApiDeployment{hubApiDeployment}
+					apiVersionNames = append(apiVersionNames, apiVersionName)
+				}
+
+				// create API spec, if available
+				b, err := storage.ReadFile(generalBaseDir + "/" + apiName + "/" + generalDeploymentApi.Name + "-oas.json")
+				if err == nil {
+					// we have a spec file
+					var hubApiVersionSpec HubApiVersionSpec
+					hubApiVersionSpec.Name = "projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + apiName + "/versions/" + apiVersionName + "/specs/" + generalDeploymentApi.Name
+					hubApiVersionSpec.DisplayName = generalDeploymentApi.DisplayName + " (" + generalDeploymentApi.PlatformName + ")"
+					apiSpecType := HubAttributeValue{Id: "openapi", DisplayName: "OpenAPI Spec", Description: "OpenAPI Spec", Immutable: true}
+					hubApiVersionSpec.SpecType.EnumValues.Values = append(hubApiVersionSpec.SpecType.EnumValues.Values, apiSpecType)
+					hubApiVersionSpec.Contents.MimeType = "application/json"
+					hubApiVersionSpec.Contents.Contents = b64.StdEncoding.EncodeToString(b)
+					hubApiVersionSpec.Documentation.ExternalUri = generalApi.DocumentationUrl
+					model.Specs = append(model.Specs, hubApiVersionSpec)
 				}
 			}
 		}
 	}
 
-	return nil
+	for _, k := range apiVersionNames {
+		v := apiVersions[k]
+		// create API version
+		var hubApiVersion HubApiVersion
+		hubApiVersion.Name = "projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + apiName + "/versions/" + k
+		hubApiVersion.DisplayName = v[0].DisplayName
+		hubApiVersion.Description = generalApi.Description
+		hubApiVersion.Documentation.ExternalUri = generalApi.DocumentationUrl
+
+		for _, d := range v {
+			hubApiVersion.Deployments = append(hubApiVersion.Deployments, d.Name)
+		}
+		model.Versions = append(model.Versions, hubApiVersion)
+	}
+
+	return model, nil
+}
+
+func apiHubImportMin(flags *ApigeeFlags) error {
+	return printStageResult(apiHubImport(context.Background(), flags))
 }
 
-func apiHubImport(flags *ApigeeFlags) error {
+func apiHubImport(ctx context.Context, flags *ApigeeFlags) (result StageResult, err error) {
+	ctx, span := startStage(ctx, "import", "apihub")
+	defer func() { span.endStage(result, err) }()
+
+	result = newStageResult("import", "apihub")
 	if flags.Project == "" {
-		fmt.Println("No project given.")
-		return nil
+		return result, errors.New("no project given")
 	} else if flags.Region == "" {
-		fmt.Println("No region given.")
-		return nil
+		return result, errors.New("no region given")
+	}
+
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return result, err
 	}
 
 	fmt.Println("Importing APIs to API Hub in project " + flags.Project + "...")
-	var baseDir = "src/main/apihub/apiproxies"
+	var baseDir = "apihub/apiproxies"
 	if flags.Token == "" {
 		var token *oauth2.Token
 		scopes := []string{
@@ -340,182 +501,211 @@ func apiHubImport(flags *ApigeeFlags) error {
 		}
 	}
 
-	apis, err := os.ReadDir(baseDir)
-	if err == nil {
-		for _, e := range apis {
-			if flags.ApiName == "" || flags.ApiName == e.Name() {
-				fmt.Println("Importing " + e.Name() + "...")
-				// Create API
-				apiFile, err := os.Open(baseDir + "/" + e.Name() + "/" + e.Name() + ".json")
-				if err == nil {
-					var hubApi HubApi
-					byteValue, _ := io.ReadAll(apiFile)
-					json.Unmarshal(byteValue, &hubApi)
-
-					requestBody := bytes.NewBuffer(byteValue)
-					r, _ := http.NewRequest(http.MethodPost, "https://apihub.googleapis.com/v1/projects/"+flags.Project+"/locations/"+flags.Region+"/apis?apiId="+e.Name(), requestBody)
-					r.Header.Add("Content-Type", "application/json")
-					r.Header.Add("Authorization", "Bearer "+flags.Token)
-					client := &http.Client{}
-					fmt.Println("Creating API " + e.Name() + "...")
-					resp, _ := client.Do(r)
-
-					if resp.StatusCode != 200 {
-						fmt.Println("  >> Error creating " + e.Name() + ": " + resp.Status)
-						//Read the response body
-						respBody, _ := io.ReadAll(resp.Body)
-						sb := string(respBody)
-						fmt.Println(sb)
-						defer resp.Body.Close()
-					}
-				} else {
-					fmt.Println("  >> Error, cloud not create API in API Hub because the definition file could not be found: " + e.Name() + ".json")
-				}
-				defer apiFile.Close()
-
-				var apiVersions map[string][]string = make(map[string][]string)
-				// read all files
-				fileEntries, _ := os.ReadDir(baseDir + "/" + e.Name())
-				for _, f := range fileEntries {
-					if strings.HasSuffix(f.Name(), "-aws.json") || strings.HasSuffix(f.Name(), "-azure.json") {
-						apiDeploymentName := strings.ReplaceAll(f.Name(), ".json", "")
-						apiVersionName := strings.ReplaceAll(f.Name(), "-aws.json", "")
-						apiVersionName = strings.ReplaceAll(apiVersionName, "-azure.json", "")
-
-						// Create Deployment
-						deploymentFile, deployErr := os.Open(baseDir + "/" + e.Name() + "/" + f.Name())
-						if deployErr == nil {
-							var apiDeployment HubApiDeployment
-							byteValue, _ := io.ReadAll(deploymentFile)
-							json.Unmarshal(byteValue, &apiDeployment)
-							requestBody := bytes.NewBuffer(byteValue)
-							deploymentUrl := "https://apihub.googleapis.com/v1/projects/" + flags.Project + "/locations/" + flags.Region + "/deployments?deploymentId=" + apiDeploymentName
-							r, _ := http.NewRequest(http.MethodPost, deploymentUrl, requestBody)
-							r.Header.Add("Content-Type", "application/json")
-							r.Header.Add("Authorization", "Bearer "+flags.Token)
-							client := &http.Client{}
-							fmt.Println("Creating deployment " + apiDeploymentName + "..." + deploymentUrl)
-							resp, _ := client.Do(r)
-
-							if resp.StatusCode != 200 {
-								fmt.Println("  >> Error creating deployment " + apiDeploymentName + ": " + resp.Status)
-								//Read the response body
-								respBody, _ := io.ReadAll(resp.Body)
-								sb := string(respBody)
-								fmt.Println(sb)
-								defer resp.Body.Close()
-							}
-						}
-						defer deploymentFile.Close()
-
-						// record deployment for version
-						_, ok := apiVersions[apiVersionName]
-						if ok {
-							apiVersions[apiVersionName] = append(apiVersions[apiVersionName], apiDeploymentName)
-						} else {
-							apiVersions[apiVersionName] = []string{apiDeploymentName}
-						}
-					}
+	ensureApiHubAttributes(ctx, flags)
+
+	apis, err := storage.ReadDir(baseDir)
+	if err != nil {
+		return result, errors.New("could not read onramped APIs: " + err.Error())
+	}
+
+	for _, e := range apis {
+		if flags.ApiName == "" || flags.ApiName == e.Name() {
+			fmt.Println("Importing " + e.Name() + "...")
+			apiCtx, apiSpan := result.startApi(ctx, e.Name())
+			err := importApiHubApi(apiCtx, flags, storage, baseDir, e.Name())
+			apiSpan.end(err)
+			if err != nil {
+				result.fail(e.Name(), err)
+			} else {
+				result.ok(e.Name())
+			}
+		}
+	}
+
+	return result, result.Err()
+}
+
+// importApiHubApi upserts an onramped API with its deployments, versions and specs to API Hub.
+// All resources are tried, and the errors of the ones that failed are returned.
+func importApiHubApi(ctx context.Context, flags *ApigeeFlags, storage Storage, baseDir string, apiName string) error {
+	locationUrl := apiHubUrl() + "/v1/projects/" + flags.Project + "/locations/" + flags.Region
+	var errs []error
+
+	// Create or update API
+	byteValue, err := storage.ReadFile(baseDir + "/" + apiName + "/" + apiName + ".json")
+	if err != nil {
+		return errors.New("the API definition file could not be read: " + err.Error())
+	}
+	var hubApi HubApi
+	json.Unmarshal(byteValue, &hubApi)
+	hubApi.Versions = nil
+
+	fmt.Println("Upserting API " + apiName + "...")
+	_, err = upsertApiHubResource(ctx, locationUrl+"/apis?apiId="+apiName, locationUrl+"/apis/"+apiName, hubApi, []string{"displayName", "description", "documentation", "owner"}, flags.Token)
+	if err != nil {
+		// without the API, its versions and specs cannot be created
+		return errors.New("could not upsert API: " + err.Error())
+	}
+
+	var apiVersions map[string][]string = make(map[string][]string)
+	var apiVersionNames []string
+	// read all files
+	fileEntries, _ := storage.ReadDir(baseDir + "/" + apiName)
+
+	// the versions list their deployments
+	deploymentVersions := map[string]string{}
+	for _, f := range fileEntries {
+		if strings.HasSuffix(f.Name(), "-version.json") {
+			var apiVersion HubApiVersion
+			byteValue, _ := storage.ReadFile(baseDir + "/" + apiName + "/" + f.Name())
+			json.Unmarshal(byteValue, &apiVersion)
+			for _, deployment := range apiVersion.Deployments {
+				deploymentVersions[apiHubResourceId(deployment)] = strings.TrimSuffix(f.Name(), "-version.json")
+			}
+		}
+	}
+
+	for _, f := range fileEntries {
+		if strings.HasSuffix(f.Name(), "-aws.json") || strings.HasSuffix(f.Name(), "-azure.json") {
+			apiDeploymentName := strings.ReplaceAll(f.Name(), ".json", "")
+			apiVersionName := deploymentVersions[apiDeploymentName]
+
+			// Create or update Deployment
+			byteValue, deployErr := storage.ReadFile(baseDir + "/" + apiName + "/" + f.Name())
+			if deployErr == nil {
+				var apiDeployment HubApiDeployment
+				json.Unmarshal(byteValue, &apiDeployment)
+
+				fmt.Println("Upserting deployment " + apiDeploymentName + "...")
+				_, err := upsertApiHubResource(ctx, locationUrl+"/deployments?deploymentId="+apiDeploymentName, locationUrl+"/deployments/"+apiDeploymentName, apiDeployment, []string{"displayName", "description", "documentation", "resourceUri", "endpoints", "apiVersions"}, flags.Token)
+				if err != nil {
+					errs = append(errs, errors.New("could not upsert deployment "+apiDeploymentName+": "+err.Error()))
 				}
+			} else {
+				errs = append(errs, deployErr)
+			}
 
-				for k, v := range apiVersions {
-					// create API version
-					versionFile, err := os.Open(baseDir + "/" + e.Name() + "/" + k + ".json")
-					if err == nil {
-						var apiVersion HubApiVersion
-						byteValue, _ := io.ReadAll(versionFile)
-						json.Unmarshal(byteValue, &apiVersion)
-						bodyBytes, _ := json.Marshal(apiVersion)
-						requestBody := bytes.NewBuffer(bodyBytes)
-
-						versionUrl := "https://apihub.googleapis.com/v1/projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + e.Name() + "/versions?versionId=" + k
-						r, _ := http.NewRequest(http.MethodPost, versionUrl, requestBody)
-						r.Header.Add("Content-Type", "application/json")
-						r.Header.Add("Authorization", "Bearer "+flags.Token)
-						client := &http.Client{}
-						fmt.Println("Creating API version " + k + "...")
-						resp, _ := client.Do(r)
-
-						if resp.StatusCode != 200 {
-							fmt.Println("  >> Error creating version " + e.Name() + ": " + resp.Status)
-							defer resp.Body.Close()
-							//Read the response body
-							respBody, _ := io.ReadAll(resp.Body)
-							sb := string(respBody)
-							fmt.Println(sb)
-
-							// update if it already exists, maybe we have a new version deployment...
-							if resp.StatusCode == 409 {
-								requestBody = bytes.NewBuffer(bodyBytes)
-								versionUrl = "https://apihub.googleapis.com/v1/projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + e.Name() + "/versions/" + k + "?updateMask=deployments"
-								r, _ := http.NewRequest(http.MethodPatch, versionUrl, requestBody)
-								r.Header.Add("Content-Type", "application/json")
-								r.Header.Add("Authorization", "Bearer "+flags.Token)
-								client := &http.Client{}
-								fmt.Println("Patching API version " + k + "...")
-								resp, _ := client.Do(r)
-								if resp.StatusCode != 200 {
-									fmt.Println("  >> Error patching version " + k + ": " + resp.Status)
-									defer resp.Body.Close()
-									//Read the response body
-									respBody, _ := io.ReadAll(resp.Body)
-									sb := string(respBody)
-									fmt.Println(sb)
-								}
-							}
-						}
-					}
-					defer versionFile.Close()
-
-					for _, d := range v {
-						// Create API Version Spec
-						versionSpecFile, err := os.Open(baseDir + "/" + e.Name() + "/" + d + "-oas.json")
-						if err == nil {
-							var apiVersionSpec HubApiVersionSpec
-							byteValue, _ := io.ReadAll(versionSpecFile)
-							json.Unmarshal(byteValue, &apiVersionSpec)
-							requestBody := bytes.NewBuffer(byteValue)
-
-							versionUrl := "https://apihub.googleapis.com/v1/projects/" + flags.Project + "/locations/" + flags.Region + "/apis/" + e.Name() + "/versions/" + k + "/specs?specId=" + d
-							r, _ := http.NewRequest(http.MethodPost, versionUrl, requestBody)
-							r.Header.Add("Content-Type", "application/json")
-							r.Header.Add("Authorization", "Bearer "+flags.Token)
-							client := &http.Client{}
-							fmt.Println("Creating API version spec " + e.Name() + "...")
-							resp, _ := client.Do(r)
-
-							if resp.StatusCode != 200 {
-								fmt.Println("  >> Error deploying version spec " + e.Name() + ": " + resp.Status)
-								defer resp.Body.Close()
-								//Read the response body
-								// respBody, _ := io.ReadAll(resp.Body)
-								// sb := string(respBody)
-								// fmt.Println(sb)
-							}
-						}
-						defer versionSpecFile.Close()
-					}
+			// record deployment for version
+			_, ok := apiVersions[apiVersionName]
+			if apiVersionName == "" {
+				errs = append(errs, errors.New("no version found for deployment "+apiDeploymentName))
+			} else if ok {
+				apiVersions[apiVersionName] = append(apiVersions[apiVersionName], apiDeploymentName)
+			} else {
+				apiVersions[apiVersionName] = []string{apiDeploymentName}
+				apiVersionNames = append(apiVersionNames, apiVersionName)
+			}
+		}
+	}
+
+	for _, k := range apiVersionNames {
+		// Create or update API version
+		byteValue, err := storage.ReadFile(baseDir + "/" + apiName + "/" + k + "-version.json")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var apiVersion HubApiVersion
+		json.Unmarshal(byteValue, &apiVersion)
+
+		fmt.Println("Upserting API version " + k + "...")
+		_, err = upsertApiHubResource(ctx, locationUrl+"/apis/"+apiName+"/versions?versionId="+k, locationUrl+"/apis/"+apiName+"/versions/"+k, apiVersion, []string{"displayName", "description", "documentation", "deployments"}, flags.Token)
+		if err != nil {
+			errs = append(errs, errors.New("could not upsert version "+k+": "+err.Error()))
+			continue
+		}
+
+		for _, d := range apiVersions[k] {
+			// Create or update API Version Spec, if the deployment has one
+			byteValue, err := storage.ReadFile(baseDir + "/" + apiName + "/" + d + "-oas.json")
+			if err == nil {
+				var apiVersionSpec HubApiVersionSpec
+				json.Unmarshal(byteValue, &apiVersionSpec)
+
+				fmt.Println("Upserting API version spec " + d + "...")
+				_, err := upsertApiHubResource(ctx, locationUrl+"/apis/"+apiName+"/versions/"+k+"/specs?specId="+d, locationUrl+"/apis/"+apiName+"/versions/"+k+"/specs/"+d, apiVersionSpec, []string{"displayName", "contents", "documentation"}, flags.Token)
+				if err != nil {
+					errs = append(errs, errors.New("could not upsert version spec "+d+": "+err.Error()))
 				}
 			}
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// upsertApiHubResource creates a resource, or if it already exists, patches the given fields that differ.
+// It returns the sync action that was done.
+func upsertApiHubResource(ctx context.Context, createUrl string, resourceUrl string, resource any, fields []string, token string) (action string, err error) {
+	ctx, span := startSpan(ctx, "apihub upsert", "apihub.resource", strings.TrimPrefix(resourceUrl, apiHubUrl()+"/v1/"))
+	defer func() {
+		span.setAttribute("apimsync.action", action)
+		span.end(err)
+	}()
+
+	body, _ := json.Marshal(resource)
+	err = apiHubRequest(ctx, http.MethodPost, createUrl, body, token)
+	var requestErr *ApiHubRequestError
+	if err == nil {
+		return SyncActionCreate, nil
+	} else if !errors.As(err, &requestErr) || requestErr.StatusCode != http.StatusConflict {
+		return "", err
+	}
+
+	// the resource already exists, compare it with the current one
+	var current map[string]any
+	if err := apiHubGet(ctx, resourceUrl, token, &current); err != nil {
+		return "", err
+	}
+	if slices.Contains(fields, "contents") {
+		var contents HubContents
+		if err := apiHubGet(ctx, resourceUrl+":contents", token, &contents); err == nil {
+			current["contents"] = contents
+		}
+	}
+
+	changed := changedFields(resource, current, fields)
+
+	// only the attributes set by apimsync are compared, others are kept
+	var desiredAttributes, currentAttributes struct {
+		Attributes map[string]HubAttributeValues `json:"attributes"`
+	}
+	json.Unmarshal(body, &desiredAttributes)
+	currentBytes, _ := json.Marshal(current)
+	json.Unmarshal(currentBytes, &currentAttributes)
+	if len(desiredAttributes.Attributes) > 0 && apiHubAttributesChanged(desiredAttributes.Attributes, currentAttributes.Attributes) {
+		changed = append(changed, "attributes")
+		var patch map[string]any
+		json.Unmarshal(body, &patch)
+		patch["attributes"] = mergeApiHubAttributes(currentAttributes.Attributes, desiredAttributes.Attributes)
+		body, _ = json.Marshal(patch)
+	}
+
+	if len(changed) == 0 {
+		return SyncActionNoop, nil
+	}
+
+	fmt.Println("  Updating " + strings.Join(changed, ", ") + "...")
+	err = apiHubRequest(ctx, http.MethodPatch, resourceUrl+"?updateMask="+strings.Join(changed, ","), body, token)
+	if err != nil {
+		return "", err
+	}
+	return SyncActionUpdate, nil
 }
 
 func apiHubCleanLocal(flags *ApigeeFlags) error {
-	var baseDir = "src/main/apihub"
-	os.RemoveAll(baseDir)
-	return nil
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+	return storage.RemoveAll("apihub")
 }
 
 func apiHubClean(flags *ApigeeFlags) error {
 	if flags.Project == "" {
-		fmt.Println("No project given.")
-		return nil
+		return errors.New("no project given")
 	} else if flags.Region == "" {
-		fmt.Println("No region given.")
-		return nil
+		return errors.New("no region given")
 	}
 
 	fmt.Println("Removing all API Hub APIs for project " + flags.Project + "...")
@@ -538,75 +728,667 @@ func apiHubClean(flags *ApigeeFlags) error {
 		}
 	}
 
-	apis := getApiHubApis(flags.Project, flags.Region, flags.Token)
+	ctx := context.Background()
+	apis, err := getApiHubApis(ctx, flags.Project, flags.Region, flags.Token)
+	if err != nil {
+		return errors.New("could not list API Hub APIs: " + err.Error())
+	}
+	var errs []error
 	for _, api := range apis.Apis {
 		if flags.ApiName == "" || strings.HasSuffix(api.Name, "/"+flags.ApiName) {
 			fmt.Println("Deleting " + api.Name + "...")
-			deleteApiHubApi(api.Name, flags.Token)
+			errs = append(errs, deleteApiHubApi(ctx, api.Name, flags.Token))
 		}
 	}
 
-	deployments := getApiHubDeployments(flags.Project, flags.Region, flags.Token)
+	deployments, err := getApiHubDeployments(ctx, flags.Project, flags.Region, flags.Token)
+	if err != nil {
+		return errors.Join(append(errs, errors.New("could not list API Hub deployments: "+err.Error()))...)
+	}
 	for _, deployment := range deployments.Deployments {
 		fmt.Println("Deleting " + deployment.Name + "...")
-		deleteApiHubDeployment(deployment.Name, flags.Token)
+		errs = append(errs, deleteApiHubDeployment(ctx, deployment.Name, flags.Token))
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-func getApiHubApis(project string, region string, token string) HubApis {
+func getApiHubApis(ctx context.Context, project string, region string, token string) (HubApis, error) {
 	var apis HubApis
 
-	req, _ := http.NewRequest(http.MethodGet, "https://apihub.googleapis.com/v1/projects/"+project+"/locations/"+region+"/apis", nil)
-	req.Header.Add("Authorization", "Bearer "+token)
+	err := apiHubListPages(ctx, apiHubUrl()+"/v1/projects/"+project+"/locations/"+region+"/apis", token, func(body []byte) string {
+		var page HubApis
+		json.Unmarshal(body, &page)
+		apis.Apis = append(apis.Apis, page.Apis...)
+		return page.NextPageToken
+	})
+
+	return apis, err
+}
+
+func deleteApiHubApi(ctx context.Context, api string, token string) error {
+	return apiHubRequest(ctx, http.MethodDelete, apiHubUrl()+"/v1/"+api+"?force=true", nil, token)
+}
+
+func getApiHubDeployments(ctx context.Context, project string, region string, token string) (HubApiDeployments, error) {
+	var deployments HubApiDeployments
+
+	err := apiHubListPages(ctx, apiHubUrl()+"/v1/projects/"+project+"/locations/"+region+"/deployments", token, func(body []byte) string {
+		var page HubApiDeployments
+		json.Unmarshal(body, &page)
+		deployments.Deployments = append(deployments.Deployments, page.Deployments...)
+		return page.NextPageToken
+	})
+
+	return deployments, err
+}
+
+func deleteApiHubDeployment(ctx context.Context, deployment string, token string) error {
+	return apiHubRequest(ctx, http.MethodDelete, apiHubUrl()+"/v1/"+deployment, nil, token)
+}
+
+func apiHubPlan(ctx context.Context, flags *ApigeeFlags) (plan SyncPlan, err error) {
+	ctx, span := startSpan(ctx, "apihub plan", "apimsync.platform", "apihub")
+	defer func() {
+		span.setAttribute("apimsync.actions", len(plan.Actions))
+		span.end(err)
+	}()
+
+	generalBaseDir := "general/apiproxies"
+	plan = SyncPlan{Target: "apihub", Actions: []SyncAction{}, Hashes: map[string]string{}}
+
+	if flags.Project == "" {
+		return plan, errors.New("no project given")
+	} else if flags.Region == "" {
+		return plan, errors.New("no region given")
+	}
+
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return plan, err
+	}
+
+	if flags.Token == "" {
+		var token *oauth2.Token
+		scopes := []string{
+			"https://www.googleapis.com/auth/cloud-platform",
+		}
+
+		ctx := context.Background()
+		credentials, err := google.FindDefaultCredentials(ctx, scopes...)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil {
-		body, err := io.ReadAll(resp.Body)
 		if err == nil {
-			json.Unmarshal(body, &apis)
-			//fmt.Println(string(body))
+			token, err = credentials.TokenSource.Token()
+
+			if err == nil {
+				flags.Token = token.AccessToken
+			}
 		}
 	}
 
-	return apis
-}
+	entries, err := storage.ReadDir(generalBaseDir)
+	if err != nil {
+		return plan, err
+	}
 
-func deleteApiHubApi(api string, token string) {
-	req, _ := http.NewRequest(http.MethodDelete, "https://apihub.googleapis.com/v1/"+api+"?force=true", nil)
-	req.Header.Add("Authorization", "Bearer "+token)
+	// current resources are keyed by id, since API Hub can return names with the project number
+	hubApis, err := getApiHubApis(ctx, flags.Project, flags.Region, flags.Token)
+	if err != nil {
+		return plan, err
+	}
+	currentApis := map[string]HubApi{}
+	for _, api := range hubApis.Apis {
+		currentApis[apiHubResourceId(api.Name)] = api
+	}
+	hubDeployments, err := getApiHubDeployments(ctx, flags.Project, flags.Region, flags.Token)
+	if err != nil {
+		return plan, err
+	}
+	currentDeployments := map[string]HubApiDeployment{}
+	for _, deployment := range hubDeployments.Deployments {
+		currentDeployments[apiHubResourceId(deployment.Name)] = deployment
+	}
 
-	_, err := http.DefaultClient.Do(req)
+	// resources apimsync never removes when pruning
+	protected := map[string]bool{}
+	for _, name := range strings.Split(flags.Protected, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			protected[apiHubResourceId(name)] = true
+		}
+	}
+	// specs are named like the deployment they describe, e.g. petstore-v1-azure
+	ownedDeployment := func(id string) bool {
+		deployment, ok := currentDeployments[id]
+		return ok && apiHubPrunable(flags, deployment.Attributes, apiHubDeploymentSourcePlatformAttribute)
+	}
+
+	state, err := loadSyncState(flags.Workspace)
 	if err != nil {
-		fmt.Println("Error deleting Apigee API: " + err.Error())
+		return plan, err
+	}
+	desiredApis := map[string]bool{}
+	desiredDeployments := map[string]bool{}
+	var deletes []SyncAction
+	for _, e := range entries {
+		if !e.IsDir() || (flags.ApiName != "" && flags.ApiName != e.Name()) {
+			continue
+		}
+
+		model, err := apiHubModelFromGeneral(flags, storage, generalBaseDir, e.Name())
+		if err != nil {
+			return plan, err
+		}
+		if model.Api.Name == "" {
+			continue
+		}
+		apiName := e.Name()
+		desiredApis[apiName] = true
+		plan.Hashes[apiName], _ = generalApiHash(storage, apiName)
+
+		// skip APIs that were already synced with the same content, unless they were removed from API Hub
+		currentApi, apiExists := currentApis[apiName]
+		if syncedUnchanged(storage, state, "apihub", apiName) && !flags.Refresh {
+			if apiExists {
+				for _, deployment := range model.Deployments {
+					desiredDeployments[apiHubResourceId(deployment.Name)] = true
+				}
+				for _, name := range state.Apis[apiName].Targets["apihub"].Resources {
+					kind := "api"
+					if strings.Contains(name, "/specs/") {
+						kind = "spec"
+					} else if strings.Contains(name, "/versions/") {
+						kind = "version"
+					} else if strings.Contains(name, "/deployments/") {
+						kind = "deployment"
+					}
+					plan.Actions = append(plan.Actions, newSyncAction(SyncActionNoop, kind, apiName, name, nil, nil))
+				}
+				continue
+			}
+			fmt.Println("Drift: " + apiName + " was synced at " + state.Apis[apiName].Targets["apihub"].LastSynced.Format(time.RFC3339) + " but no longer exists in API Hub.")
+		}
+
+		// API
+		if !apiExists {
+			plan.Actions = append(plan.Actions, newSyncAction(SyncActionCreate, "api", apiName, model.Api.Name, nil, model.Api))
+		} else {
+			fields := changedFields(model.Api, currentApi, []string{"displayName", "description", "documentation", "owner"})
+			if flags.PruneSource != "" {
+				// a run limited to one source doesn't have the APIs of the other sources, so their values are kept
+				for attribute, values := range currentApi.Attributes {
+					attributeId := apiHubResourceId(attribute)
+					if (attributeId == apiHubApiSourcePlatformAttribute || attributeId == apiHubApiSourceUriAttribute) && values.StringValues != nil {
+						for _, value := range values.StringValues.Values {
+							setApiHubSourceAttribute(flags, model.Api.Attributes, attributeId, value)
+						}
+					}
+				}
+			}
+			if apiHubAttributesChanged(model.Api.Attributes, currentApi.Attributes) {
+				fields = append(fields, "attributes")
+				model.Api.Attributes = mergeApiHubAttributes(currentApi.Attributes, model.Api.Attributes)
+			}
+			if len(fields) > 0 {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionUpdate, "api", apiName, model.Api.Name, fields, model.Api))
+			} else {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionNoop, "api", apiName, model.Api.Name, nil, nil))
+			}
+		}
+
+		// deployments
+		for _, deployment := range model.Deployments {
+			deploymentId := apiHubResourceId(deployment.Name)
+			desiredDeployments[deploymentId] = true
+			currentDeployment, ok := currentDeployments[deploymentId]
+			if !ok {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionCreate, "deployment", apiName, deployment.Name, nil, deployment))
+				continue
+			}
+
+			fields := changedFields(deployment, currentDeployment, []string{"displayName", "description", "documentation", "resourceUri", "endpoints", "apiVersions"})
+			if apiHubAttributesChanged(deployment.Attributes, currentDeployment.Attributes) {
+				fields = append(fields, "attributes")
+				deployment.Attributes = mergeApiHubAttributes(currentDeployment.Attributes, deployment.Attributes)
+			}
+			if len(fields) > 0 {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionUpdate, "deployment", apiName, deployment.Name, fields, deployment))
+			} else {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionNoop, "deployment", apiName, deployment.Name, nil, nil))
+			}
+		}
+
+		// versions
+		currentVersions := map[string]HubApiVersion{}
+		if apiExists {
+			hubVersions, err := getApiHubApiVersions(ctx, currentApi.Name, flags.Token)
+			if err != nil {
+				return plan, err
+			}
+			for _, version := range hubVersions.Versions {
+				currentVersions[apiHubResourceId(version.Name)] = version
+			}
+		}
+		desiredVersions := map[string]bool{}
+		for _, version := range model.Versions {
+			versionId := apiHubResourceId(version.Name)
+			desiredVersions[versionId] = true
+			currentVersion, ok := currentVersions[versionId]
+			if !ok {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionCreate, "version", apiName, version.Name, nil, version))
+				continue
+			}
+
+			// compare deployments by id as well
+			currentVersionDeployments := []string{}
+			for _, d := range currentVersion.Deployments {
+				currentVersionDeployments = append(currentVersionDeployments, version.Name[:strings.Index(version.Name, "/apis/")]+"/deployments/"+apiHubResourceId(d))
+			}
+			currentVersion.Deployments = currentVersionDeployments
+			if fields := changedFields(version, currentVersion, []string{"displayName", "description", "documentation", "deployments"}); len(fields) > 0 {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionUpdate, "version", apiName, version.Name, fields, version))
+			} else {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionNoop, "version", apiName, version.Name, nil, nil))
+			}
+		}
+
+		// specs, keyed by version id and spec id
+		currentSpecs := map[string]HubApiVersionSpec{}
+		for versionId, version := range currentVersions {
+			hubSpecs, err := getApiHubApiVersionSpecs(ctx, version.Name, flags.Token)
+			if err != nil {
+				return plan, err
+			}
+			for _, spec := range hubSpecs.Specs {
+				currentSpecs[versionId+"/"+apiHubResourceId(spec.Name)] = spec
+			}
+		}
+		desiredSpecs := map[string]bool{}
+		for _, spec := range model.Specs {
+			specKey := apiHubResourceId(spec.Name[:strings.LastIndex(spec.Name, "/specs/")]) + "/" + apiHubResourceId(spec.Name)
+			desiredSpecs[specKey] = true
+			currentSpec, ok := currentSpecs[specKey]
+			if !ok {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionCreate, "spec", apiName, spec.Name, nil, spec))
+				continue
+			}
+
+			currentSpec.Contents, err = getApiHubApiVersionSpecContents(ctx, currentSpec.Name, flags.Token)
+			if err != nil {
+				return plan, err
+			}
+			if fields := changedFields(spec, currentSpec, []string{"displayName", "contents", "documentation"}); len(fields) > 0 {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionUpdate, "spec", apiName, spec.Name, fields, spec))
+			} else {
+				plan.Actions = append(plan.Actions, newSyncAction(SyncActionNoop, "spec", apiName, spec.Name, nil, nil))
+			}
+		}
+
+		// remove versions and specs of this API created by apimsync that are no longer in general, a version is
+		// created by apimsync if all its deployments are
+		if flags.Prune && apiExists && apiHubPrunable(flags, currentApi.Attributes, apiHubApiSourcePlatformAttribute) {
+			for specKey, spec := range currentSpecs {
+				specId := apiHubResourceId(spec.Name)
+				if desiredSpecs[specKey] || !desiredVersions[specKey[:strings.Index(specKey, "/")]] || !ownedDeployment(specId) {
+					continue
+				}
+				if protected[specId] {
+					fmt.Println("Not removing protected spec " + spec.Name + ".")
+				} else {
+					deletes = append(deletes, newSyncAction(SyncActionDelete, "spec", apiName, spec.Name, nil, nil))
+				}
+			}
+			for versionId, version := range currentVersions {
+				if desiredVersions[versionId] || len(version.Deployments) == 0 || slices.ContainsFunc(version.Deployments, func(d string) bool { return !ownedDeployment(apiHubResourceId(d)) }) {
+					continue
+				}
+				if protected[versionId] {
+					fmt.Println("Not removing protected version " + version.Name + ".")
+				} else {
+					deletes = append(deletes, newSyncAction(SyncActionDelete, "version", apiName, version.Name, nil, nil))
+				}
+			}
+		}
+	}
+
+	// remove APIs and deployments created by apimsync whose source no longer exists
+	if flags.Prune && flags.ApiName == "" {
+		for apiId, api := range currentApis {
+			if !desiredApis[apiId] && apiHubPrunable(flags, api.Attributes, apiHubApiSourcePlatformAttribute) {
+				if protected[apiId] {
+					fmt.Println("Not removing protected API " + api.Name + ".")
+				} else {
+					deletes = append(deletes, newSyncAction(SyncActionDelete, "api", apiId, api.Name, nil, nil))
+				}
+			}
+		}
+		for deploymentId, deployment := range currentDeployments {
+			if !desiredDeployments[deploymentId] && apiHubPrunable(flags, deployment.Attributes, apiHubDeploymentSourcePlatformAttribute) {
+				if protected[deploymentId] {
+					fmt.Println("Not removing protected deployment " + deployment.Name + ".")
+				} else {
+					deletes = append(deletes, newSyncAction(SyncActionDelete, "deployment", "", deployment.Name, nil, nil))
+				}
+			}
+		}
 	}
+
+	// delete children before their parents, and deployments after the versions that reference them
+	deleteOrder := map[string]int{"spec": 0, "version": 1, "api": 2, "deployment": 3}
+	sort.SliceStable(deletes, func(i, j int) bool {
+		return deleteOrder[deletes[i].Kind] < deleteOrder[deletes[j].Kind]
+	})
+	plan.Actions = append(plan.Actions, deletes...)
+
+	return plan, nil
 }
 
-func getApiHubDeployments(project string, region string, token string) HubApiDeployments {
-	var deployments HubApiDeployments
+func apiHubApply(ctx context.Context, flags *ApigeeFlags, plan SyncPlan) (err error) {
+	ctx, span := startSpan(ctx, "apihub apply", "apimsync.platform", "apihub", "apimsync.actions", len(plan.Actions))
+	defer func() { span.end(err) }()
 
-	req, _ := http.NewRequest(http.MethodGet, "https://apihub.googleapis.com/v1/projects/"+project+"/locations/"+region+"/deployments", nil)
-	req.Header.Add("Authorization", "Bearer "+token)
+	if plan.Target != "apihub" {
+		return errors.New("plan is for target " + plan.Target + ", not apihub")
+	}
+
+	if flags.Token == "" {
+		var token *oauth2.Token
+		scopes := []string{
+			"https://www.googleapis.com/auth/cloud-platform",
+		}
+
+		ctx := context.Background()
+		credentials, err := google.FindDefaultCredentials(ctx, scopes...)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil {
-		body, err := io.ReadAll(resp.Body)
 		if err == nil {
-			json.Unmarshal(body, &deployments)
-			//fmt.Println(string(body))
+			token, err = credentials.TokenSource.Token()
+
+			if err == nil {
+				flags.Token = token.AccessToken
+			}
 		}
 	}
 
-	return deployments
+	ensureApiHubAttributes(ctx, flags)
+
+	collections := map[string]string{"api": "apis", "version": "versions", "deployment": "deployments", "spec": "specs"}
+	var errs []error
+	for _, action := range plan.Actions {
+		collection, ok := collections[action.Kind]
+		if !ok {
+			errs = append(errs, &SyncActionError{Action: action, Err: errors.New("unknown resource kind " + action.Kind)})
+			continue
+		}
+
+		if action.Action == SyncActionNoop {
+			continue
+		}
+
+		actionCtx, actionSpan := startSpan(ctx, "apihub "+action.Action+" "+action.Kind, "apimsync.api", action.Api, "apihub.resource", action.Name)
+		var err error
+		switch action.Action {
+		case SyncActionCreate:
+			fmt.Println("Creating " + action.Kind + " " + action.Name + "...")
+			index := strings.LastIndex(action.Name, "/"+collection+"/")
+			parent := action.Name[:index]
+			id := action.Name[index+len(collection)+2:]
+			err = apiHubRequest(actionCtx, http.MethodPost, apiHubUrl()+"/v1/"+parent+"/"+collection+"?"+action.Kind+"Id="+id, action.Resource, flags.Token)
+		case SyncActionUpdate:
+			fmt.Println("Updating " + action.Kind + " " + action.Name + "...")
+			err = apiHubRequest(actionCtx, http.MethodPatch, apiHubUrl()+"/v1/"+action.Name+"?updateMask="+strings.Join(action.Fields, ","), action.Resource, flags.Token)
+		case SyncActionDelete:
+			fmt.Println("Deleting " + action.Kind + " " + action.Name + "...")
+			deleteUrl := apiHubUrl() + "/v1/" + action.Name
+			if action.Kind == "api" || action.Kind == "version" {
+				deleteUrl = deleteUrl + "?force=true"
+			}
+			err = apiHubRequest(actionCtx, http.MethodDelete, deleteUrl, nil, flags.Token)
+		}
+		actionSpan.end(err)
+
+		if err != nil {
+			fmt.Println("  >> Error: " + err.Error())
+			errs = append(errs, &SyncActionError{Action: action, Err: err})
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
-func deleteApiHubDeployment(deployment string, token string) {
-	req, _ := http.NewRequest(http.MethodDelete, "https://apihub.googleapis.com/v1/"+deployment, nil)
-	req.Header.Add("Authorization", "Bearer "+token)
+// ApiHubRequestError is returned when API Hub responds with an error status.
+type ApiHubRequestError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *ApiHubRequestError) Error() string {
+	return e.Method + " " + e.Url + ": " + e.Status + " " + e.Body
+}
 
-	_, err := http.DefaultClient.Do(req)
+// apiHubRequest sends a request to API Hub and returns an error with the response body if it did not succeed.
+func apiHubRequest(ctx context.Context, method string, url string, body []byte, token string) error {
+	_, err := apiHubDo(ctx, method, url, body, token)
+	return err
+}
+
+// apiHubGet gets a resource from API Hub and unmarshals it into result.
+func apiHubGet(ctx context.Context, url string, token string, result any) error {
+	body, err := apiHubDo(ctx, http.MethodGet, url, nil, token)
 	if err != nil {
-		fmt.Println("Error deleting API Hub Deployment: " + err.Error())
+		return err
+	}
+	return json.Unmarshal(body, result)
+}
+
+func apiHubDo(ctx context.Context, method string, url string, body []byte, token string) ([]byte, error) {
+	r, _ := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
+	r.Header.Add("Content-Type", "application/json")
+	r.Header.Add("Authorization", "Bearer "+token)
+
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return nil, &ApiHubRequestError{Method: method, Url: url, StatusCode: resp.StatusCode, Status: resp.Status, Body: string(respBody)}
+	}
+
+	return respBody, nil
+}
+
+func getApiHubApiVersions(ctx context.Context, api string, token string) (HubApiVersions, error) {
+	var versions HubApiVersions
+
+	err := apiHubListPages(ctx, apiHubUrl()+"/v1/"+api+"/versions", token, func(body []byte) string {
+		var page HubApiVersions
+		json.Unmarshal(body, &page)
+		versions.Versions = append(versions.Versions, page.Versions...)
+		return page.NextPageToken
+	})
+
+	return versions, err
+}
+
+func getApiHubApiVersionSpecs(ctx context.Context, version string, token string) (HubApiVersionSpecs, error) {
+	var specs HubApiVersionSpecs
+
+	err := apiHubListPages(ctx, apiHubUrl()+"/v1/"+version+"/specs", token, func(body []byte) string {
+		var page HubApiVersionSpecs
+		json.Unmarshal(body, &page)
+		specs.Specs = append(specs.Specs, page.Specs...)
+		return page.NextPageToken
+	})
+
+	return specs, err
+}
+
+// apiHubListPages gets every page of an API Hub list call. The page function reads a page and returns its nextPageToken.
+func apiHubListPages(ctx context.Context, listUrl string, token string, page func(body []byte) string) error {
+	pageToken := ""
+	seen := map[string]bool{}
+	for {
+		pageUrl := listUrl
+		if pageToken != "" {
+			pageUrl = listUrl + "?" + url.Values{"pageToken": {pageToken}}.Encode()
+		}
+
+		body, err := apiHubDo(ctx, http.MethodGet, pageUrl, nil, token)
+		if err != nil {
+			return err
+		}
+
+		pageToken = page(body)
+		if pageToken == "" {
+			return nil
+		}
+		// a repeated token would read the same pages forever
+		if seen[pageToken] {
+			return errors.New("API Hub returned the page token " + pageToken + " twice for " + listUrl)
+		}
+		seen[pageToken] = true
+	}
+}
+
+func getApiHubApiVersionSpecContents(ctx context.Context, spec string, token string) (HubContents, error) {
+	var contents HubContents
+
+	body, err := apiHubDo(ctx, http.MethodGet, apiHubUrl()+"/v1/"+spec+":contents", nil, token)
+	if err != nil {
+		return contents, err
+	}
+	if err := json.Unmarshal(body, &contents); err != nil {
+		return contents, errors.New("could not parse the contents of " + spec + ": " + err.Error())
+	}
+
+	return contents, nil
+}
+
+func apiHubResourceId(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func setApiHubSourceAttribute(flags *ApigeeFlags, attributes map[string]HubAttributeValues, attributeId string, value string) {
+	if value == "" {
+		return
+	}
+
+	attribute := "projects/" + flags.Project + "/locations/" + flags.Region + "/attributes/" + attributeId
+	values, ok := attributes[attribute]
+	if !ok {
+		values = HubAttributeValues{Attribute: attribute, StringValues: &HubStringValues{Values: []string{}}}
+	}
+	if !slices.Contains(values.StringValues.Values, value) {
+		values.StringValues.Values = append(values.StringValues.Values, value)
+	}
+	attributes[attribute] = values
+}
+
+// apiHubOwned returns true if the resource was tagged by apimsync with the given source attribute.
+func apiHubOwned(attributes map[string]HubAttributeValues, attributeId string) bool {
+	for attribute := range attributes {
+		if apiHubResourceId(attribute) == attributeId {
+			return true
+		}
+	}
+	return false
+}
+
+// apiHubPrunable returns true if pruning may remove the resource, which apimsync must have tagged with the given
+// source attribute. If pruning is limited to a source platform, all sources of the resource must be that platform,
+// so that an API that is still deployed from another source is kept.
+func apiHubPrunable(flags *ApigeeFlags, attributes map[string]HubAttributeValues, attributeId string) bool {
+	if !apiHubOwned(attributes, attributeId) {
+		return false
+	}
+	if flags.PruneSource == "" {
+		return true
+	}
+	platformId := sourcePlatformId(flags.PruneSource)
+	for attribute, values := range attributes {
+		if apiHubResourceId(attribute) != attributeId {
+			continue
+		}
+		if values.StringValues == nil || len(values.StringValues.Values) == 0 {
+			return false
+		}
+		for _, value := range values.StringValues.Values {
+			if value != platformId {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// apiHubAttributesChanged compares only the attributes apimsync sets, other attributes are left alone.
+func apiHubAttributesChanged(desired map[string]HubAttributeValues, current map[string]HubAttributeValues) bool {
+	for attribute, desiredValues := range desired {
+		var currentValues []string
+		for currentAttribute, values := range current {
+			if apiHubResourceId(currentAttribute) == apiHubResourceId(attribute) && values.StringValues != nil {
+				currentValues = values.StringValues.Values
+			}
+		}
+
+		desiredSorted := slices.Clone(desiredValues.StringValues.Values)
+		currentSorted := slices.Clone(currentValues)
+		slices.Sort(desiredSorted)
+		slices.Sort(currentSorted)
+		if !slices.Equal(desiredSorted, currentSorted) {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeApiHubAttributes(current map[string]HubAttributeValues, desired map[string]HubAttributeValues) map[string]HubAttributeValues {
+	result := map[string]HubAttributeValues{}
+	for attribute, values := range current {
+		if _, ok := desired[attribute]; !ok && !apiHubOwned(desired, apiHubResourceId(attribute)) {
+			result[attribute] = values
+		}
+	}
+	for attribute, values := range desired {
+		result[attribute] = values
+	}
+	return result
+}
+
+// ensureApiHubAttributes creates the source attributes apimsync tags its resources with, if they don't exist yet.
+func ensureApiHubAttributes(ctx context.Context, flags *ApigeeFlags) {
+	attributes := []struct {
+		id          string
+		scope       string
+		displayName string
+	}{
+		{apiHubApiSourcePlatformAttribute, "API", "Source platform (apimsync)"},
+		{apiHubApiSourceUriAttribute, "API", "Source resource URI (apimsync)"},
+		{apiHubDeploymentSourcePlatformAttribute, "DEPLOYMENT", "Source platform (apimsync)"},
+		{apiHubDeploymentSourceUriAttribute, "DEPLOYMENT", "Source resource URI (apimsync)"},
+	}
+
+	attributesUrl := apiHubUrl() + "/v1/projects/" + flags.Project + "/locations/" + flags.Region + "/attributes"
+	for _, attribute := range attributes {
+		if apiHubRequest(ctx, http.MethodGet, attributesUrl+"/"+attribute.id, nil, flags.Token) == nil {
+			continue
+		}
+
+		body, _ := json.Marshal(map[string]any{
+			"displayName": attribute.displayName,
+			"description": "Set by apimsync to track which source platform resource this was synced from.",
+			"scope":       attribute.scope,
+			"dataType":    "STRING",
+			"cardinality": 20,
+		})
+		fmt.Println("Creating attribute " + attribute.id + "...")
+		err := apiHubRequest(ctx, http.MethodPost, attributesUrl+"?attributeId="+attribute.id, body, flags.Token)
+		if err != nil {
+			fmt.Println("  >> Error creating attribute " + attribute.id + ": " + err.Error())
+		}
 	}
 }
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// The scopes of the web server operations. The "*" scope grants all scopes.
+const (
+	// ScopeRead allows reading the status, jobs, sync state, catalog and metrics.
+	ScopeRead = "read"
+	// ScopeSync allows starting offramps, onramps, syncs and plans, which call the platforms with the stored credentials.
+	ScopeSync = "sync"
+)
+
+// Principal is who made a request, and the scopes they were granted.
+type Principal struct {
+	Name   string
+	Scheme string
+	Scopes []string
+}
+
+func (p Principal) hasScopes(scopes []string) bool {
+	if slices.Contains(p.Scopes, "*") {
+		return true
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(p.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Authenticator checks the credentials of a request for one security scheme of the web server.
+type Authenticator interface {
+	// Scheme is the name of the security scheme in the OpenAPI doc.
+	Scheme() string
+	SecurityScheme() *huma.SecurityScheme
+	// Authenticate returns errNoCredentials if the request has no credentials for this scheme.
+	Authenticate(ctx huma.Context) (Principal, error)
+}
+
+var errNoCredentials = errors.New("no credentials")
+
+// WebServerAuth checks the credentials and scopes of the web server operations.
+type WebServerAuth struct {
+	Authenticators []Authenticator
+}
+
+// newWebServerAuth configures the authenticators from env variables:
+//
+//	APIMSYNC_API_KEYS           static API keys and their scopes, e.g. "key1=read,sync;key2=read"
+//	APIMSYNC_GOOGLE_AUDIENCE    the audience of Google-signed OIDC ID tokens, e.g. the Cloud Run URL
+//	APIMSYNC_GOOGLE_PRINCIPALS  the emails allowed in Google ID tokens and their scopes, e.g. "scheduler@project.iam.gserviceaccount.com=sync"
+//	APIMSYNC_JWT_JWKS_URL       the JWKS URL to validate other JWTs with, their scopes are taken from the scope or scp claim
+//	APIMSYNC_JWT_ISSUER         the accepted JWT issuers, comma separated, required with a JWKS URL
+//	APIMSYNC_JWT_AUDIENCE       the accepted JWT audiences, comma separated, required with a JWKS URL
+//	APIMSYNC_AUTH               none to run without authentication, like noAuth
+//
+// One of them has to be configured, unless noAuth is true, so that a deployment missing its settings isn't open.
+func newWebServerAuth(noAuth bool) (*WebServerAuth, error) {
+	auth := &WebServerAuth{}
+	noAuth = noAuth || os.Getenv("APIMSYNC_AUTH") == "none"
+
+	if keys := os.Getenv("APIMSYNC_API_KEYS"); keys != "" {
+		auth.Authenticators = append(auth.Authenticators, &apiKeyAuthenticator{keys: parseScopeMap(keys)})
+	}
+
+	if audience := os.Getenv("APIMSYNC_GOOGLE_AUDIENCE"); audience != "" {
+		auth.Authenticators = append(auth.Authenticators, &googleOidcAuthenticator{
+			validator:  &JwtValidator{JwksUrl: googleCertsUrl(), Issuers: []string{"https://accounts.google.com", "accounts.google.com"}, Audiences: splitList(audience)},
+			principals: parseScopeMap(os.Getenv("APIMSYNC_GOOGLE_PRINCIPALS")),
+		})
+	}
+
+	if jwksUrl := os.Getenv("APIMSYNC_JWT_JWKS_URL"); jwksUrl != "" {
+		// the keys of an issuer usually sign the tokens of all its clients, so both have to be checked
+		issuers, audiences := splitList(os.Getenv("APIMSYNC_JWT_ISSUER")), splitList(os.Getenv("APIMSYNC_JWT_AUDIENCE"))
+		if len(issuers) == 0 || len(audiences) == 0 {
+			return nil, errors.New("APIMSYNC_JWT_ISSUER and APIMSYNC_JWT_AUDIENCE are required with APIMSYNC_JWT_JWKS_URL")
+		}
+		auth.Authenticators = append(auth.Authenticators, &jwtAuthenticator{
+			validator: &JwtValidator{JwksUrl: jwksUrl, Issuers: issuers, Audiences: audiences},
+		})
+	}
+
+	if noAuth && len(auth.Authenticators) > 0 {
+		return nil, errors.New("authentication is configured, but turned off with --noauth or APIMSYNC_AUTH=none")
+	}
+	if !noAuth && len(auth.Authenticators) == 0 {
+		return nil, errors.New("no authentication configured, set APIMSYNC_API_KEYS, APIMSYNC_GOOGLE_AUDIENCE or APIMSYNC_JWT_JWKS_URL, or run without it with --noauth or APIMSYNC_AUTH=none")
+	}
+	return auth, nil
+}
+
+// register declares the security schemes in the OpenAPI doc and adds the middleware that checks them.
+func (a *WebServerAuth) register(api huma.API) {
+	if len(a.Authenticators) == 0 {
+		fmt.Println("Authentication is turned off, the web server endpoints are open to anyone who can reach them.")
+		return
+	}
+
+	openApi := api.OpenAPI()
+	if openApi.Components == nil {
+		openApi.Components = &huma.Components{}
+	}
+	if openApi.Components.SecuritySchemes == nil {
+		openApi.Components.SecuritySchemes = map[string]*huma.SecurityScheme{}
+	}
+	for _, authenticator := range a.Authenticators {
+		openApi.Components.SecuritySchemes[authenticator.Scheme()] = authenticator.SecurityScheme()
+	}
+
+	api.UseMiddleware(a.middleware(api))
+}
+
+// require makes an operation require the scopes, with any of the configured security schemes.
+func (a *WebServerAuth) require(scopes ...string) func(o *huma.Operation) {
+	return func(o *huma.Operation) {
+		for _, authenticator := range a.Authenticators {
+			o.Security = append(o.Security, map[string][]string{authenticator.Scheme(): scopes})
+		}
+	}
+}
+
+func (a *WebServerAuth) middleware(api huma.API) func(ctx huma.Context, next func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		operation := ctx.Operation()
+		if operation == nil || len(operation.Security) == 0 {
+			next(ctx)
+			return
+		}
+
+		errs := []error{}
+		var forbidden *Principal
+		for _, authenticator := range a.Authenticators {
+			principal, err := authenticator.Authenticate(ctx)
+			if err == errNoCredentials {
+				continue
+			} else if err != nil {
+				errs = append(errs, errors.New(authenticator.Scheme()+": "+err.Error()))
+				continue
+			}
+
+			scopes, ok := requiredScopes(operation, authenticator.Scheme())
+			if ok && principal.hasScopes(scopes) {
+				next(ctx)
+				return
+			}
+			forbidden = &principal
+		}
+
+		if forbidden != nil {
+			huma.WriteErr(api, ctx, http.StatusForbidden, forbidden.Name+" is not allowed to call "+operation.OperationID+".")
+		} else {
+			huma.WriteErr(api, ctx, http.StatusUnauthorized, "Missing or invalid credentials.", errs...)
+		}
+	}
+}
+
+// requiredScopes returns the scopes an operation requires with a security scheme, or false if the scheme isn't accepted.
+func requiredScopes(operation *huma.Operation, scheme string) ([]string, bool) {
+	for _, requirement := range operation.Security {
+		if scopes, ok := requirement[scheme]; ok {
+			return scopes, true
+		}
+	}
+	return nil, false
+}
+
+// apiKeyAuthenticator checks static API keys sent in the X-API-Key header.
+type apiKeyAuthenticator struct {
+	keys map[string][]string
+}
+
+func (a *apiKeyAuthenticator) Scheme() string {
+	return "apiKey"
+}
+
+func (a *apiKeyAuthenticator) SecurityScheme() *huma.SecurityScheme {
+	return &huma.SecurityScheme{Type: "apiKey", In: "header", Name: "X-API-Key", Description: "A static API key, configured in APIMSYNC_API_KEYS."}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(ctx huma.Context) (Principal, error) {
+	key := ctx.Header("X-API-Key")
+	if key == "" {
+		return Principal{}, errNoCredentials
+	}
+	for configured, scopes := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(configured)) == 1 {
+			return Principal{Name: "API key " + configured[:min(4, len(configured))] + "...", Scheme: a.Scheme(), Scopes: scopes}, nil
+		}
+	}
+	return Principal{}, errors.New("invalid API key")
+}
+
+// googleOidcAuthenticator checks Google-signed OIDC ID tokens, like the ones Cloud Scheduler sends.
+type googleOidcAuthenticator struct {
+	validator  *JwtValidator
+	principals map[string][]string
+}
+
+func (a *googleOidcAuthenticator) Scheme() string {
+	return "googleOidc"
+}
+
+func (a *googleOidcAuthenticator) SecurityScheme() *huma.SecurityScheme {
+	return &huma.SecurityScheme{Type: "openIdConnect", OpenIDConnectURL: "https://accounts.google.com/.well-known/openid-configuration", Description: "A Google-signed ID token as bearer token, e.g. from Cloud Scheduler, for a principal in APIMSYNC_GOOGLE_PRINCIPALS."}
+}
+
+func (a *googleOidcAuthenticator) Authenticate(ctx huma.Context) (Principal, error) {
+	token := bearerToken(ctx)
+	if token == "" {
+		return Principal{}, errNoCredentials
+	}
+	claims, err := a.validator.Validate(token)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	email := claims.String("email")
+	if email == "" || claims["email_verified"] != true {
+		return Principal{}, errors.New("token has no verified email")
+	}
+	scopes, ok := a.principals[email]
+	if !ok {
+		scopes = a.principals["*"]
+	}
+	return Principal{Name: email, Scheme: a.Scheme(), Scopes: scopes}, nil
+}
+
+// jwtAuthenticator checks JWTs signed with the keys of a JWKS URL, with the scopes in their scope or scp claim.
+type jwtAuthenticator struct {
+	validator *JwtValidator
+}
+
+func (a *jwtAuthenticator) Scheme() string {
+	return "jwt"
+}
+
+func (a *jwtAuthenticator) SecurityScheme() *huma.SecurityScheme {
+	return &huma.SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "A JWT signed with a key of APIMSYNC_JWT_JWKS_URL, with the scopes in its scope or scp claim."}
+}
+
+func (a *jwtAuthenticator) Authenticate(ctx huma.Context) (Principal, error) {
+	token := bearerToken(ctx)
+	if token == "" {
+		return Principal{}, errNoCredentials
+	}
+	claims, err := a.validator.Validate(token)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	scopes := append(claims.Strings("scope"), claims.Strings("scp")...)
+	return Principal{Name: claims.String("sub"), Scheme: a.Scheme(), Scopes: scopes}, nil
+}
+
+func bearerToken(ctx huma.Context) string {
+	header := ctx.Header("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// parseScopeMap parses names with their scopes in the form "name1=read,sync;name2=read".
+func parseScopeMap(value string) map[string][]string {
+	result := map[string][]string{}
+	for _, entry := range strings.Split(value, ";") {
+		name, scopes, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if name != "" {
+			result[name] = splitList(scopes)
+		}
+	}
+	return result
+}
+
+// splitList splits a comma separated list and drops empty values.
+func splitList(value string) []string {
+	result := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -2,18 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"net/http"
 	"os"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
 	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2/types"
+	"github.com/leaanthony/clir"
 )
 
 type AwsApis struct {
@@ -50,15 +61,63 @@ type AwsCorsConfiguration struct {
 type AwsFlags struct {
 	AccessKey    string `name:"accessKey" description:"The AWS access key to use to authenticate with AWS."`
 	AccessSecret string `name:"accessSecret" description:"The AWS secret key to use to authenticate with AWS."`
-	Region       string `name:"region" description:"The AWS region of the API Gateway."`
+	Region       string `name:"region" description:"The AWS region of the API Gateway, several separated by commas, or * for all enabled regions."`
 	ApiName      string `name:"api" description:"A specific Azure API Management API."`
 	OnlyNew      bool   `name:"onlyNew" description:"If only newly discovered APIs should be processed."`
+	Prune        bool   `name:"prune" description:"If local APIs that no longer exist in AWS should be removed."`
+	Workspace    string `name:"workspace" description:"The workspace the files are in, a directory or e.g. s3://bucket/prefix, default is APIMSYNC_WORKSPACE or src/main."`
+}
+
+type awsPlatform struct {
+	flags AwsFlags
+}
+
+func init() {
+	registerPlatform(PlatformRegistration{
+		Name:        "aws",
+		Description: "AWS API Gateway",
+		New:         newAwsPlatform,
+		Commands:    awsCommands,
+		Source:      true,
+		PlatformId:  "aws-api-gateway",
+	})
+}
+
+func newAwsPlatform(options PlatformOptions) Platform {
+	flags := AwsFlags{Region: os.Getenv("AWS_REGION"), AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"), AccessSecret: os.Getenv("AWS_SECRET_ACCESS_KEY")}
+	flags.ApiName = options.ApiName
+	flags.OnlyNew = options.OnlyNew
+	flags.Prune = options.Prune
+	flags.Workspace = options.Workspace
+	return &awsPlatform{flags: flags}
+}
+
+func (p *awsPlatform) Status() PlatformStatus {
+	return awsStatus(&p.flags)
+}
+
+func (p *awsPlatform) Export(ctx context.Context) (StageResult, error) {
+	return awsExport(ctx, &p.flags)
+}
+
+func (p *awsPlatform) Offramp(ctx context.Context) (StageResult, error) {
+	return awsOfframp(ctx, &p.flags)
+}
+
+func awsCommands(cli *clir.Cli) {
+	awsCommand := cli.NewSubCommand("aws", "Functions for AWS API Gateway.")
+	awsApisCommand := awsCommand.NewSubCommand("apis", "Functions for AWS API Gateway API resources.")
+	awsApisCommand.NewSubCommandFunction("export", "Exports AWS API Gateway APIs.", locked("aws apis export", snapshotted("aws apis export", awsExportMin)))
+	awsApisCommand.NewSubCommandFunction("offramp", "Offramp AWS API Gateway APIs.", locked("aws apis offramp", snapshotted("aws apis offramp", awsOfframpMin)))
+	awsApisCommand.NewSubCommandFunction("cleanlocal", "Removes all exported AWS APIs from local storage.", locked("aws apis cleanlocal", awsCleanLocal))
 }
 
 func awsCleanLocal(flags *AwsFlags) error {
-	var baseDir = "src/main/aws"
-	os.RemoveAll(baseDir)
-	return nil
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+	return storage.RemoveAll("aws")
 }
 
 func awsStatus(flags *AwsFlags) PlatformStatus {
@@ -74,162 +133,370 @@ func awsStatus(flags *AwsFlags) PlatformStatus {
 		os.Setenv("AWS_SECRET_ACCESS_KEY", flags.AccessSecret)
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(flags.Region))
+	instances, err := awsInstances(context.Background(), flags)
 	if err != nil {
-		log.Fatal(err)
+		status.Connected = false
+		status.Message = err.Error()
+		return status
 	}
+	for _, instance := range instances {
+		client, err := newAwsClient(context.Background(), instance.Region)
+		if err != nil {
+			status.Connected = false
+			status.Apis = 0
+			status.Message = "Could not create AWS client: " + err.Error()
+			return status
+		}
+
+		apis, err := getAwsApis(context.Background(), client)
+		if err != nil {
+			status.Connected = false
+			status.Apis = 0
+			status.Message = err.Error()
+			return status
+		}
+		status.Apis += len(apis.Items)
+	}
+
+	status.Connected = true
+	if len(instances) == 1 && instances[0].Instance == "" {
+		status.Message = "Connected to Aws, " + strconv.Itoa(status.Apis) + " API(s) found ."
+	} else {
+		status.Message = "Connected to Aws, " + strconv.Itoa(status.Apis) + " API(s) found in " + strconv.Itoa(len(instances)) + " regions."
+	}
+	return status
+}
 
-	client := apigatewayv2.NewFromConfig(cfg)
+// awsInstance is a region that APIs are exported from. Instance namespaces its APIs if several regions
+// are exported, and is empty otherwise.
+type awsInstance struct {
+	Region   string
+	Instance string
+}
 
-	if client != nil {
-		apis, _ := client.GetApis(context.TODO(), &apigatewayv2.GetApisInput{})
+// awsInstances returns the regions to export: the region given, or if several regions are selected, each
+// of them, where * selects all regions that are enabled for the account.
+func awsInstances(ctx context.Context, flags *AwsFlags) ([]awsInstance, error) {
+	names, all, namespaced := sourceInstances(flags.Region)
+	if !namespaced {
+		return []awsInstance{{Region: flags.Region}}, nil
+	}
 
-		if apis != nil {
-			status.Connected = true
-			status.Message = "Connected to Aws, " + strconv.Itoa(len(apis.Items)) + " API(s) found ."
+	if all {
+		regions, err := getAwsRegions(ctx)
+		if err != nil {
+			return nil, errors.New("could not list AWS regions: " + err.Error())
+		}
+		for _, region := range regions {
+			if !slices.Contains(names, region) {
+				names = append(names, region)
+			}
 		}
 	}
 
-	return status
+	instances := []awsInstance{}
+	for _, region := range names {
+		instances = append(instances, awsInstance{Region: region, Instance: sourceInstanceName(region)})
+	}
+	return instances, nil
+}
+
+// awsExportedInstances returns the regions whose exported APIs are offramped, without calling AWS: the
+// region given, or the exported regions that are selected.
+func awsExportedInstances(flags *AwsFlags, storage Storage) []awsInstance {
+	names, all, namespaced := sourceInstances(flags.Region)
+	if !namespaced {
+		return []awsInstance{{Region: flags.Region}}
+	}
+
+	instances := []awsInstance{}
+	for _, instance := range exportedSourceInstances(storage, "aws") {
+		if all || slices.Contains(names, instance) {
+			instances = append(instances, awsInstance{Region: instance, Instance: instance})
+		}
+	}
+	return instances
+}
+
+// getAwsRegions returns the regions that are enabled for the account, with the EC2 DescribeRegions API.
+func getAwsRegions(ctx context.Context) (regions []string, err error) {
+	ctx, span := startSpan(ctx, "aws DescribeRegions")
+	defer func() { span.end(err) }()
+
+	// the regions can be listed in any region
+	region := "us-east-1"
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body := "Action=DescribeRegions&Version=2016-11-15"
+	endpoint := awsEc2Url()
+	if endpoint == "" {
+		endpoint = "https://ec2." + region + ".amazonaws.com"
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	payloadHash := sha256.Sum256([]byte(body))
+	if err := v4.NewSigner().SignHTTP(ctx, credentials, req, hex.EncodeToString(payloadHash[:]), "ec2", region, time.Now()); err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+
+	var result struct {
+		Regions []struct {
+			RegionName string `xml:"regionName"`
+		} `xml:"regionInfo>item"`
+	}
+	if err := xml.Unmarshal(responseBody, &result); err != nil {
+		return nil, errors.New("could not parse regions: " + err.Error())
+	}
+	for _, region := range result.Regions {
+		regions = append(regions, region.RegionName)
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// newAwsClient creates an API Gateway v2 client for the region, using the custom endpoint if one is configured.
+func newAwsClient(ctx context.Context, region string) (*apigatewayv2.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	return apigatewayv2.NewFromConfig(cfg, func(o *apigatewayv2.Options) {
+		// the shared client retries, rate limits, measures and traces the calls, instead of the SDK
+		o.HTTPClient = httpClient
+		o.Retryer = aws.NopRetryer{}
+		if endpoint := awsUrl(); endpoint != "" {
+			o.BaseEndpoint = &endpoint
+		}
+	}), nil
+}
+
+// getAwsApis gets all APIs in the region, following NextToken until all pages are read.
+func getAwsApis(ctx context.Context, client *apigatewayv2.Client) (apis *apigatewayv2.GetApisOutput, err error) {
+	ctx, span := startSpan(ctx, "aws GetApis")
+	defer func() { span.end(err) }()
+
+	apis = &apigatewayv2.GetApisOutput{}
+	input := &apigatewayv2.GetApisInput{}
+	seen := map[string]bool{}
+	for {
+		page, err := client.GetApis(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		apis.Items = append(apis.Items, page.Items...)
+		if page.NextToken == nil || *page.NextToken == "" {
+			break
+		}
+		// a repeated token would read the same pages forever
+		if seen[*page.NextToken] {
+			return nil, errors.New("AWS returned the next token " + *page.NextToken + " twice")
+		}
+		seen[*page.NextToken] = true
+		input.NextToken = page.NextToken
+	}
+
+	return apis, nil
 }
 
 func awsExportMin(flags *AwsFlags) error {
-	awsExport(flags)
-	return nil
+	return printStageResult(awsExport(context.Background(), flags))
 }
 
-func awsExport(flags *AwsFlags) ([]string, error) {
-	var baseDir = "src/main/aws/apiproxies"
+func awsExport(ctx context.Context, flags *AwsFlags) (result StageResult, err error) {
+	ctx, span := startStage(ctx, "export", "aws")
+	defer func() { span.endStage(result, err) }()
+
+	result = newStageResult("export", "aws")
 	if flags.Region == "" {
 		flags.Region = os.Getenv("AWS_REGION")
 		if flags.Region == "" {
-			fmt.Println("No region given, cannot export AWS APIs.")
-			return nil, nil
+			return result, errors.New("no region given, cannot export AWS APIs")
 		}
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(flags.Region))
+	storage, err := workspaceStorage(flags.Workspace)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	client := apigatewayv2.NewFromConfig(cfg)
-	apiNames := []string{}
-
-	if client != nil {
-		fmt.Println("Exporting AWS APIs for region " + flags.Region + "...")
-
-		apis, _ := client.GetApis(context.TODO(), &apigatewayv2.GetApisInput{})
-		if apis != nil {
-			if len(apis.Items) > 0 {
-				for _, api := range apis.Items {
-					if flags.ApiName == "" || flags.ApiName == *api.Name {
-						fmt.Println("Exporting " + *api.Name + "...")
-						outputType := "JSON"
-						specType := "OAS30"
-						apiExport, exportErr := client.ExportApi(context.TODO(), &apigatewayv2.ExportApiInput{
-							ApiId:         api.ApiId,
-							OutputType:    &outputType,
-							Specification: &specType,
-						})
-
-						if exportErr != nil {
-							fmt.Println(exportErr)
-						}
+		return result, err
+	}
+	instances, err := awsInstances(ctx, flags)
+	if err != nil {
+		return result, err
+	}
 
-						bytes, _ := json.MarshalIndent(api, "", "  ")
-						newName := strings.ReplaceAll(strings.ToLower(*api.Name), " ", "-")
+	exported := []string{}
+	for _, instance := range instances {
+		if err := exportAwsInstance(ctx, flags, storage, instance, &result); err != nil {
+			return result, err
+		}
+		exported = append(exported, instance.Instance)
+	}
 
-						var re = regexp.MustCompile(`(-v\d+)$`)
-						newName2 := re.ReplaceAllString(newName, "")
+	if flags.Prune && flags.ApiName == "" {
+		for _, removed := range pruneSourceInstances(storage, "aws", exported) {
+			fmt.Println("Removed " + removed + ", its region is no longer exported.")
+		}
+	}
 
-						_, fileExistsErr := os.Open(baseDir + "/" + newName2 + "/" + newName + ".json")
+	return result, result.Err()
+}
 
-						if (flags.OnlyNew && fileExistsErr != nil) || !flags.OnlyNew {
-							os.MkdirAll(baseDir+"/"+newName2, 0755)
-							writeError := os.WriteFile(baseDir+"/"+newName2+"/"+newName+".json", bytes, 0644)
-							if writeError != nil {
-								fmt.Println(writeError)
-							}
-							if apiExport != nil && apiExport.Body != nil {
-								os.WriteFile(baseDir+"/"+newName2+"/"+newName+"-oas.json", apiExport.Body, 0644)
-							}
+// exportAwsInstance exports the APIs of a region, and records the result of each API.
+func exportAwsInstance(ctx context.Context, flags *AwsFlags, storage Storage, instance awsInstance, result *StageResult) error {
+	baseDir := sourceInstanceDir("aws", instance.Instance)
+	client, err := newAwsClient(ctx, instance.Region)
+	if err != nil {
+		return errors.New("could not create AWS client: " + err.Error())
+	}
 
-							apiNames = append(apiNames, newName)
-						}
-					}
+	fmt.Println("Exporting AWS APIs for region " + instance.Region + "...")
+
+	apis, err := getAwsApis(ctx, client)
+	if err != nil {
+		return errors.New("could not list AWS APIs of region " + instance.Region + ": " + err.Error())
+	}
+	if len(apis.Items) == 0 {
+		fmt.Println("No AWS APIs found in region " + instance.Region + ".")
+	}
+
+	currentApis := map[string]bool{}
+	for _, api := range apis.Items {
+		if (flags.ApiName == "" || flags.ApiName == aws.ToString(api.Name)) && apiIncluded(aws.ToString(api.Name)) {
+			fmt.Println("Exporting " + aws.ToString(api.Name) + "...")
+			newName := strings.ReplaceAll(strings.ToLower(aws.ToString(api.Name)), " ", "-")
+
+			var re = regexp.MustCompile(`(-v\d+)$`)
+			newName2 := re.ReplaceAllString(newName, "")
+
+			currentApis[newName2+"/"+newName] = true
+			_, fileExistsErr := storage.Stat(baseDir + "/" + newName2 + "/" + newName + ".json")
+
+			if (flags.OnlyNew && fileExistsErr != nil) || !flags.OnlyNew {
+				name := instanceApiName(instance.Instance, newName)
+				apiCtx, apiSpan := result.startApi(ctx, name)
+				err := writeAwsApi(apiCtx, client, storage, baseDir+"/"+newName2, newName, api)
+				apiSpan.end(err)
+				if err != nil {
+					result.fail(name, err)
+				} else {
+					result.ok(name)
 				}
-			} else {
-				fmt.Println("No AWS APIs found in region " + flags.Region + ", cannot export APIs.")
-				return nil, nil
 			}
-		} else {
-			fmt.Println("No valid APIs found in region " + flags.Region + ", cannot export APIs.")
-			return nil, nil
 		}
-	} else {
-		fmt.Println("AWS client could not be created, cannot export APIs.")
-		return nil, nil
 	}
 
-	return apiNames, nil
+	if flags.Prune && flags.ApiName == "" {
+		for _, removed := range pruneLocalApis(storage, baseDir, currentApis) {
+			fmt.Println("Removed " + removed + ", it no longer exists in AWS.")
+		}
+	}
+
+	return nil
+}
+
+// writeAwsApi writes an AWS API and its exported OpenAPI spec to dir.
+func writeAwsApi(ctx context.Context, client *apigatewayv2.Client, storage Storage, dir string, name string, api types.Api) error {
+	outputType := "JSON"
+	specType := "OAS30"
+	exportCtx, span := startSpan(ctx, "aws ExportApi", "aws.apigateway.api_id", aws.ToString(api.ApiId))
+	apiExport, err := client.ExportApi(exportCtx, &apigatewayv2.ExportApiInput{
+		ApiId:         api.ApiId,
+		OutputType:    &outputType,
+		Specification: &specType,
+	})
+	span.end(err)
+	if err != nil {
+		return errors.New("could not export spec: " + err.Error())
+	}
+
+	bytes, _ := json.MarshalIndent(api, "", "  ")
+	if err := storage.WriteFile(dir+"/"+name+".json", bytes); err != nil {
+		return err
+	}
+	if apiExport.Body != nil {
+		return storage.WriteFile(dir+"/"+name+"-oas.json", apiExport.Body)
+	}
+
+	return nil
 }
 
-func awsOfframp(flags *AwsFlags) error {
+func awsOfframpMin(flags *AwsFlags) error {
+	return printStageResult(awsOfframp(context.Background(), flags))
+}
 
-	awsBaseDir := "src/main/aws/apiproxies"
-	baseDir := "src/main/general/apiproxies"
+func awsOfframp(ctx context.Context, flags *AwsFlags) (result StageResult, err error) {
+	ctx, span := startStage(ctx, "offramp", "aws")
+	defer func() { span.endStage(result, err) }()
 
-	entries, err := os.ReadDir(awsBaseDir)
+	result = newStageResult("offramp", "aws")
+	if flags.Region == "" {
+		flags.Region = os.Getenv("AWS_REGION")
+	}
+
+	storage, err := workspaceStorage(flags.Workspace)
 	if err != nil {
-		log.Fatal(err)
+		return result, err
+	}
+	instances := awsExportedInstances(flags, storage)
+	if len(instances) == 0 {
+		return result, errors.New("no exported AWS regions found, cannot offramp AWS APIs")
+	}
+	instanceEntries := [][]fs.DirEntry{}
+	for _, instance := range instances {
+		entries, err := storage.ReadDir(sourceInstanceDir("aws", instance.Instance))
+		if err != nil {
+			return result, errors.New("could not read exported AWS APIs: " + err.Error())
+		}
+		instanceEntries = append(instanceEntries, entries)
 	}
 
 	fmt.Println("Offramping AWS API Gateway APIs to general...")
 
-	for _, e := range entries {
-		if flags.ApiName == "" || flags.ApiName == e.Name() {
-			fmt.Println(e.Name())
-
-			// read all files
-			fileEntries, _ := os.ReadDir(awsBaseDir + "/" + e.Name())
-			for _, f := range fileEntries {
-				if !strings.HasSuffix(f.Name(), "-oas.json") && !strings.HasSuffix(f.Name(), "-oas-definition.json") {
-					var awsApi types.Api
-					apiFile, err := os.Open(awsBaseDir + "/" + e.Name() + "/" + f.Name())
-					if err != nil {
-						log.Fatal(err)
-					} else {
-						byteValue, _ := io.ReadAll(apiFile)
-						json.Unmarshal(byteValue, &awsApi)
-					}
-					defer apiFile.Close()
-
-					if *awsApi.Name != "" {
-						var generalApi GeneralApi
-						baseName := strings.ReplaceAll(strings.ToLower(*awsApi.Name), " ", "-")
-						generalApi.Name = baseName + "-aws"
-						generalApi.DisplayName = *awsApi.Name
-						generalApi.Description = *awsApi.Description
-						generalApi.Version = *awsApi.Version
-						generalApi.GatewayUrl = *awsApi.ApiEndpoint
-						generalApi.PlatformId = "aws-api-gateway"
-						generalApi.PlatformName = "AWS API Gateway"
-						generalApi.PlatformResourceUri = "https://" + flags.Region + ".console.aws.amazon.com/apigateway/main/apis?api=" + *awsApi.ApiId
-
-						bytes, _ := json.MarshalIndent(generalApi, "", "  ")
-						//os.RemoveAll(baseDir + "/" + generalApi.Name)
-						os.MkdirAll(baseDir+"/"+e.Name(), 0755)
-
-						//os.WriteFile(baseDir+"/"+e.Name()+"/"+e.Name()+".json", bytes, 0644)
-						writeGeneralApi(e.Name(), generalApi)
-						os.WriteFile(baseDir+"/"+e.Name()+"/"+generalApi.Name+".json", bytes, 0644)
-
-						schemaFile, err := os.Open(awsBaseDir + "/" + e.Name() + "/" + baseName + "-oas.json")
-						if err == nil {
-							// we have an api spec, copy it over
-							byteValue, _ := io.ReadAll(schemaFile)
-							os.WriteFile(baseDir+"/"+e.Name()+"/"+generalApi.Name+"-oas.json", byteValue, 0644)
+	if flags.Prune && flags.ApiName == "" {
+		for _, removed := range pruneGeneralApis(storage, "aws") {
+			fmt.Println("Removed general API " + removed + ", it no longer exists in AWS.")
+		}
+	}
+
+	for i, instance := range instances {
+		awsBaseDir := sourceInstanceDir("aws", instance.Instance)
+		for _, e := range instanceEntries[i] {
+			if flags.ApiName == "" || flags.ApiName == e.Name() {
+				fmt.Println(e.Name())
+
+				// read all files
+				fileEntries, _ := storage.ReadDir(awsBaseDir + "/" + e.Name())
+				for _, f := range fileEntries {
+					if !strings.HasSuffix(f.Name(), "-oas.json") && !strings.HasSuffix(f.Name(), "-oas-definition.json") {
+						name := instanceApiName(instance.Instance, strings.TrimSuffix(f.Name(), ".json"))
+						_, apiSpan := result.startApi(ctx, name)
+						err := offrampAwsApi(storage, instance, e.Name(), f.Name())
+						apiSpan.end(err)
+						if err != nil {
+							result.fail(name, err)
+						} else {
+							result.ok(name)
 						}
 					}
 				}
@@ -237,5 +504,53 @@ func awsOfframp(flags *AwsFlags) error {
 		}
 	}
 
+	return result, result.Err()
+}
+
+// offrampAwsApi converts the exported AWS API in file to a general API in the general directory dir.
+func offrampAwsApi(storage Storage, instance awsInstance, dir string, file string) error {
+	awsBaseDir := sourceInstanceDir("aws", instance.Instance)
+	baseDir := "general/apiproxies"
+
+	var awsApi types.Api
+	byteValue, err := storage.ReadFile(awsBaseDir + "/" + dir + "/" + file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(byteValue, &awsApi); err != nil {
+		return errors.New("could not parse " + file + ": " + err.Error())
+	}
+
+	if aws.ToString(awsApi.Name) == "" {
+		return errors.New(file + " has no API name")
+	}
+
+	var generalApi GeneralApi
+	baseName := strings.ReplaceAll(strings.ToLower(*awsApi.Name), " ", "-")
+	generalApi.Name = generalPlatformApiName(baseName, instance.Instance, "aws")
+	generalApi.Instance = instance.Instance
+	generalApi.DisplayName = *awsApi.Name
+	generalApi.Description = aws.ToString(awsApi.Description)
+	generalApi.Version = aws.ToString(awsApi.Version)
+	generalApi.GatewayUrl = aws.ToString(awsApi.ApiEndpoint)
+	generalApi.PlatformId = "aws-api-gateway"
+	generalApi.PlatformName = "AWS API Gateway"
+	generalApi.PlatformResourceUri = "https://" + instance.Region + ".console.aws.amazon.com/apigateway/main/apis?api=" + aws.ToString(awsApi.ApiId)
+
+	bytes, _ := json.MarshalIndent(generalApi, "", "  ")
+
+	if err := storage.WriteFile(baseDir+"/"+dir+"/"+generalApi.Name+".json", bytes); err != nil {
+		return err
+	}
+	if err := mergeGeneralApi(storage, dir, generalMergePrecedenceFromEnv()); err != nil {
+		return errors.New("could not merge general API " + dir + ": " + err.Error())
+	}
+
+	schemaBytes, err := storage.ReadFile(awsBaseDir + "/" + dir + "/" + baseName + "-oas.json")
+	if err == nil {
+		// we have an api spec, copy it over
+		return storage.WriteFile(baseDir+"/"+dir+"/"+generalApi.Name+"-oas.json", schemaBytes)
+	}
+
 	return nil
 }
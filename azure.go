@@ -2,16 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/leaanthony/clir"
 	"github.com/tidwall/gjson"
 )
 
@@ -32,7 +38,8 @@ type AzureServiceProperties struct {
 }
 
 type AzureApis struct {
-	Value []AzureApi `json:"value"`
+	Value    []AzureApi `json:"value"`
+	NextLink string     `json:"nextLink"`
 }
 
 type AzureApi struct {
@@ -94,12 +101,62 @@ type AzureTokenResponse struct {
 }
 
 type AzureFlags struct {
-	Subscription  string `name:"subscription" description:"The Azure subscription ID."`
-	ResourceGroup string `name:"resourcegroup" description:"The Azure resource group."`
-	ServiceName   string `name:"name" description:"The Azure API Management service name."`
+	Subscription  string `name:"subscription" description:"The Azure subscription ID, or several separated by commas."`
+	ResourceGroup string `name:"resourcegroup" description:"The Azure resource group, optional if several services are given."`
+	ServiceName   string `name:"name" description:"The Azure API Management service name, several separated by commas, or * for all services in the subscriptions."`
 	Token         string `name:"token" description:"The Azure access token to call Azure with."`
 	ApiName       string `name:"api" description:"A specific Azure API Management API."`
 	OnlyNew       bool   `name:"onlyNew" description:"If only newly discovered APIs should be processed."`
+	Prune         bool   `name:"prune" description:"If local APIs that no longer exist in Azure should be removed."`
+	Workspace     string `name:"workspace" description:"The workspace the files are in, a directory or e.g. s3://bucket/prefix, default is APIMSYNC_WORKSPACE or src/main."`
+}
+
+type azurePlatform struct {
+	flags AzureFlags
+}
+
+func init() {
+	registerPlatform(PlatformRegistration{
+		Name:        "azure",
+		Description: "Azure API Management",
+		New:         newAzurePlatform,
+		Commands:    azureCommands,
+		Source:      true,
+		PlatformId:  "azure-api-management",
+	})
+}
+
+func newAzurePlatform(options PlatformOptions) Platform {
+	flags := AzureFlags{Subscription: os.Getenv("AZURE_SUBSCRIPTION_ID"), ResourceGroup: os.Getenv("AZURE_RESOURCE_GROUP"), ServiceName: os.Getenv("AZURE_SERVICE_NAME")}
+	flags.ApiName = options.ApiName
+	flags.OnlyNew = options.OnlyNew
+	flags.Prune = options.Prune
+	flags.Workspace = options.Workspace
+	return &azurePlatform{flags: flags}
+}
+
+func (p *azurePlatform) Status() PlatformStatus {
+	return azureStatus(&p.flags)
+}
+
+func (p *azurePlatform) Export(ctx context.Context) (StageResult, error) {
+	if err := azureServiceExport(ctx, &p.flags); err != nil {
+		return newStageResult("export", "azure"), err
+	}
+	return azureExport(ctx, &p.flags)
+}
+
+func (p *azurePlatform) Offramp(ctx context.Context) (StageResult, error) {
+	return azureOfframp(ctx, &p.flags)
+}
+
+func azureCommands(cli *clir.Cli) {
+	azureCommand := cli.NewSubCommand("azure", "Functions for Azure API Management.")
+	azureCommand.NewSubCommandFunction("export", "Exports Azure API Management service info.", locked("azure export", snapshotted("azure export", azureServiceExportMin)))
+	azureApisCommand := azureCommand.NewSubCommand("apis", "Functions for Azure API Management API resources.")
+	azureApisCommand.NewSubCommandFunction("export", "Exports Azure API Management APIs.", locked("azure apis export", snapshotted("azure apis export", azureExportMin)))
+	azureApisCommand.NewSubCommandFunction("offramp", "Migrates Azure API Management APIs out to general.", locked("azure apis offramp", snapshotted("azure apis offramp", azureOfframpMin)))
+	azureApisCommand.NewSubCommandFunction("cleanlocal", "Removes all exported Azure APIs from local storage.", locked("azure apis cleanlocal", azureCleanLocal))
 }
 
 func azureStatus(flags *AzureFlags) PlatformStatus {
@@ -109,7 +166,7 @@ func azureStatus(flags *AzureFlags) PlatformStatus {
 		status.Connected = false
 		status.Message = "No subscription given, cannot connect to Azure API Management."
 		return status
-	} else if flags.ResourceGroup == "" {
+	} else if flags.ResourceGroup == "" && !azureNamespaced(flags) {
 		status.Connected = false
 		status.Message = "No resource group given, cannot connect to Azure API Management."
 		return status
@@ -135,7 +192,13 @@ func azureStatus(flags *AzureFlags) PlatformStatus {
 				return status
 			}
 
-			token = getAzureToken(client_id, client_secret, tenant_id)
+			var err error
+			token, err = getAzureToken(context.Background(), client_id, client_secret, tenant_id)
+			if err != nil {
+				status.Connected = false
+				status.Message = "Could not get Azure token: " + err.Error()
+				return status
+			}
 		}
 
 		if token == "" {
@@ -145,346 +208,561 @@ func azureStatus(flags *AzureFlags) PlatformStatus {
 		}
 	}
 
-	var apis AzureApis
-	req, _ := http.NewRequest(http.MethodGet, "https://management.azure.com/subscriptions/"+flags.Subscription+"/resourceGroups/"+flags.ResourceGroup+"/providers/Microsoft.ApiManagement/service/"+flags.ServiceName+"/apis?api-version=2022-08-01", nil)
-	req.Header.Add("Authorization", "Bearer "+token)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil {
-		body, err := io.ReadAll(resp.Body)
-		if err == nil {
-			json.Unmarshal(body, &apis)
-			//fmt.Println(string(body))
-		}
-
-		if resp.StatusCode == 200 {
-			status.Connected = true
-			status.Message = "Connected to Azure, " + strconv.Itoa(len(apis.Value)) + " APIs found in service " + flags.ServiceName + "."
-		} else {
-			status.Connected = false
-			status.Message = resp.Status
-		}
-	} else {
+	instances, err := azureInstances(context.Background(), flags, token)
+	if err != nil {
 		status.Connected = false
 		status.Message = err.Error()
+		return status
+	}
+	for _, instance := range instances {
+		apis, err := getAzureApis(context.Background(), instance.Subscription, instance.ResourceGroup, instance.ServiceName, token)
+		if err != nil {
+			status.Connected = false
+			status.Apis = 0
+			status.Message = err.Error()
+			return status
+		}
+		status.Apis += len(apis.Value)
 	}
 
+	status.Connected = true
+	if len(instances) == 1 && instances[0].Instance == "" {
+		status.Message = "Connected to Azure, " + strconv.Itoa(status.Apis) + " APIs found in service " + flags.ServiceName + "."
+	} else {
+		status.Message = "Connected to Azure, " + strconv.Itoa(status.Apis) + " APIs found in " + strconv.Itoa(len(instances)) + " services."
+	}
 	return status
 }
 
-func azureCleanLocal(flags *AzureFlags) error {
-	var baseDir = "src/main/azure"
-	os.RemoveAll(baseDir)
-	return nil
+// azureInstance is an API Management service that APIs are exported from. Instance namespaces its APIs
+// if several services are exported, and is empty otherwise.
+type azureInstance struct {
+	Subscription  string
+	ResourceGroup string
+	ServiceName   string
+	Instance      string
 }
 
-func azureServiceExport(flags *AzureFlags) error {
-	var baseDir = "src/main/azure"
-	var token string = flags.Token
-	if flags.Subscription == "" {
-		fmt.Println("No subscription given, cannot export Azure APIs.")
-		return nil
-	} else if flags.ResourceGroup == "" {
-		fmt.Println("No resource group given, cannot export Azure APIs.")
-		return nil
-	} else if flags.ServiceName == "" {
-		fmt.Println("No service name given, cannot export Azure APIs.")
-		return nil
-	}
+// azureNamespaced returns true if the flags select several services, which are then namespaced.
+func azureNamespaced(flags *AzureFlags) bool {
+	_, _, namespaced := sourceInstances(flags.ServiceName)
+	return namespaced || len(splitList(flags.Subscription)) > 1
+}
 
-	if token == "" {
-		// fetch an Azure token using a client id and secret
-		var env_token string = os.Getenv("AZURE_TOKEN")
-		if env_token != "" {
-			token = env_token
-		} else {
-			var client_id string = os.Getenv("AZURE_CLIENT_ID")
-			var client_secret string = os.Getenv("AZURE_CLIENT_SECRET")
-			var tenant_id string = os.Getenv("AZURE_TENANT_ID")
+// azureInstances returns the services to export: the service given by name, or if several services are
+// selected, the matching services found in the subscriptions (and the resource group, if one is given).
+func azureInstances(ctx context.Context, flags *AzureFlags, token string) ([]azureInstance, error) {
+	if !azureNamespaced(flags) {
+		return []azureInstance{{Subscription: flags.Subscription, ResourceGroup: flags.ResourceGroup, ServiceName: flags.ServiceName}}, nil
+	}
 
-			if client_id == "" || client_secret == "" || tenant_id == "" {
-				fmt.Println("No token sent and no client environment variables set, cannot export Azure APIs.")
-				return nil
+	names, all, _ := sourceInstances(flags.ServiceName)
+	instances := []azureInstance{}
+	found := []string{}
+	for _, subscription := range splitList(flags.Subscription) {
+		services, err := getAzureServices(ctx, subscription, flags.ResourceGroup, token)
+		if err != nil {
+			return nil, errors.New("could not list Azure services of subscription " + subscription + ": " + err.Error())
+		}
+		for _, service := range services {
+			if all || slices.Contains(names, service.Name) {
+				instances = append(instances, azureInstance{Subscription: subscription, ResourceGroup: azureResourceGroup(service.Id), ServiceName: service.Name, Instance: sourceInstanceName(service.Name)})
+				found = append(found, service.Name)
 			}
+		}
+	}
 
-			token = getAzureToken(client_id, client_secret, tenant_id)
+	for _, name := range names {
+		if !slices.Contains(found, name) {
+			return nil, errors.New("Azure service " + name + " not found in subscriptions " + flags.Subscription)
 		}
+	}
+	return instances, nil
+}
 
-		if token == "" {
-			fmt.Println("Could not get valid Azure token, cannot export Azure APIs.")
-			return nil
+// azureExportedInstances returns the services whose exported APIs are offramped, without calling Azure: the
+// service given by name, or the exported services that are selected.
+func azureExportedInstances(flags *AzureFlags, storage Storage) []azureInstance {
+	if !azureNamespaced(flags) {
+		return []azureInstance{{Subscription: flags.Subscription, ResourceGroup: flags.ResourceGroup, ServiceName: flags.ServiceName}}
+	}
+
+	names, all, _ := sourceInstances(flags.ServiceName)
+	instances := []azureInstance{}
+	for _, instance := range exportedSourceInstances(storage, "azure") {
+		// the exported service has the subscription and resource group in its id
+		files := []string{}
+		entries, _ := storage.ReadDir("azure/instances/" + instance)
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+				files = append(files, "azure/instances/"+instance+"/"+e.Name())
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		var service AzureService
+		byteValue, _ := storage.ReadFile(files[0])
+		json.Unmarshal(byteValue, &service)
+		subscription := azureIdSegment(service.Id, "subscriptions")
+		if (all || slices.Contains(names, service.Name)) && slices.Contains(splitList(flags.Subscription), subscription) {
+			instances = append(instances, azureInstance{Subscription: subscription, ResourceGroup: azureResourceGroup(service.Id), ServiceName: service.Name, Instance: instance})
+		}
+	}
+	return instances
+}
+
+// azureResourceGroup returns the resource group of an Azure resource id.
+func azureResourceGroup(id string) string {
+	return azureIdSegment(id, "resourceGroups")
+}
+
+// azureIdSegment returns the segment after key in an Azure resource id like /subscriptions/{id}/resourceGroups/{name}/...
+func azureIdSegment(id string, key string) string {
+	segments := strings.Split(id, "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if strings.EqualFold(segments[i], key) {
+			return segments[i+1]
 		}
 	}
+	return ""
+}
+
+// azureFlagsToken returns the token given in the flags, or gets one with the client credentials in the env variables.
+func azureFlagsToken(ctx context.Context, flags *AzureFlags) (string, error) {
+	if flags.Token != "" {
+		return flags.Token, nil
+	}
+	if token := os.Getenv("AZURE_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	// fetch an Azure token using a client id and secret
+	var client_id string = os.Getenv("AZURE_CLIENT_ID")
+	var client_secret string = os.Getenv("AZURE_CLIENT_SECRET")
+	var tenant_id string = os.Getenv("AZURE_TENANT_ID")
+	if client_id == "" || client_secret == "" || tenant_id == "" {
+		return "", errors.New("no token sent and no client environment variables set, cannot export Azure APIs")
+	}
+
+	token, err := getAzureToken(ctx, client_id, client_secret, tenant_id)
+	if err != nil {
+		return "", errors.New("could not get Azure token: " + err.Error())
+	}
+	if token == "" {
+		return "", errors.New("could not get valid Azure token, cannot export Azure APIs")
+	}
+	return token, nil
+}
+
+func azureCleanLocal(flags *AzureFlags) error {
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+	return storage.RemoveAll("azure")
+}
+
+func azureServiceExportMin(flags *AzureFlags) error {
+	return azureServiceExport(context.Background(), flags)
+}
+
+func azureServiceExport(ctx context.Context, flags *AzureFlags) (err error) {
+	ctx, span := startSpan(ctx, "azure service export", "apimsync.platform", "azure")
+	defer func() { span.end(err) }()
+
+	if flags.Subscription == "" {
+		return errors.New("no subscription given, cannot export Azure APIs")
+	} else if flags.ResourceGroup == "" && !azureNamespaced(flags) {
+		return errors.New("no resource group given, cannot export Azure APIs")
+	} else if flags.ServiceName == "" {
+		return errors.New("no service name given, cannot export Azure APIs")
+	}
+
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+	token, err := azureFlagsToken(ctx, flags)
+	if err != nil {
+		return err
+	}
+	instances, err := azureInstances(ctx, flags, token)
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		fmt.Println("Exporting Azure service " + instance.ServiceName + "...")
+		service, err := getAzureService(ctx, instance.Subscription, instance.ResourceGroup, instance.ServiceName, token)
+		if err != nil {
+			return errors.New("could not get Azure service " + instance.ServiceName + ": " + err.Error())
+		}
 
-	fmt.Println("Exporting Azure service " + flags.ServiceName + "...")
-	service := getAzureService(flags.Subscription, flags.ResourceGroup, flags.ServiceName, token)
-	if service != "" {
-		os.MkdirAll(baseDir, 0755)
+		// the service is stored next to the apiproxies directory of its APIs
+		baseDir := path.Dir(sourceInstanceDir("azure", instance.Instance))
 		bytes := []byte(service)
-		//bytes, _ := json.MarshalIndent(service, "", " ")
 		var result map[string]any
 		json.Unmarshal(bytes, &result)
 		bytes2, _ := json.MarshalIndent(result, "", "  ")
-		os.WriteFile(baseDir+"/"+flags.ServiceName+".json", bytes2, 0644)
+		if err := storage.WriteFile(baseDir+"/"+instance.ServiceName+".json", bytes2); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
 func azureExportMin(flags *AzureFlags) error {
-	azureExport(flags)
-	return nil
+	return printStageResult(azureExport(context.Background(), flags))
 }
 
-func azureExport(flags *AzureFlags) ([]string, error) {
-	var baseDir = "src/main/azure/apiproxies"
-	var token string = flags.Token
+func azureExport(ctx context.Context, flags *AzureFlags) (result StageResult, err error) {
+	ctx, span := startStage(ctx, "export", "azure")
+	defer func() { span.endStage(result, err) }()
+
+	result = newStageResult("export", "azure")
 	if flags.Subscription == "" {
-		fmt.Println("No subscription given, cannot export Azure APIs.")
-		return []string{}, nil
-	} else if flags.ResourceGroup == "" {
-		fmt.Println("No resource group given, cannot export Azure APIs.")
-		return []string{}, nil
+		return result, errors.New("no subscription given, cannot export Azure APIs")
+	} else if flags.ResourceGroup == "" && !azureNamespaced(flags) {
+		return result, errors.New("no resource group given, cannot export Azure APIs")
 	} else if flags.ServiceName == "" {
-		fmt.Println("No service name given, cannot export Azure APIs.")
-		return []string{}, nil
+		return result, errors.New("no service name given, cannot export Azure APIs")
 	}
 
-	if token == "" {
-		// fetch an Azure token using a client id and secret
-		var env_token string = os.Getenv("AZURE_TOKEN")
-		if env_token != "" {
-			token = env_token
-		} else {
-			var client_id string = os.Getenv("AZURE_CLIENT_ID")
-			var client_secret string = os.Getenv("AZURE_CLIENT_SECRET")
-			var tenant_id string = os.Getenv("AZURE_TENANT_ID")
-
-			if client_id == "" || client_secret == "" || tenant_id == "" {
-				fmt.Println("No token sent and no client environment variables set, cannot export Azure APIs.")
-				return []string{}, nil
-			}
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return result, err
+	}
+	token, err := azureFlagsToken(ctx, flags)
+	if err != nil {
+		return result, err
+	}
+	instances, err := azureInstances(ctx, flags, token)
+	if err != nil {
+		return result, err
+	}
 
-			token = getAzureToken(client_id, client_secret, tenant_id)
+	exported := []string{}
+	for _, instance := range instances {
+		if err := exportAzureInstance(ctx, flags, storage, instance, token, &result); err != nil {
+			return result, err
 		}
+		exported = append(exported, instance.Instance)
+	}
 
-		if token == "" {
-			fmt.Println("Could not get valid Azure token, cannot export Azure APIs.")
-			return []string{}, nil
+	if flags.Prune && flags.ApiName == "" {
+		for _, removed := range pruneSourceInstances(storage, "azure", exported) {
+			fmt.Println("Removed " + removed + ", its service is no longer exported.")
 		}
 	}
 
-	fmt.Println("Exporting Azure APIs for service " + flags.ServiceName + "...")
-	apis := getAzureApis(flags.Subscription, flags.ResourceGroup, flags.ServiceName, token)
-	apiNames := []string{}
-	if len(apis.Value) > 0 {
-		for _, api := range apis.Value {
-			if (flags.ApiName == "" || flags.ApiName == api.Name) && !strings.Contains(api.Name, ";rev=") {
-				fmt.Println("Exporting " + api.Name + "...")
-
-				var re = regexp.MustCompile(`(-v\d+)$`)
-				newName := re.ReplaceAllString(api.Name, "")
-				newApiName := api.Name
-				if api.Properties.ApiVersion != "" && !strings.HasSuffix(newApiName, api.Properties.ApiVersion) {
-					newApiName = api.Name + "-" + api.Properties.ApiVersion
-					api.Name = newApiName
-					api.Properties.DisplayName = api.Properties.DisplayName + " " + api.Properties.ApiVersion
-				}
+	return result, result.Err()
+}
+
+// exportAzureInstance exports the APIs of a service, and records the result of each API.
+func exportAzureInstance(ctx context.Context, flags *AzureFlags, storage Storage, instance azureInstance, token string, result *StageResult) error {
+	baseDir := sourceInstanceDir("azure", instance.Instance)
+
+	fmt.Println("Exporting Azure APIs for service " + instance.ServiceName + "...")
+	apis, err := getAzureApis(ctx, instance.Subscription, instance.ResourceGroup, instance.ServiceName, token)
+	if err != nil {
+		return errors.New("could not list Azure APIs of service " + instance.ServiceName + ": " + err.Error())
+	}
+	currentApis := map[string]bool{}
+	for _, api := range apis.Value {
+		if (flags.ApiName == "" || flags.ApiName == api.Name) && !strings.Contains(api.Name, ";rev=") && apiIncluded(api.Name) {
+			fmt.Println("Exporting " + api.Name + "...")
+
+			var re = regexp.MustCompile(`(-v\d+)$`)
+			newName := re.ReplaceAllString(api.Name, "")
+			newApiName := api.Name
+			if api.Properties.ApiVersion != "" && !strings.HasSuffix(newApiName, api.Properties.ApiVersion) {
+				newApiName = api.Name + "-" + api.Properties.ApiVersion
+				api.Name = newApiName
+				api.Properties.DisplayName = api.Properties.DisplayName + " " + api.Properties.ApiVersion
+			}
+
+			if api.Properties.ApiVersion != "" && !strings.HasSuffix(api.Properties.DisplayName, api.Properties.ApiVersion) {
+				api.Properties.DisplayName = api.Properties.DisplayName + " " + api.Properties.ApiVersion
+			}
 
-				if api.Properties.ApiVersion != "" && !strings.HasSuffix(api.Properties.DisplayName, api.Properties.ApiVersion) {
-					api.Properties.DisplayName = api.Properties.DisplayName + " " + api.Properties.ApiVersion
+			currentApis[newName+"/"+newApiName] = true
+			_, fileExistsErr := storage.Stat(baseDir + "/" + newName + "/" + api.Name + ".json")
+
+			if (flags.OnlyNew && fileExistsErr != nil) || !flags.OnlyNew {
+				name := instanceApiName(instance.Instance, api.Name)
+				apiCtx, apiSpan := result.startApi(ctx, name)
+				err := writeAzureApi(apiCtx, storage, instance, baseDir+"/"+newName, api, token)
+				apiSpan.end(err)
+				if err != nil {
+					result.fail(name, err)
+				} else {
+					result.ok(name)
 				}
+			}
+		}
+	}
 
-				_, fileExistsErr := os.Open(baseDir + "/" + newName + "/" + api.Name + ".json")
+	if flags.Prune && flags.ApiName == "" {
+		for _, removed := range pruneLocalApis(storage, baseDir, currentApis) {
+			fmt.Println("Removed " + removed + ", it no longer exists in Azure.")
+		}
+	}
 
-				if (flags.OnlyNew && fileExistsErr != nil) || !flags.OnlyNew {
-					bytes, _ := json.MarshalIndent(api, "", "  ")
+	return nil
+}
 
-					os.MkdirAll(baseDir+"/"+newName, 0755)
-					os.WriteFile(baseDir+"/"+newName+"/"+newApiName+".json", bytes, 0644)
-					schema := getAzureApiSchema(flags.Subscription, flags.ResourceGroup, flags.ServiceName, newApiName, token)
+// writeAzureApi writes an Azure API and its schema, if it has one, to dir.
+func writeAzureApi(ctx context.Context, storage Storage, instance azureInstance, dir string, api AzureApi, token string) error {
+	// get the schema first, so that nothing is written if it fails
+	schema, err := getAzureApiSchema(ctx, instance.Subscription, instance.ResourceGroup, instance.ServiceName, api.Name, token)
+	if err != nil {
+		return errors.New("could not get schema: " + err.Error())
+	}
 
-					if schema.Id != "" {
-						bytes, _ := json.MarshalIndent(schema, "", "  ")
-						os.WriteFile(baseDir+"/"+newName+"/"+newApiName+"-oas-definition.json", bytes, 0644)
+	bytes, _ := json.MarshalIndent(api, "", "  ")
+	if err := storage.WriteFile(dir+"/"+api.Name+".json", bytes); err != nil {
+		return err
+	}
 
-						doc_bytes := []byte(schema.Properties.Document)
-						os.WriteFile(baseDir+"/"+newName+"/"+newApiName+"-oas."+schema.Properties.SchemaType, doc_bytes, 0644)
-					}
+	if schema.Id != "" {
+		bytes, _ := json.MarshalIndent(schema, "", "  ")
+		storage.WriteFile(dir+"/"+api.Name+"-oas-definition.json", bytes)
 
-					apiNames = append(apiNames, api.Name)
-				}
-			}
-		}
+		doc_bytes := []byte(schema.Properties.Document)
+		return storage.WriteFile(dir+"/"+api.Name+"-oas."+schema.Properties.SchemaType, doc_bytes)
 	}
 
-	return apiNames, nil
+	return nil
 }
 
-func getAzureToken(clientId string, clientSecret string, tenantId string) string {
-	var result string = ""
-	var body string = "grant_type=client_credentials&client_id=" + clientId + "&client_secret=" + clientSecret + "&resource=https%3A%2F%2Fmanagement.azure.com%2F"
+func getAzureToken(ctx context.Context, clientId string, clientSecret string, tenantId string) (string, error) {
+	var body string = "grant_type=client_credentials&client_id=" + clientId + "&client_secret=" + clientSecret + "&resource=" + url.QueryEscape(azureManagementUrl()+"/")
 	bodyBuffer := bytes.NewBufferString(body)
-	req, _ := http.NewRequest(http.MethodPost, "https://login.microsoftonline.com/"+tenantId+"/oauth2/token", bodyBuffer)
-	response, err := http.DefaultClient.Do(req)
-
-	//Handle Error
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, azureLoginUrl()+"/"+tenantId+"/oauth2/token", bodyBuffer)
+	response, err := httpClient.Do(req)
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return "", err
 	}
 	defer response.Body.Close()
 	//Read the response body
 	responseBody, err := io.ReadAll(response.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return "", err
+	}
+	if response.StatusCode != 200 {
+		return "", errors.New(response.Status)
 	}
 	var azureToken AzureTokenResponse
 	json.Unmarshal(responseBody, &azureToken)
 
-	if azureToken.AccessToken != "" {
-		result = azureToken.AccessToken
+	return azureToken.AccessToken, nil
+}
+
+func getAzureService(ctx context.Context, subscriptionId string, resourceGroup string, serviceName string, token string) (string, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, azureManagementUrl()+"/subscriptions/"+subscriptionId+"/resourceGroups/"+resourceGroup+"/providers/Microsoft.ApiManagement/service/"+serviceName+"?api-version=2022-08-01", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", errors.New(resp.Status)
 	}
 
-	return result
+	return string(body), nil
 }
 
-func getAzureService(subscriptionId string, resourceGroup string, serviceName string, token string) string {
-	//var service AzureService
-	var service string
-	req, _ := http.NewRequest(http.MethodGet, "https://management.azure.com/subscriptions/"+subscriptionId+"/resourceGroups/"+resourceGroup+"/providers/Microsoft.ApiManagement/service/"+serviceName+"?api-version=2022-08-01", nil)
-	req.Header.Add("Authorization", "Bearer "+token)
+// getAzureServices lists the API Management services of a subscription, or of a resource group if one is given.
+func getAzureServices(ctx context.Context, subscriptionId string, resourceGroup string, token string) ([]AzureService, error) {
+	services := []AzureService{}
+	nextLink := azureManagementUrl() + "/subscriptions/" + subscriptionId + "/providers/Microsoft.ApiManagement/service?api-version=2022-08-01"
+	if resourceGroup != "" {
+		nextLink = azureManagementUrl() + "/subscriptions/" + subscriptionId + "/resourceGroups/" + resourceGroup + "/providers/Microsoft.ApiManagement/service?api-version=2022-08-01"
+	}
+
+	seen := map[string]bool{}
+
+	// follow nextLink until all pages are read
+	for nextLink != "" {
+		seen[nextLink] = true
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, nextLink, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return services, err
+		}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil {
 		body, err := io.ReadAll(resp.Body)
-		if err == nil {
-			service = string(body)
-			//json.Unmarshal(body, &service)
-			//fmt.Println(string(body))
+		resp.Body.Close()
+		if err != nil {
+			return services, err
+		}
+		if resp.StatusCode != 200 {
+			return services, errors.New(resp.Status)
+		}
+
+		var page struct {
+			Value    []AzureService `json:"value"`
+			NextLink string         `json:"nextLink"`
+		}
+		json.Unmarshal(body, &page)
+		services = append(services, page.Value...)
+		nextLink = page.NextLink
+		// a repeated link would read the same pages forever
+		if seen[nextLink] {
+			return services, errors.New("Azure returned the next link " + nextLink + " twice")
 		}
 	}
 
-	return service
+	return services, nil
 }
 
-func getAzureApis(subscriptionId string, resourceGroup string, serviceName string, token string) AzureApis {
+func getAzureApis(ctx context.Context, subscriptionId string, resourceGroup string, serviceName string, token string) (AzureApis, error) {
 	var apis AzureApis
-	req, _ := http.NewRequest(http.MethodGet, "https://management.azure.com/subscriptions/"+subscriptionId+"/resourceGroups/"+resourceGroup+"/providers/Microsoft.ApiManagement/service/"+serviceName+"/apis?api-version=2022-08-01", nil)
-	req.Header.Add("Authorization", "Bearer "+token)
+	nextLink := azureManagementUrl() + "/subscriptions/" + subscriptionId + "/resourceGroups/" + resourceGroup + "/providers/Microsoft.ApiManagement/service/" + serviceName + "/apis?api-version=2022-08-01"
+
+	seen := map[string]bool{}
+
+	// follow nextLink until all pages are read
+	for nextLink != "" {
+		seen[nextLink] = true
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, nextLink, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return apis, err
+		}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil {
 		body, err := io.ReadAll(resp.Body)
-		if err == nil {
-			json.Unmarshal(body, &apis)
-			//fmt.Println(string(body))
+		resp.Body.Close()
+		if err != nil {
+			return apis, err
+		}
+		if resp.StatusCode != 200 {
+			return apis, errors.New(resp.Status)
+		}
+
+		var page AzureApis
+		json.Unmarshal(body, &page)
+		apis.Value = append(apis.Value, page.Value...)
+		nextLink = page.NextLink
+		// a repeated link would read the same pages forever
+		if seen[nextLink] {
+			return apis, errors.New("Azure returned the next link " + nextLink + " twice")
 		}
 	}
 
-	return apis
+	return apis, nil
 }
 
-func getAzureApiSchema(subscriptionId string, resourceGroup string, serviceName string, apiName string, token string) AzureApiSchema {
+// getAzureApiSchema returns the schema of an API, or an empty schema if the API has none.
+func getAzureApiSchema(ctx context.Context, subscriptionId string, resourceGroup string, serviceName string, apiName string, token string) (AzureApiSchema, error) {
 	var schema AzureApiSchema
-	req, _ := http.NewRequest(http.MethodGet, "https://management.azure.com/subscriptions/"+subscriptionId+"/resourceGroups/"+resourceGroup+"/providers/Microsoft.ApiManagement/service/"+serviceName+"/schemas/"+apiName+"?api-version=2022-08-01", nil)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, azureManagementUrl()+"/subscriptions/"+subscriptionId+"/resourceGroups/"+resourceGroup+"/providers/Microsoft.ApiManagement/service/"+serviceName+"/schemas/"+apiName+"?api-version=2022-08-01", nil)
 	req.Header.Add("Authorization", "Bearer "+token)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err == nil {
-		if resp.StatusCode == 200 {
-			body, err := io.ReadAll(resp.Body)
-			if err == nil {
-				document := gjson.Get(string(body), "properties.document").String()
-				json.Unmarshal(body, &schema)
-				schema.Properties.Document = document
-				//fmt.Println(string(body))
-			}
-		}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return schema, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return schema, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return schema, err
+	}
+	if resp.StatusCode != 200 {
+		return schema, errors.New(resp.Status)
+	}
+
+	document := gjson.Get(string(body), "properties.document").String()
+	json.Unmarshal(body, &schema)
+	schema.Properties.Document = document
+	return schema, nil
+}
 
-	return schema
+func azureOfframpMin(flags *AzureFlags) error {
+	return printStageResult(azureOfframp(context.Background(), flags))
 }
 
-func azureOfframp(flags *AzureFlags) error {
+func azureOfframp(ctx context.Context, flags *AzureFlags) (result StageResult, err error) {
+	ctx, span := startStage(ctx, "offramp", "azure")
+	defer func() { span.endStage(result, err) }()
 
-	azureBaseDir := "src/main/azure/apiproxies"
-	baseDir := "src/main/general/apiproxies"
+	result = newStageResult("offramp", "azure")
 
 	if flags.Subscription == "" {
-		fmt.Println("No subscription given, cannot offramp Azure APIs.")
-		return nil
-	} else if flags.ResourceGroup == "" {
-		fmt.Println("No resource group given, cannot offramp Azure APIs.")
-		return nil
+		return result, errors.New("no subscription given, cannot offramp Azure APIs")
+	} else if flags.ResourceGroup == "" && !azureNamespaced(flags) {
+		return result, errors.New("no resource group given, cannot offramp Azure APIs")
 	} else if flags.ServiceName == "" {
-		fmt.Println("No service name given, cannot offramp Azure APIs.")
-		return nil
+		return result, errors.New("no service name given, cannot offramp Azure APIs")
 	}
 
-	entries, err := os.ReadDir(azureBaseDir)
+	storage, err := workspaceStorage(flags.Workspace)
 	if err != nil {
-		log.Fatal(err)
+		return result, err
+	}
+	instances := azureExportedInstances(flags, storage)
+	if len(instances) == 0 {
+		return result, errors.New("no exported Azure services found, cannot offramp Azure APIs")
+	}
+	instanceEntries := [][]fs.DirEntry{}
+	for _, instance := range instances {
+		entries, err := storage.ReadDir(sourceInstanceDir("azure", instance.Instance))
+		if err != nil {
+			return result, errors.New("could not read exported Azure APIs: " + err.Error())
+		}
+		instanceEntries = append(instanceEntries, entries)
 	}
 
 	fmt.Println("Offramping Azure API Management APIs to general...")
 
-	// load azureService info, if available
-	var azureService AzureService
-	azureServiceFile, err := os.Open(azureBaseDir + "/../" + flags.ServiceName + ".json")
+	if flags.Prune && flags.ApiName == "" {
+		for _, removed := range pruneGeneralApis(storage, "azure") {
+			fmt.Println("Removed general API " + removed + ", it no longer exists in Azure.")
+		}
+	}
 
-	if err == nil {
-		byteValue, _ := io.ReadAll(azureServiceFile)
-		json.Unmarshal(byteValue, &azureService)
-	}
-
-	for _, e := range entries {
-		if flags.ApiName == "" || flags.ApiName == e.Name() {
-			fmt.Println(e.Name())
-
-			// read all files
-			fileEntries, _ := os.ReadDir(azureBaseDir + "/" + e.Name())
-			for _, f := range fileEntries {
-				if !strings.HasSuffix(f.Name(), "-oas.json") && !strings.HasSuffix(f.Name(), "-oas-definition.json") {
-					// this is an API file
-					var azureApi AzureApi
-					apiFile, err := os.Open(azureBaseDir + "/" + e.Name() + "/" + f.Name())
-
-					if err != nil {
-						log.Fatal(err)
-					} else {
-						byteValue, _ := io.ReadAll(apiFile)
-						json.Unmarshal(byteValue, &azureApi)
-					}
-					defer apiFile.Close()
-
-					if azureApi.Name != "" {
-						var generalApi GeneralApi
-						generalApi.Name = azureApi.Name + "-azure"
-						generalApi.DisplayName = azureApi.Properties.DisplayName
-						generalApi.Description = azureApi.Properties.Description
-						generalApi.Version = azureApi.Properties.ApiVersion
-						generalApi.OwnerEmail = azureService.Properties.PublisherEmail
-						generalApi.OwnerName = azureService.Properties.PublisherName
-						generalApi.DocumentationUrl = azureService.Properties.DeveloperPortalUrl + "/api-details#api=" + azureApi.Name
-						generalApi.GatewayUrl = azureService.Properties.GatewayUrl + "/" + azureApi.Properties.Path
-						generalApi.BasePath = azureApi.Properties.Path
-						generalApi.PlatformId = "azure-api-management"
-						generalApi.PlatformName = "Azure API Management"
-						generalApi.PlatformResourceUri = "https://portal.azure.com/#resource/subscriptions/" + flags.Subscription + "/resourceGroups/" + flags.ResourceGroup + "/providers/Microsoft.ApiManagement/service/" + flags.ServiceName + "/overview?apiName=" + azureApi.Name
-
-						bytes, _ := json.MarshalIndent(generalApi, "", "  ")
-						//os.RemoveAll(baseDir + "/" + generalApi.Name)
-						os.MkdirAll(baseDir+"/"+e.Name(), 0755)
-
-						//os.WriteFile(baseDir+"/"+e.Name()+"/"+e.Name()+".json", bytes, 0644)
-						writeGeneralApi(e.Name(), generalApi)
-						os.WriteFile(baseDir+"/"+e.Name()+"/"+generalApi.Name+".json", bytes, 0644)
-
-						schemaFile, err := os.Open(azureBaseDir + "/" + e.Name() + "/" + azureApi.Name + "-oas.json")
-						if err == nil {
-							// we have an api spec, copy it over
-							byteValue, _ := io.ReadAll(schemaFile)
-							os.WriteFile(baseDir+"/"+e.Name()+"/"+generalApi.Name+"-oas.json", byteValue, 0644)
+	for i, instance := range instances {
+		azureBaseDir := sourceInstanceDir("azure", instance.Instance)
+
+		// load azureService info, if available
+		var azureService AzureService
+		byteValue, err := storage.ReadFile(path.Dir(azureBaseDir) + "/" + instance.ServiceName + ".json")
+
+		if err == nil {
+			json.Unmarshal(byteValue, &azureService)
+		}
+
+		for _, e := range instanceEntries[i] {
+			if flags.ApiName == "" || flags.ApiName == e.Name() {
+				fmt.Println(e.Name())
+
+				// read all files
+				fileEntries, _ := storage.ReadDir(azureBaseDir + "/" + e.Name())
+				for _, f := range fileEntries {
+					if !strings.HasSuffix(f.Name(), "-oas.json") && !strings.HasSuffix(f.Name(), "-oas-definition.json") {
+						// this is an API file
+						name := instanceApiName(instance.Instance, strings.TrimSuffix(f.Name(), ".json"))
+						_, apiSpan := result.startApi(ctx, name)
+						err := offrampAzureApi(storage, instance, azureService, e.Name(), f.Name())
+						apiSpan.end(err)
+						if err != nil {
+							result.fail(name, err)
+						} else {
+							result.ok(name)
 						}
 					}
 				}
@@ -492,5 +770,56 @@ func azureOfframp(flags *AzureFlags) error {
 		}
 	}
 
+	return result, result.Err()
+}
+
+// offrampAzureApi converts the exported Azure API in file to a general API in the general directory dir.
+func offrampAzureApi(storage Storage, instance azureInstance, azureService AzureService, dir string, file string) error {
+	azureBaseDir := sourceInstanceDir("azure", instance.Instance)
+	baseDir := "general/apiproxies"
+
+	var azureApi AzureApi
+	byteValue, err := storage.ReadFile(azureBaseDir + "/" + dir + "/" + file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(byteValue, &azureApi); err != nil {
+		return errors.New("could not parse " + file + ": " + err.Error())
+	}
+
+	if azureApi.Name == "" {
+		return errors.New(file + " has no API name")
+	}
+
+	var generalApi GeneralApi
+	generalApi.Name = generalPlatformApiName(azureApi.Name, instance.Instance, "azure")
+	generalApi.Instance = instance.Instance
+	generalApi.DisplayName = azureApi.Properties.DisplayName
+	generalApi.Description = azureApi.Properties.Description
+	generalApi.Version = azureApi.Properties.ApiVersion
+	generalApi.OwnerEmail = azureService.Properties.PublisherEmail
+	generalApi.OwnerName = azureService.Properties.PublisherName
+	generalApi.DocumentationUrl = azureService.Properties.DeveloperPortalUrl + "/api-details#api=" + azureApi.Name
+	generalApi.GatewayUrl = azureService.Properties.GatewayUrl + "/" + azureApi.Properties.Path
+	generalApi.BasePath = azureApi.Properties.Path
+	generalApi.PlatformId = "azure-api-management"
+	generalApi.PlatformName = "Azure API Management"
+	generalApi.PlatformResourceUri = "https://portal.azure.com/#resource/subscriptions/" + instance.Subscription + "/resourceGroups/" + instance.ResourceGroup + "/providers/Microsoft.ApiManagement/service/" + instance.ServiceName + "/overview?apiName=" + azureApi.Name
+
+	bytes, _ := json.MarshalIndent(generalApi, "", "  ")
+
+	if err := storage.WriteFile(baseDir+"/"+dir+"/"+generalApi.Name+".json", bytes); err != nil {
+		return err
+	}
+	if err := mergeGeneralApi(storage, dir, generalMergePrecedenceFromEnv()); err != nil {
+		return errors.New("could not merge general API " + dir + ": " + err.Error())
+	}
+
+	schemaBytes, err := storage.ReadFile(azureBaseDir + "/" + dir + "/" + azureApi.Name + "-oas.json")
+	if err == nil {
+		// we have an api spec, copy it over
+		return storage.WriteFile(baseDir+"/"+dir+"/"+generalApi.Name+"-oas.json", schemaBytes)
+	}
+
 	return nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAzureRepeatedNextLink checks that the list calls stop if a page links to a page that was already read.
+func TestAzureRepeatedNextLink(t *testing.T) {
+	var server *httptest.Server
+	requests := 0
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// the second page links back to the first one
+		next := server.URL + r.URL.Path + "?api-version=2022-08-01&$skip=2"
+		if r.URL.Query().Get("$skip") != "" {
+			next = server.URL + r.URL.Path + "?api-version=2022-08-01"
+		}
+		writeFakeJson(w, http.StatusOK, map[string]any{"value": []any{map[string]any{"name": "petstore"}}, "nextLink": next})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("APIMSYNC_AZURE_MANAGEMENT_URL", server.URL)
+
+	tests := []struct {
+		name string
+		list func() error
+	}{
+		{"services", func() error {
+			_, err := getAzureServices(context.Background(), "subscription", "", "token")
+			return err
+		}},
+		{"apis", func() error {
+			_, err := getAzureApis(context.Background(), "subscription", "group", "service", "token")
+			return err
+		}},
+	}
+	for _, test := range tests {
+		requests = 0
+		if err := test.list(); err == nil || !strings.Contains(err.Error(), "twice") {
+			t.Errorf("%s: expected an error for the repeated link, got %v", test.name, err)
+		}
+		if requests != 2 {
+			t.Errorf("%s: expected 2 requests, got %d", test.name, requests)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// CatalogApi is a merged general API with the platform APIs it was merged from.
+type CatalogApi struct {
+	GeneralApi
+	Deployments []CatalogDeployment `json:"deployments" doc:"The platform APIs the general API was merged from, one for each platform and version."`
+
+	// storage and dir are the workspace and the directory of the general API in it
+	storage Storage
+	dir     string
+}
+
+// CatalogDeployment is a platform API of a general API, e.g. petstore-v1-azure.
+type CatalogDeployment struct {
+	GeneralApi
+	Platform string `json:"platform" example:"azure" doc:"The platform the API was offramped from."`
+	HasSpec  bool   `json:"hasSpec" doc:"If an OpenAPI document of the API is stored, see /v1/apis/{name}/specs/{platform}."`
+}
+
+var errCatalogApiNotFound = errors.New("general API not found")
+
+// loadCatalogApi reads a general API and its platform APIs from general/apiproxies in the workspace.
+func loadCatalogApi(storage Storage, name string) (CatalogApi, error) {
+	baseDir := "general/apiproxies"
+	var result CatalogApi
+
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return result, errCatalogApiNotFound
+	}
+	byteValue, err := storage.ReadFile(baseDir + "/" + name + "/" + name + ".json")
+	if err != nil {
+		return result, errCatalogApiNotFound
+	}
+	if err := json.Unmarshal(byteValue, &result.GeneralApi); err != nil {
+		return result, errors.New("could not parse " + name + ".json: " + err.Error())
+	}
+
+	result.storage = storage
+	result.dir = baseDir + "/" + name
+	result.Deployments = []CatalogDeployment{}
+	fileEntries, _ := storage.ReadDir(baseDir + "/" + name)
+	for _, f := range fileEntries {
+		platformName := generalPlatformName(f.Name())
+		if platformName == "" || strings.Contains(f.Name(), "-oas") {
+			continue
+		}
+		byteValue, err := storage.ReadFile(baseDir + "/" + name + "/" + f.Name())
+		if err != nil {
+			continue
+		}
+		deployment := CatalogDeployment{Platform: platformName}
+		json.Unmarshal(byteValue, &deployment.GeneralApi)
+		deployment.Name = strings.TrimSuffix(f.Name(), ".json")
+		if _, err := storage.Stat(baseDir + "/" + name + "/" + deployment.Name + "-oas.json"); err == nil {
+			deployment.HasSpec = true
+		}
+		result.Deployments = append(result.Deployments, deployment)
+	}
+
+	return result, nil
+}
+
+// listCatalogApis returns the general APIs sorted by name, only the ones deployed to platform if it is given.
+func listCatalogApis(storage Storage, platform string) []CatalogApi {
+	result := []CatalogApi{}
+	entries, _ := storage.ReadDir("general/apiproxies")
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		api, err := loadCatalogApi(storage, e.Name())
+		if err != nil {
+			continue
+		}
+		if platform == "" || len(api.deploymentsOn(platform)) > 0 {
+			result = append(result, api)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// deploymentsOn returns the platform APIs on a platform, given by name (azure) or platform ID (azure-api-management).
+func (api CatalogApi) deploymentsOn(platform string) []CatalogDeployment {
+	result := []CatalogDeployment{}
+	for _, deployment := range api.Deployments {
+		if deployment.Platform == platform || deployment.PlatformId == platform {
+			result = append(result, deployment)
+		}
+	}
+	return result
+}
+
+// readCatalogSpec returns the stored OpenAPI document of a platform API of a general API. If the API has
+// several platform APIs on the platform, e.g. v1 and v2, the deployment name must be given.
+func readCatalogSpec(api CatalogApi, platform string, deployment string) (string, []byte, error) {
+	candidates := []string{}
+	for _, d := range api.deploymentsOn(platform) {
+		if d.HasSpec && (deployment == "" || deployment == d.Name) {
+			candidates = append(candidates, d.Name)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil, errCatalogApiNotFound
+	}
+	if len(candidates) > 1 {
+		return "", nil, errors.New("API " + api.Name + " has several " + platform + " specs (" + strings.Join(candidates, ", ") + "), select one with the deployment parameter")
+	}
+
+	bytes, err := api.storage.ReadFile(api.dir + "/" + candidates[0] + "-oas.json")
+	return candidates[0], bytes, err
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the apimsync.yaml file, with named profiles of sources, targets and syncs, e.g. dev and prod.
+type Config struct {
+	// DefaultProfile is used if no profile is selected with --profile or APIMSYNC_PROFILE.
+	DefaultProfile string                   `json:"defaultProfile"`
+	Profiles       map[string]ConfigProfile `json:"profiles"`
+}
+
+// ConfigProfile sets the env variables of the platforms and apimsync, env variables that are already set win.
+type ConfigProfile struct {
+	Sources  ConfigSources    `json:"sources"`
+	Targets  ConfigTargets    `json:"targets"`
+	Syncs    []ScheduleConfig `json:"syncs"`
+	Filters  ConfigFilters    `json:"filters"`
+	Mappings ConfigMappings   `json:"mappings"`
+	// Workspace is the directory the files are in, JobWorkspace is shared or temp for the syncs of the web server.
+	Workspace    string `json:"workspace"`
+	JobWorkspace string `json:"jobWorkspace"`
+	// Env sets any other env variable, e.g. APIMSYNC_HTTP_RETRIES.
+	Env map[string]string `json:"env"`
+}
+
+type ConfigSources struct {
+	Azure  *AzureConfig  `json:"azure"`
+	Aws    *AwsConfig    `json:"aws"`
+	Apigee *GoogleConfig `json:"apigee"`
+}
+
+type ConfigTargets struct {
+	ApiHub *GoogleConfig `json:"apihub"`
+	Apigee *GoogleConfig `json:"apigee"`
+}
+
+// AzureConfig selects a single service with serviceName, or several services with subscriptions and services,
+// where "*" selects all services of the subscriptions.
+type AzureConfig struct {
+	Subscription  string   `json:"subscription"`
+	Subscriptions []string `json:"subscriptions"`
+	ResourceGroup string   `json:"resourceGroup"`
+	ServiceName   string   `json:"serviceName"`
+	Services      []string `json:"services"`
+	TenantId      string   `json:"tenantId"`
+	ClientId      string   `json:"clientId"`
+	ClientSecret  string   `json:"clientSecret"`
+}
+
+// AwsConfig selects a single region with region, or several regions with regions, where "*" selects all enabled regions.
+type AwsConfig struct {
+	Region          string   `json:"region"`
+	Regions         []string `json:"regions"`
+	AccessKeyId     string   `json:"accessKeyId"`
+	SecretAccessKey string   `json:"secretAccessKey"`
+}
+
+// GoogleConfig is the project of Apigee and API Hub, which share the APIGEE_PROJECT and APIGEE_REGION variables.
+type GoogleConfig struct {
+	Project   string   `json:"project"`
+	Region    string   `json:"region"`
+	Protected []string `json:"protected"`
+}
+
+// ConfigFilters selects the source APIs that are exported, by name patterns like "petstore*".
+type ConfigFilters struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+type ConfigMappings struct {
+	// Precedence lists for each field, or "*" for all fields, the platforms to merge it from first.
+	Precedence map[string][]string `json:"precedence"`
+}
+
+// configSetting is an env variable set by a profile.
+type configSetting struct {
+	name   string
+	value  string
+	secret bool
+}
+
+// profile is the profile apimsync runs with, if any.
+var profile ConfigProfile
+var profileName string
+
+func configFile() string {
+	if os.Getenv("APIMSYNC_CONFIG") != "" {
+		return os.Getenv("APIMSYNC_CONFIG")
+	}
+	return "apimsync.yaml"
+}
+
+var configVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// loadConfig reads a config file. ${NAME} in values is replaced with the env variable NAME, so that secrets
+// don't have to be in the file.
+func loadConfig(file string) (Config, error) {
+	var config Config
+	byteValue, err := os.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	// the YAML is read into the JSON fields of the config, with numbers and booleans as strings where the
+	// fields are strings, e.g. the values of env
+	if err := yaml.UnmarshalStrict(byteValue, &config); err != nil {
+		return config, errors.New("could not read " + file + ": " + err.Error())
+	}
+
+	// the variables are replaced in the parsed values, so that their values can't change the structure of the file
+	var value any
+	jsonBytes, _ := json.Marshal(config)
+	json.Unmarshal(jsonBytes, &value)
+	jsonBytes, _ = json.Marshal(expandConfigVariables(value))
+	config = Config{}
+	if err := json.Unmarshal(jsonBytes, &config); err != nil {
+		return config, errors.New("could not read " + file + ": " + err.Error())
+	}
+	return config, nil
+}
+
+func expandConfigVariables(value any) any {
+	switch v := value.(type) {
+	case string:
+		return configVariable.ReplaceAllStringFunc(v, func(match string) string {
+			return os.Getenv(match[2 : len(match)-1])
+		})
+	case []any:
+		for i := range v {
+			v[i] = expandConfigVariables(v[i])
+		}
+	case map[string]any:
+		for key := range v {
+			v[key] = expandConfigVariables(v[key])
+		}
+	}
+	return value
+}
+
+// profileArgs removes --profile and --config from the command line, since they apply to all commands, and
+// returns the remaining arguments with the profile and config file given.
+func profileArgs(args []string) ([]string, string, string) {
+	result := []string{}
+	selected, file := "", ""
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if (name != "--profile" && name != "-profile" && name != "--config" && name != "-config") || i == 0 {
+			result = append(result, args[i])
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		if strings.HasSuffix(name, "profile") {
+			selected = value
+		} else {
+			file = value
+		}
+	}
+	return result, selected, file
+}
+
+// useProfile applies the selected profile of the config file, or its default profile. It does nothing if no
+// profile is selected and the config file doesn't exist.
+func useProfile(selected string) error {
+	// older env scripts set APIGEE_PROJECT_ID
+	if os.Getenv("APIGEE_PROJECT") == "" && os.Getenv("APIGEE_PROJECT_ID") != "" {
+		os.Setenv("APIGEE_PROJECT", os.Getenv("APIGEE_PROJECT_ID"))
+	}
+
+	if selected == "" {
+		selected = os.Getenv("APIMSYNC_PROFILE")
+	}
+	file := configFile()
+	if _, err := os.Stat(file); err != nil && selected == "" {
+		return nil
+	}
+
+	config, err := loadConfig(file)
+	if err != nil {
+		return err
+	}
+	if selected == "" {
+		selected = config.DefaultProfile
+	}
+	if selected == "" {
+		return nil
+	}
+	selectedProfile, ok := config.Profiles[selected]
+	if !ok {
+		return errors.New("profile " + selected + " not found in " + file)
+	}
+
+	for _, setting := range selectedProfile.settings() {
+		if _, set := os.LookupEnv(setting.name); !set && setting.value != "" {
+			os.Setenv(setting.name, setting.value)
+		}
+	}
+	profile = selectedProfile
+	profileName = selected
+	return nil
+}
+
+// settings returns the env variables the profile sets.
+func (p ConfigProfile) settings() []configSetting {
+	settings := []configSetting{}
+	add := func(name string, value string, secret bool) {
+		if !slices.ContainsFunc(settings, func(setting configSetting) bool { return setting.name == name && setting.value == value }) {
+			settings = append(settings, configSetting{name: name, value: value, secret: secret})
+		}
+	}
+
+	if azure := p.Sources.Azure; azure != nil {
+		add("AZURE_SUBSCRIPTION_ID", configList(azure.Subscription, azure.Subscriptions), false)
+		add("AZURE_RESOURCE_GROUP", azure.ResourceGroup, false)
+		add("AZURE_SERVICE_NAME", configList(azure.ServiceName, azure.Services), false)
+		add("AZURE_TENANT_ID", azure.TenantId, false)
+		add("AZURE_CLIENT_ID", azure.ClientId, false)
+		add("AZURE_CLIENT_SECRET", azure.ClientSecret, true)
+	}
+	if aws := p.Sources.Aws; aws != nil {
+		add("AWS_REGION", configList(aws.Region, aws.Regions), false)
+		add("AWS_ACCESS_KEY_ID", aws.AccessKeyId, false)
+		add("AWS_SECRET_ACCESS_KEY", aws.SecretAccessKey, true)
+	}
+	for _, google := range []*GoogleConfig{p.Sources.Apigee, p.Targets.Apigee, p.Targets.ApiHub} {
+		if google != nil {
+			add("APIGEE_PROJECT", google.Project, false)
+			add("APIGEE_REGION", google.Region, false)
+		}
+	}
+	if p.Targets.ApiHub != nil {
+		add("APIHUB_PROTECTED", strings.Join(p.Targets.ApiHub.Protected, ","), false)
+	}
+	add("APIMSYNC_INCLUDE", strings.Join(p.Filters.Include, ","), false)
+	add("APIMSYNC_EXCLUDE", strings.Join(p.Filters.Exclude, ","), false)
+
+	rules := []string{}
+	for _, field := range sortedKeys(p.Mappings.Precedence) {
+		rules = append(rules, field+"="+strings.Join(p.Mappings.Precedence[field], ","))
+	}
+	add("APIMSYNC_MERGE_PRECEDENCE", strings.Join(rules, ";"), false)
+	add("APIMSYNC_WORKSPACE", p.Workspace, false)
+	add("APIMSYNC_JOB_WORKSPACE", p.JobWorkspace, false)
+
+	for _, name := range sortedKeys(p.Env) {
+		add(name, p.Env[name], false)
+	}
+	return settings
+}
+
+// configList joins a single value and a list of values to the comma separated list of an env variable.
+func configList(value string, values []string) string {
+	if value != "" {
+		values = append([]string{value}, values...)
+	}
+	return strings.Join(values, ",")
+}
+
+// validate returns all problems of the profile.
+func (p ConfigProfile) validate() []error {
+	errs := []error{}
+
+	if azure := p.Sources.Azure; azure != nil {
+		flags := AzureFlags{Subscription: configList(azure.Subscription, azure.Subscriptions), ResourceGroup: azure.ResourceGroup, ServiceName: configList(azure.ServiceName, azure.Services)}
+		if flags.Subscription == "" || flags.ServiceName == "" || (flags.ResourceGroup == "" && !azureNamespaced(&flags)) {
+			errs = append(errs, errors.New("sources.azure needs subscription, resourceGroup and serviceName, or subscriptions and services"))
+		}
+	}
+	if aws := p.Sources.Aws; aws != nil && configList(aws.Region, aws.Regions) == "" {
+		errs = append(errs, errors.New("sources.aws needs a region or regions"))
+	}
+	google := map[string]string{}
+	for name, config := range map[string]*GoogleConfig{"sources.apigee": p.Sources.Apigee, "targets.apigee": p.Targets.Apigee, "targets.apihub": p.Targets.ApiHub} {
+		if config == nil {
+			continue
+		}
+		if config.Project == "" {
+			errs = append(errs, errors.New(name+" needs a project"))
+		}
+		google[name] = config.Project + "/" + config.Region
+	}
+	values := []string{}
+	for _, name := range sortedKeys(google) {
+		if !slices.Contains(values, google[name]) {
+			values = append(values, google[name])
+		}
+	}
+	if len(values) > 1 {
+		errs = append(errs, errors.New("apigee and apihub must use the same project and region, they share APIGEE_PROJECT and APIGEE_REGION"))
+	}
+
+	names := map[string]bool{}
+	for _, sync := range p.Syncs {
+		schedule, err := newSchedule(sync)
+		if err != nil {
+			errs = append(errs, errors.New("syncs: "+err.Error()))
+			continue
+		}
+		if names[schedule.Name] {
+			errs = append(errs, errors.New("syncs: "+schedule.Name+" is defined more than once"))
+		}
+		names[schedule.Name] = true
+	}
+
+	for _, pattern := range append(slices.Clone(p.Filters.Include), p.Filters.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, errors.New("filters: invalid pattern "+pattern))
+		}
+	}
+	if p.JobWorkspace != "" {
+		if err := checkJobWorkspace(p.JobWorkspace); err != nil {
+			errs = append(errs, errors.New("jobWorkspace: "+err.Error()))
+		}
+	}
+	for field, platformNames := range p.Mappings.Precedence {
+		if field != "*" && !slices.Contains(generalApiFields(), field) {
+			errs = append(errs, errors.New("mappings.precedence: unknown field "+field+", use one of "+strings.Join(generalApiFields(), ", ")))
+		}
+		if len(platformNames) == 0 {
+			errs = append(errs, errors.New("mappings.precedence: no platforms given for "+field))
+		}
+	}
+
+	return errs
+}
+
+// apiIncluded returns true if a source API passes the filters in APIMSYNC_INCLUDE and APIMSYNC_EXCLUDE.
+func apiIncluded(name string) bool {
+	matches := func(patterns string) bool {
+		for _, pattern := range splitList(patterns) {
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+		return false
+	}
+	if os.Getenv("APIMSYNC_INCLUDE") != "" && !matches(os.Getenv("APIMSYNC_INCLUDE")) {
+		return false
+	}
+	return !matches(os.Getenv("APIMSYNC_EXCLUDE"))
+}
+
+// configValidate checks all profiles of the config file, and shows the env variables each of them sets.
+func configValidate() error {
+	file := configFile()
+	config, err := loadConfig(file)
+	if err != nil {
+		return err
+	}
+	if len(config.Profiles) == 0 {
+		return errors.New("no profiles found in " + file)
+	}
+	if _, ok := config.Profiles[config.DefaultProfile]; config.DefaultProfile != "" && !ok {
+		return errors.New("the default profile " + config.DefaultProfile + " is not defined in " + file)
+	}
+
+	var errs []error
+	for _, name := range sortedKeys(config.Profiles) {
+		active := ""
+		if name == profileName {
+			active = ", the active profile"
+		}
+		profileErrs := config.Profiles[name].validate()
+		if len(profileErrs) > 0 {
+			fmt.Println("Profile " + name + active + " has " + strconv.Itoa(len(profileErrs)) + " problem(s):")
+			for _, err := range profileErrs {
+				fmt.Println("  " + err.Error())
+				errs = append(errs, errors.New(name+": "+err.Error()))
+			}
+			continue
+		}
+
+		fmt.Println("Profile " + name + active + " is valid, it sets:")
+		for _, setting := range config.Profiles[name].settings() {
+			if setting.value == "" {
+				continue
+			}
+			value := setting.value
+			if setting.secret {
+				value = "***"
+			}
+			if envValue, set := os.LookupEnv(setting.name); set && envValue != setting.value {
+				value = value + " (overridden by the env)"
+			}
+			fmt.Println("  " + setting.name + "=" + value)
+		}
+	}
+
+	return errors.Join(errs...)
+}
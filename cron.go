@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression with the fields minute, hour, day of month, month and day of week,
+// or one of the shortcuts @hourly, @daily, @weekly, @monthly or "@every <duration>".
+type CronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// if both days and weekdays are restricted, a time matches if either matches, like in cron
+	daysRestricted, weekdaysRestricted bool
+	every                              time.Duration
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronWeekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseCron(expression string) (CronSchedule, error) {
+	var schedule CronSchedule
+	expression = strings.TrimSpace(expression)
+
+	if duration, found := strings.CutPrefix(expression, "@every "); found {
+		every, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || every < time.Minute {
+			return schedule, errors.New("invalid duration in " + expression + ", it must be at least 1m")
+		}
+		schedule.every = every
+		return schedule, nil
+	}
+	if shortcut, ok := cronShortcuts[expression]; ok {
+		expression = shortcut
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return schedule, errors.New("invalid cron expression " + expression + ", it needs 5 fields: minute hour day month weekday")
+	}
+
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return schedule, errors.New("invalid minute: " + err.Error())
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return schedule, errors.New("invalid hour: " + err.Error())
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return schedule, errors.New("invalid day of month: " + err.Error())
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return schedule, errors.New("invalid month: " + err.Error())
+	}
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7, cronWeekdayNames); err != nil {
+		return schedule, errors.New("invalid day of week: " + err.Error())
+	}
+	// 7 is Sunday too
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+	schedule.daysRestricted = fields[2] != "*" && fields[2] != "?"
+	schedule.weekdaysRestricted = fields[4] != "*" && fields[4] != "?"
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (1-5) and steps (*/15, 1-30/2) into a bit set.
+func parseCronField(field string, minimum int, maximum int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepValue, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepValue)
+			if err != nil || step < 1 {
+				return 0, errors.New("invalid step " + stepValue)
+			}
+		}
+
+		start, end := minimum, maximum
+		if valueRange != "*" && valueRange != "?" {
+			startValue, endValue, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if start, err = parseCronValue(startValue, minimum, names); err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseCronValue(endValue, minimum, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				end = maximum
+			}
+		}
+		if start < minimum || end > maximum || start > end {
+			return 0, errors.New(part + " is out of range " + strconv.Itoa(minimum) + "-" + strconv.Itoa(maximum))
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, minimum int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return i + minimum, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("invalid value " + value)
+	}
+	return number, nil
+}
+
+// Next returns the first time after the given time that matches the schedule, in the location of after.
+func (s CronSchedule) Next(after time.Time) time.Time {
+	if s.every > 0 {
+		return after.Add(s.every)
+	}
+
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// every combination repeats within a few years, give up after that
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s CronSchedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.daysRestricted && s.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
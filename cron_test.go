@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	date := func(value string) time.Time {
+		result, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	tests := []struct {
+		expression string
+		after      string
+		expected   string
+	}{
+		{"* * * * *", "2024-03-08 10:07:30", "2024-03-08 10:08:00"},
+		{"*/15 * * * *", "2024-03-08 10:07:30", "2024-03-08 10:15:00"},
+		{"0 * * * *", "2024-03-08 10:00:00", "2024-03-08 11:00:00"},
+		{"0 9-17/4 * * *", "2024-03-08 09:00:00", "2024-03-08 13:00:00"},
+		{"5,10 * * * *", "2024-03-08 10:05:00", "2024-03-08 10:10:00"},
+		{"@hourly", "2024-03-08 23:30:00", "2024-03-09 00:00:00"},
+		{"@daily", "2024-03-10 23:59:00", "2024-03-11 00:00:00"},
+		{"@weekly", "2024-03-08 10:00:00", "2024-03-10 00:00:00"},
+		{"@monthly", "2024-01-31 12:00:00", "2024-02-01 00:00:00"},
+		{"@yearly", "2024-03-08 10:00:00", "2025-01-01 00:00:00"},
+		{"30 2 * * mon-fri", "2024-03-08 03:00:00", "2024-03-11 02:30:00"},
+		{"0 0 * * 7", "2024-03-06 10:00:00", "2024-03-10 00:00:00"},
+		{"0 0 * * SUN", "2024-03-06 10:00:00", "2024-03-10 00:00:00"},
+		{"0 0 31 * *", "2024-04-01 00:00:00", "2024-05-31 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"0 0 1 jan-mar/2 *", "2024-01-15 00:00:00", "2024-03-01 00:00:00"},
+		// a restricted day of month and day of week match if either matches
+		{"0 12 15 * mon", "2024-03-02 00:00:00", "2024-03-04 12:00:00"},
+		{"0 12 3 * mon", "2024-03-02 00:00:00", "2024-03-03 12:00:00"},
+		{"0 12 ? * mon", "2024-03-02 00:00:00", "2024-03-04 12:00:00"},
+		{"@every 90m", "2024-03-08 10:07:30", "2024-03-08 11:37:30"},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.expression)
+		if err != nil {
+			t.Errorf("parseCron(%s): %v", test.expression, err)
+			continue
+		}
+		if next := schedule.Next(date(test.after)); !next.Equal(date(test.expected)) {
+			t.Errorf("%s after %s: expected %s, got %s", test.expression, test.after, test.expected, next)
+		}
+	}
+
+	// February 30th never comes
+	schedule, _ := parseCron("0 0 30 2 *")
+	if next := schedule.Next(date("2024-01-01 00:00:00")); !next.IsZero() {
+		t.Errorf("expected no next time for February 30th, got %s", next)
+	}
+}
+
+func TestCronNextLocation(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	schedule, _ := parseCron("0 9 * * *")
+	next := schedule.Next(time.Date(2024, 3, 8, 10, 0, 0, 0, location))
+	if !next.Equal(time.Date(2024, 3, 9, 9, 0, 0, 0, location)) || next.Location() != location {
+		t.Errorf("expected 9:00 in the location of the time, got %s", next)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"", "invalid cron expression"},
+		{"* * * *", "invalid cron expression"},
+		{"* * * * * *", "invalid cron expression"},
+		{"@fortnightly", "invalid cron expression"},
+		{"60 * * * *", "invalid minute: 60 is out of range 0-59"},
+		{"* 24 * * *", "invalid hour"},
+		{"* * 0 * *", "invalid day of month"},
+		{"* * * 13 *", "invalid month"},
+		{"* * * foo *", "invalid month: invalid value foo"},
+		{"* * * * 8", "invalid day of week"},
+		{"*/0 * * * *", "invalid minute: invalid step 0"},
+		{"*/x * * * *", "invalid minute: invalid step x"},
+		{"5-1 * * * *", "invalid minute: 5-1 is out of range"},
+		{"1-x * * * *", "invalid minute: invalid value x"},
+		{"@every 30s", "invalid duration"},
+		{"@every soon", "invalid duration"},
+	}
+	for _, test := range tests {
+		if _, err := parseCron(test.expression); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parseCron(%q): expected %q, got %v", test.expression, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// The end-to-end tests run export, offramp, onramp and import against the in-process fakes of all platforms,
+// in a new workspace, and check the files of the workspace and the resources in the fakes.
+
+// e2eSuite is the fakes and the workspace of a test.
+type e2eSuite struct {
+	*testing.T
+	fakes     *FakeServers
+	workspace string
+	storage   Storage
+	// location is the API Hub location of the fakes
+	location string
+}
+
+// newE2eSuite starts the fakes with the sample APIs and points apimsync at them. The workspace is a new
+// directory, unless another one is given, e.g. s3://apimsync-e2e/workspace on the fake object store.
+func newE2eSuite(t *testing.T, workspace string) *e2eSuite {
+	t.Helper()
+	s := &e2eSuite{T: t, fakes: startFakeServers(), workspace: workspace, location: "projects/" + fakeProject + "/locations/" + fakeRegion}
+	t.Cleanup(s.fakes.Close)
+	seedFakeServers(s.fakes)
+
+	dir := t.TempDir()
+	for name, value := range s.fakes.Env() {
+		t.Setenv(name, value)
+	}
+	// no Google credentials, so that Apigee and API Hub are called without a token
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(dir, "no-credentials.json"))
+	t.Setenv("APIMSYNC_SNAPSHOT_REPO", "")
+
+	if s.workspace == "" {
+		s.workspace = filepath.Join(dir, "workspace")
+	}
+	var err error
+	s.storage, err = workspaceStorage(s.workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// allInstances makes the sources export all Azure services and AWS regions.
+func (s *e2eSuite) allInstances() {
+	s.Setenv("AZURE_SERVICE_NAME", "*")
+	s.Setenv("AZURE_RESOURCE_GROUP", "")
+	s.Setenv("AWS_REGION", "*")
+}
+
+// webServer makes the handlers of the web server run in the workspace of the suite, with the given job workspaces,
+// until the test is done.
+func (s *e2eSuite) webServer(jobWorkspace string) {
+	workspace, previousJobWorkspace := webServerWorkspace, webServerJobWorkspace
+	webServerWorkspace, webServerJobWorkspace = s.workspace, jobWorkspace
+	s.Cleanup(func() { webServerWorkspace, webServerJobWorkspace = workspace, previousJobWorkspace })
+}
+
+// waitForJob waits until the job a handler started is done.
+func (s *e2eSuite) waitForJob(output *ApimJobOutput, err error) Job {
+	s.Helper()
+	if err != nil {
+		s.Fatalf("could not start the job: %v", err)
+	}
+	watchJob(context.Background(), output.Body.Id, func(event any) error { return nil })
+	job, _ := getJob(output.Body.Id)
+	return job
+}
+
+// runSources exports and offramps all sources, and onramps the general APIs to API Hub.
+// The files are written to the workspace of the suite, unless options gives another one.
+func (s *e2eSuite) runSources(options PlatformOptions) PlanningTarget {
+	s.Helper()
+	if options.Workspace == "" {
+		options.Workspace = s.workspace
+	}
+	for _, name := range []string{"azure", "aws"} {
+		source, _ := newSourcePlatform(name, options)
+		if status := source.Status(); !status.Connected {
+			s.Fatalf("%s is not connected: %s", name, status.Message)
+		}
+		if _, err := source.Export(context.Background()); err != nil {
+			s.Fatalf("%s export: %v", name, err)
+		}
+		if _, err := source.Offramp(context.Background()); err != nil {
+			s.Fatalf("%s offramp: %v", name, err)
+		}
+	}
+
+	target, _ := newTargetPlatform("apihub", options)
+	if _, err := target.Onramp(context.Background()); err != nil {
+		s.Fatalf("apihub onramp: %v", err)
+	}
+	return target.(PlanningTarget)
+}
+
+// sync runs the sources, and plans and applies a sync to API Hub.
+func (s *e2eSuite) sync(options PlatformOptions) {
+	s.Helper()
+	target := s.runSources(options)
+	plan, err := target.Plan(context.Background())
+	if err != nil {
+		s.Fatalf("apihub plan: %v", err)
+	}
+	if options.Workspace == "" {
+		options.Workspace = s.workspace
+	}
+	if err := applySyncPlan(context.Background(), options.Workspace, target, plan); err != nil {
+		s.Fatalf("apihub apply: %v", err)
+	}
+}
+
+// importApis runs the sources and imports the general APIs to API Hub.
+func (s *e2eSuite) importApis() StageResult {
+	s.Helper()
+	imported, err := s.runSources(PlatformOptions{}).Import(context.Background())
+	if err != nil {
+		s.Fatalf("apihub import: %v", err)
+	}
+	return imported
+}
+
+// exists returns true if a file or directory exists in the workspace of the suite.
+func (s *e2eSuite) exists(name string) bool {
+	_, err := s.storage.Stat(name)
+	return err == nil
+}
+
+func (s *e2eSuite) checkFile(name string) {
+	s.Helper()
+	if !s.exists(name) {
+		s.Errorf("file %s does not exist", name)
+	}
+}
+
+func (s *e2eSuite) checkNoFile(name string) {
+	s.Helper()
+	if s.exists(name) {
+		s.Errorf("file %s exists", name)
+	}
+}
+
+// checkNames checks the ids of the API Hub resources in a collection of the location, e.g. apis.
+func (s *e2eSuite) checkNames(collection string, expected ...string) {
+	s.Helper()
+	names := []string{}
+	for _, resourceName := range s.fakes.ApiHub.Names(s.location + "/" + collection) {
+		names = append(names, apiHubResourceId(resourceName))
+	}
+	if !slices.Equal(names, expected) {
+		s.Errorf("%s: expected %v, got %v", collection, expected, names)
+	}
+}
+
+// checkField checks a field of an API Hub resource of the location, e.g. deployments/petstore-v1-azure.
+func (s *e2eSuite) checkField(name string, field string, expected any) {
+	s.Helper()
+	resource, ok := s.fakes.ApiHub.Resource(s.location + "/" + name)
+	if !ok {
+		s.Errorf("%s does not exist", name)
+		return
+	}
+	if fmt.Sprint(resource[field]) != fmt.Sprint(expected) {
+		s.Errorf("%s %s: expected %v, got %v", name, field, expected, resource[field])
+	}
+}
+
+func (s *e2eSuite) checkSpec(name string, expected string) {
+	s.Helper()
+	resource, ok := s.fakes.ApiHub.Resource(s.location + "/" + name)
+	if !ok {
+		s.Errorf("spec %s does not exist", name)
+		return
+	}
+	contents, _ := resource["contents"].(map[string]any)
+	decoded, _ := b64.StdEncoding.DecodeString(fmt.Sprint(contents["contents"]))
+	if string(decoded) != expected {
+		s.Errorf("spec %s: expected %s, got %s", name, expected, decoded)
+	}
+}
+
+func (s *e2eSuite) checkSourceAttribute(name string, attributeId string, expected string) {
+	s.Helper()
+	resource, _ := s.fakes.ApiHub.Resource(s.location + "/" + name)
+	attributes, _ := resource["attributes"].(map[string]any)
+	values := []string{}
+	for attribute, value := range attributes {
+		if apiHubResourceId(attribute) != attributeId {
+			continue
+		}
+		stringValues, _ := value.(map[string]any)["stringValues"].(map[string]any)
+		for _, v := range stringValues["values"].([]any) {
+			values = append(values, fmt.Sprint(v))
+		}
+	}
+	if !slices.Contains(values, expected) {
+		s.Errorf("%s %s: expected %s in %v", name, attributeId, expected, values)
+	}
+}
+
+// checkNoChanges checks that a refreshed plan has nothing left to do.
+func (s *e2eSuite) checkNoChanges(options PlatformOptions) {
+	s.Helper()
+	options.Refresh = true
+	if options.Workspace == "" {
+		options.Workspace = s.workspace
+	}
+	target, _ := newTargetPlatform("apihub", options)
+	plan, err := target.(PlanningTarget).Plan(context.Background())
+	if err != nil {
+		s.Fatalf("apihub plan: %v", err)
+	}
+	if plan.Changes() != 0 {
+		printSyncPlan(plan)
+		s.Errorf("expected no changes, got %s", plan.Summary())
+	}
+}
+
+func TestImport(t *testing.T) {
+	s := newE2eSuite(t, "")
+	imported := s.importApis()
+	if !slices.Equal(imported.Apis, []string{"inventory", "orders", "petstore"}) {
+		t.Errorf("imported %v", imported.Apis)
+	}
+
+	s.checkFile("azure/fake-apim.json")
+	s.checkFile("azure/apiproxies/petstore/petstore-v1.json")
+	s.checkFile("azure/apiproxies/petstore/petstore-v1-oas.json")
+	s.checkFile("azure/apiproxies/orders/orders.json")
+	// revisions that aren't current are not exported
+	s.checkNoFile("azure/apiproxies/orders/orders;rev=2.json")
+	s.checkFile("aws/apiproxies/petstore/petstore-v2.json")
+	s.checkFile("aws/apiproxies/inventory/inventory-oas.json")
+	s.checkFile("general/apiproxies/petstore/petstore.json")
+	s.checkFile("general/apiproxies/petstore/petstore-v1-azure.json")
+	s.checkFile("general/apiproxies/petstore/petstore-v2-aws.json")
+	s.checkFile("general/apiproxies/petstore/petstore-v1-azure-oas.json")
+
+	s.checkNames("apis", "inventory", "orders", "petstore")
+	s.checkNames("deployments", "inventory-aws", "orders-azure", "petstore-v1-azure", "petstore-v2-aws")
+	s.checkNames("apis/petstore/versions", "petstore-v1", "petstore-v2")
+	s.checkNames("apis/petstore/versions/petstore-v1/specs", "petstore-v1-azure")
+	s.checkNames("apis/inventory/versions/inventory/specs", "inventory-aws")
+	s.checkField("deployments/petstore-v1-azure", "displayName", "Petstore v1")
+	s.checkField("deployments/petstore-v1-azure", "endpoints", []string{"https://fake-apim.azure-api.net/petstore"})
+	s.checkField("deployments/petstore-v2-aws", "displayName", "Petstore v2")
+	s.checkField("apis/petstore/versions/petstore-v1", "deployments", []string{s.location + "/deployments/petstore-v1-azure"})
+	s.checkSourceAttribute("deployments/petstore-v1-azure", apiHubDeploymentSourcePlatformAttribute, "azure-api-management")
+	s.checkSourceAttribute("apis/petstore", apiHubApiSourcePlatformAttribute, "aws-api-gateway")
+	s.checkSpec("apis/petstore/versions/petstore-v1/specs/petstore-v1-azure", fakeSpec("Petstore", "v1"))
+	s.checkSpec("apis/petstore/versions/petstore-v2/specs/petstore-v2-aws", fakeSpec("Petstore", "v2"))
+	s.checkNoChanges(PlatformOptions{Prune: true})
+}
+
+func TestSyncPrune(t *testing.T) {
+	tests := []struct {
+		name      string
+		protected []string
+		apis      []string
+	}{
+		{"prune", nil, []string{"inventory", "petstore"}},
+		{"protected", []string{"orders"}, []string{"inventory", "orders", "petstore"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newE2eSuite(t, "")
+			s.importApis()
+			// a version and spec made by hand in API Hub, which apimsync doesn't own
+			s.fakes.ApiHub.AddResource(s.location+"/apis/petstore/versions/manual", map[string]any{"displayName": "Manual"})
+			s.fakes.ApiHub.AddResource(s.location+"/apis/petstore/versions/manual/specs/manual", map[string]any{"displayName": "Manual"})
+
+			s.fakes.Azure.DeleteApi("orders")
+			s.fakes.Azure.DeleteApi("orders;rev=2")
+			s.fakes.Azure.AddApi(AzureApi{Name: "petstore-v1", Properties: AzureApiProperties{DisplayName: "Petstore", Description: "The new petstore API.", Path: "petstore", ApiVersion: "v1", IsCurrent: true}}, fakeSpec("Petstore", "v1.1"))
+			options := PlatformOptions{Prune: true, Protected: test.protected}
+			s.sync(options)
+
+			s.checkNoFile("general/apiproxies/orders")
+			s.checkFile("syncstate.json")
+			s.checkNames("apis", test.apis...)
+			s.checkNames("deployments", "inventory-aws", "petstore-v1-azure", "petstore-v2-aws")
+			s.checkNames("apis/petstore/versions", "manual", "petstore-v1", "petstore-v2")
+			s.checkNames("apis/petstore/versions/manual/specs", "manual")
+			s.checkField("deployments/petstore-v1-azure", "description", "The new petstore API.")
+			s.checkSpec("apis/petstore/versions/petstore-v1/specs/petstore-v1-azure", fakeSpec("Petstore", "v1.1"))
+			s.checkNoChanges(options)
+		})
+	}
+}
+
+func TestExportFailingApi(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.fakes.Azure.AddApi(AzureApi{Name: "billing", Properties: AzureApiProperties{DisplayName: "Billing", Path: "billing", IsCurrent: true}}, fakeSpec("Billing", "v1"))
+	s.fakes.Azure.FailingSchemas = []string{"billing"}
+
+	azure, _ := newSourcePlatform("azure", PlatformOptions{Workspace: s.workspace})
+	exported, err := azure.Export(context.Background())
+	if err == nil || exitCode(err) != 2 {
+		t.Errorf("expected a partial failure with exit code 2, got %v", err)
+	}
+	if len(exported.Apis) != 2 || len(exported.Failed) != 1 || exported.Failed[0].Api != "billing" {
+		t.Errorf("export result %v", exported)
+	}
+	s.checkFile("azure/apiproxies/petstore/petstore-v1.json")
+	s.checkNoFile("azure/apiproxies/billing")
+}
+
+func TestSyncAllInstances(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.importApis()
+
+	s.fakes.Azure.AddService("fake-group-eu", "fake-apim-eu")
+	s.fakes.Azure.AddServiceApi("fake-apim-eu", AzureApi{Name: "petstore-v1", Properties: AzureApiProperties{DisplayName: "Petstore", Description: "The petstore API in Europe.", Path: "petstore", ApiVersion: "v1", IsCurrent: true}}, fakeSpec("Petstore", "v1"))
+	s.fakes.Aws.Regions = append(s.fakes.Aws.Regions, "eu-west-1")
+	s.fakes.Aws.AddRegionApi("eu-west-1", "Petstore v2", "The petstore API on AWS in Europe.", "v2", fakeSpec("Petstore", "v2"))
+	s.allInstances()
+	s.sync(PlatformOptions{Prune: true})
+
+	s.checkFile("azure/instances/fake-apim-eu/fake-apim-eu.json")
+	s.checkFile("azure/instances/fake-apim/apiproxies/petstore/petstore-v1.json")
+	s.checkFile("azure/instances/fake-apim-eu/apiproxies/petstore/petstore-v1.json")
+	s.checkFile("aws/instances/eu-west-1/apiproxies/petstore/petstore-v2.json")
+	s.checkFile("general/apiproxies/petstore/petstore-v1-fake-apim-eu-azure.json")
+	// the export of the single service is pruned
+	s.checkNoFile("azure/apiproxies")
+	s.checkNames("deployments", "inventory-us-east-1-aws", "orders-fake-apim-azure", "petstore-v1-fake-apim-azure", "petstore-v1-fake-apim-eu-azure", "petstore-v2-eu-west-1-aws", "petstore-v2-us-east-1-aws")
+	s.checkNames("apis/petstore/versions", "petstore-v1", "petstore-v2")
+	s.checkField("apis/petstore/versions/petstore-v1", "deployments", []string{s.location + "/deployments/petstore-v1-fake-apim-azure", s.location + "/deployments/petstore-v1-fake-apim-eu-azure"})
+	s.checkField("deployments/petstore-v1-fake-apim-eu-azure", "endpoints", []string{"https://fake-apim-eu.azure-api.net/petstore"})
+	s.checkField("deployments/petstore-v2-eu-west-1-aws", "description", "The petstore API on AWS in Europe.")
+	s.checkNoChanges(PlatformOptions{Prune: true})
+}
+
+func TestApigeeExportImport(t *testing.T) {
+	s := newE2eSuite(t, "")
+	flags := ApigeeFlags{Project: fakeProject, Workspace: s.workspace}
+	if status := apigeeStatus(&flags); !status.Connected {
+		t.Fatalf("apigee is not connected: %s", status.Message)
+	}
+	if err := apigeeExport(&ApigeeFlags{Project: fakeProject, Workspace: s.workspace}); err != nil {
+		t.Fatalf("apigee export: %v", err)
+	}
+	s.checkFile("apigee/apiproxies/hello/apiproxy/hello.xml")
+	if err := apigeeImport(&ApigeeFlags{Project: fakeProject, Workspace: s.workspace}); err != nil {
+		t.Fatalf("apigee import: %v", err)
+	}
+	if revisions := s.fakes.Apigee.Revisions("hello"); revisions != 2 {
+		t.Errorf("expected the import to create revision 2, got %d revisions", revisions)
+	}
+}
+
+func TestObjectStoreWorkspace(t *testing.T) {
+	s := newE2eSuite(t, "s3://apimsync-e2e/workspace")
+	s.allInstances()
+	s.sync(PlatformOptions{Prune: true})
+
+	for _, key := range []string{"azure/instances/fake-apim/fake-apim.json", "general/apiproxies/petstore/petstore.json", "apihub/apiproxies/petstore/petstore.json", "syncstate.json"} {
+		if _, ok := s.fakes.ObjectStore.Object("apimsync-e2e", "workspace/"+key); !ok {
+			t.Errorf("object %s does not exist", key)
+		}
+	}
+	spec, _ := s.fakes.ObjectStore.Object("apimsync-e2e", "workspace/general/apiproxies/petstore/petstore-v2-us-east-1-aws-oas.json")
+	if spec != fakeSpec("Petstore", "v2") {
+		t.Errorf("expected the spec in the object store, got %s", spec)
+	}
+	s.checkNames("apis", "inventory", "orders", "petstore")
+	s.checkNoChanges(PlatformOptions{Prune: true})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// The base URLs of the platform APIs can be overridden with env variables, for example to run
+// against local emulators or fakes instead of the real cloud services.
+
+func apigeeUrl() string {
+	return endpointUrl("APIMSYNC_APIGEE_URL", "https://apigee.googleapis.com")
+}
+
+func apiHubUrl() string {
+	return endpointUrl("APIMSYNC_APIHUB_URL", "https://apihub.googleapis.com")
+}
+
+func azureManagementUrl() string {
+	return endpointUrl("APIMSYNC_AZURE_MANAGEMENT_URL", "https://management.azure.com")
+}
+
+func azureLoginUrl() string {
+	return endpointUrl("APIMSYNC_AZURE_LOGIN_URL", "https://login.microsoftonline.com")
+}
+
+// googleCertsUrl returns the JWKS URL of the keys Google signs OIDC ID tokens with.
+func googleCertsUrl() string {
+	return endpointUrl("APIMSYNC_GOOGLE_CERTS_URL", "https://www.googleapis.com/oauth2/v3/certs")
+}
+
+// awsUrl returns the custom endpoint of the API Gateway v2 client, or an empty string to use
+// the default AWS endpoint resolver.
+func awsUrl() string {
+	return endpointUrl("APIMSYNC_AWS_URL", "")
+}
+
+// awsEc2Url returns the custom endpoint of the EC2 API, which lists the enabled regions, or an empty
+// string to use the endpoint of the region.
+func awsEc2Url() string {
+	return endpointUrl("APIMSYNC_AWS_EC2_URL", "")
+}
+
+func endpointUrl(name string, defaultValue string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = defaultValue
+	}
+	return strings.TrimSuffix(value, "/")
+}
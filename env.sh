@@ -1,13 +1,68 @@
 
 # Apigee & API Hub environment variables
-APIGEE_PROJECT_ID=YOUR_GOOGLE_CLOUD_PROJECT_ID
+APIGEE_PROJECT=YOUR_GOOGLE_CLOUD_PROJECT_ID
 APIGEE_REGION=YOUR_GOOGLE_CLOUD_REGION
 
 # Azure environment variables
 AZURE_SUBSCRIPTION_ID=YOUR_AZURE_SUBSCRIPTION_ID
 AZURE_RESOURCE_GROUP=YOUR_AZURE_RESOURCE_GROUP
 AZURE_SERVICE_NAME=YOUR_AZURE_APIM_SERVICE_NAME
+# or several services (and subscriptions) separated by commas, or * for all services of the subscriptions
+# AZURE_SERVICE_NAME=*
 # Azure service principal credentials
 AZURE_CLIENT_ID=YOUR_AZURE_CLIENT_ID
 AZURE_CLIENT_SECRET=YOUR_AZURE_CLIENT_SECRET
 AZURE_TENANT_ID=YOUR_AZURE_TENANT_ID
+
+# Optional custom endpoints, for example local emulators
+# APIMSYNC_APIGEE_URL=http://localhost:9001
+# APIMSYNC_APIHUB_URL=http://localhost:9002
+# APIMSYNC_AZURE_MANAGEMENT_URL=http://localhost:9003
+# APIMSYNC_AZURE_LOGIN_URL=http://localhost:9003
+# APIMSYNC_AWS_URL=http://localhost:9004
+# APIMSYNC_AWS_EC2_URL=http://localhost:9004
+
+# Web server authentication, the web server doesn't start without one, unless APIMSYNC_AUTH=none
+# APIMSYNC_API_KEYS="YOUR_READ_KEY=read;YOUR_SYNC_KEY=read,sync"
+# APIMSYNC_GOOGLE_AUDIENCE=YOUR_CLOUD_RUN_URL
+# APIMSYNC_GOOGLE_PRINCIPALS="YOUR_SCHEDULER_SERVICE_ACCOUNT_EMAIL=sync"
+# APIMSYNC_JWT_JWKS_URL=https://YOUR_IDP/.well-known/jwks.json
+# APIMSYNC_JWT_ISSUER=https://YOUR_IDP/
+# APIMSYNC_JWT_AUDIENCE=apimsync
+# APIMSYNC_AUTH=none
+
+# Optional schedules for the web server to run syncs on, see README
+# APIMSYNC_SCHEDULES_FILE=schedules.json
+
+# Optional interval in seconds to refresh the platform status metrics at /metrics
+# APIMSYNC_METRICS_STATUS_INTERVAL=300
+
+# Optional OpenTelemetry trace export over OTLP (http/protobuf or grpc), see README
+# OTEL_EXPORTER_OTLP_ENDPOINT=http://localhost:4318
+# OTEL_EXPORTER_OTLP_HEADERS="api-key=YOUR_KEY"
+
+# Optional run lock, so that only one run at a time changes the local files, see README
+# APIMSYNC_LOCK_FILE=src/main/.apimsync.lock
+# APIMSYNC_LOCK_STALE=120
+
+# Optional workspace directory for the local files, and shared or temp workspaces for the web server syncs, see README
+# APIMSYNC_WORKSPACE=src/main
+# APIMSYNC_JOB_WORKSPACE=shared
+
+# Optional object store for workspaces like s3://bucket/prefix or gs://bucket/prefix, see README
+# APIMSYNC_STORAGE_URL=http://localhost:9000
+# APIMSYNC_STORAGE_REGION=us-east-1
+# APIMSYNC_STORAGE_ACCESS_KEY=YOUR_ACCESS_KEY
+# APIMSYNC_STORAGE_SECRET_KEY=YOUR_SECRET_KEY
+
+# Optional git repository to snapshot the workspace to after each export and offramp, see README
+# APIMSYNC_SNAPSHOT_REPO=https://github.com/my-org/apis.git
+# APIMSYNC_SNAPSHOT_BRANCH=main
+# APIMSYNC_SNAPSHOT_PUSH=origin
+# APIMSYNC_SNAPSHOT_AUTHOR="apimsync <apimsync@localhost>"
+
+# Optional config file with profiles, env variables set here override it, see README
+# APIMSYNC_CONFIG=apimsync.yaml
+# APIMSYNC_PROFILE=prod
+# APIMSYNC_INCLUDE=petstore*,orders*
+# APIMSYNC_EXCLUDE=internal-*
@@ -0,0 +1,887 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeServers are in-process fakes of the subset of the Azure Management, API Hub, Apigee and
+// AWS API Gateway v2 APIs that apimsync calls, and of an S3 compatible object store, with in-memory
+// state. Pointing apimsync at them with Env lets the whole pipeline run without cloud accounts.
+type FakeServers struct {
+	Azure       *FakeAzure
+	ApiHub      *FakeApiHub
+	Apigee      *FakeApigee
+	Aws         *FakeAws
+	ObjectStore *FakeObjectStore
+}
+
+const (
+	fakeAzureToken    = "fake-azure-token"
+	fakeSubscription  = "fake-subscription"
+	fakeResourceGroup = "fake-group"
+	fakeProject       = "fake-project"
+	fakeRegion        = "us-central1"
+	fakeAwsRegion     = "us-east-1"
+	fakeStorageKey    = "fake-storage-key"
+)
+
+func startFakeServers() *FakeServers {
+	return &FakeServers{
+		Azure:       newFakeAzure(),
+		ApiHub:      newFakeApiHub(),
+		Apigee:      newFakeApigee(),
+		Aws:         newFakeAws(),
+		ObjectStore: newFakeObjectStore(),
+	}
+}
+
+func (f *FakeServers) Close() {
+	f.Azure.Server.Close()
+	f.ApiHub.Server.Close()
+	f.Apigee.Server.Close()
+	f.Aws.Server.Close()
+	f.ObjectStore.Server.Close()
+}
+
+// Env returns the environment variables that point apimsync at the fakes.
+func (f *FakeServers) Env() map[string]string {
+	return map[string]string{
+		"APIMSYNC_APIGEE_URL":           f.Apigee.Server.URL,
+		"APIMSYNC_APIHUB_URL":           f.ApiHub.Server.URL,
+		"APIMSYNC_AZURE_MANAGEMENT_URL": f.Azure.Server.URL,
+		"APIMSYNC_AZURE_LOGIN_URL":      f.Azure.Server.URL,
+		"APIMSYNC_AWS_URL":              f.Aws.Server.URL,
+		"APIMSYNC_AWS_EC2_URL":          f.Aws.Server.URL,
+		"APIMSYNC_HTTP_RATE":            "1000",
+		"AZURE_SUBSCRIPTION_ID":         fakeSubscription,
+		"AZURE_RESOURCE_GROUP":          fakeResourceGroup,
+		"AZURE_SERVICE_NAME":            f.Azure.Service.Name,
+		"AZURE_CLIENT_ID":               "fake-client",
+		"AZURE_CLIENT_SECRET":           "fake-secret",
+		"AZURE_TENANT_ID":               "fake-tenant",
+		"AZURE_TOKEN":                   "",
+		"APIGEE_PROJECT":                fakeProject,
+		"APIGEE_REGION":                 fakeRegion,
+		"AWS_REGION":                    fakeAwsRegion,
+		"AWS_ACCESS_KEY_ID":             "fake-access-key",
+		"AWS_SECRET_ACCESS_KEY":         "fake-secret-key",
+		"APIMSYNC_STORAGE_URL":          f.ObjectStore.Server.URL,
+		"APIMSYNC_STORAGE_ACCESS_KEY":   fakeStorageKey,
+		"APIMSYNC_STORAGE_SECRET_KEY":   "fake-storage-secret",
+	}
+}
+
+// seedFakeServers adds sample APIs to the fakes. The page sizes are small so that every list call
+// has to follow its next page links.
+func seedFakeServers(f *FakeServers) {
+	f.Azure.AddApi(AzureApi{Name: "petstore-v1", Properties: AzureApiProperties{DisplayName: "Petstore", Description: "The petstore API.", Path: "petstore", ApiVersion: "v1", IsCurrent: true}}, fakeSpec("Petstore", "v1"))
+	f.Azure.AddApi(AzureApi{Name: "orders", Properties: AzureApiProperties{DisplayName: "Orders", Description: "The orders API.", Path: "orders", IsCurrent: true}}, fakeSpec("Orders", "1.0"))
+	f.Azure.AddApi(AzureApi{Name: "orders;rev=2", Properties: AzureApiProperties{DisplayName: "Orders", Description: "The orders API, revision 2.", Path: "orders", ApiRevision: "2"}}, "")
+
+	f.Aws.AddApi("Petstore v2", "The petstore API on AWS.", "v2", fakeSpec("Petstore", "v2"))
+	f.Aws.AddApi("Inventory", "The inventory API.", "1.0", fakeSpec("Inventory", "1.0"))
+
+	f.Apigee.AddProxy("hello", fakeApigeeBundle("hello"))
+}
+
+func fakeSpec(title string, version string) string {
+	return `{"openapi":"3.0.1","info":{"title":"` + title + `","version":"` + version + `"},"paths":{}}`
+}
+
+// fakeApigeeBundle returns a minimal proxy bundle zip.
+func fakeApigeeBundle(name string) []byte {
+	buffer := &bytes.Buffer{}
+	w := zip.NewWriter(buffer)
+	file, _ := w.Create("apiproxy/" + name + ".xml")
+	file.Write([]byte(`<APIProxy name="` + name + `"><Description>Fake proxy</Description></APIProxy>`))
+	w.Close()
+	return buffer.Bytes()
+}
+
+func writeFakeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeFakeError writes an error in the Google API error format, which the Azure and AWS clients also accept.
+func writeFakeError(w http.ResponseWriter, status int, message string) {
+	writeFakeJson(w, status, map[string]any{
+		"error": map[string]any{"code": status, "message": message, "status": http.StatusText(status)},
+	})
+}
+
+// fakePage returns the items of a page and the token of the next page, which is the index of its first item.
+func fakePage[T any](items []T, pageToken string, pageSize int) ([]T, string) {
+	start, _ := strconv.Atoi(pageToken)
+	start = min(max(start, 0), len(items))
+	end := len(items)
+	if pageSize > 0 {
+		end = min(start+pageSize, len(items))
+	}
+	next := ""
+	if end < len(items) {
+		next = strconv.Itoa(end)
+	}
+	return items[start:end], next
+}
+
+// FakeAzure fakes the Azure AD token endpoint and the API Management services, APIs and schemas.
+// Service is the service the env variables point at, more services can be added with AddService.
+type FakeAzure struct {
+	Server   *httptest.Server
+	Service  AzureService
+	PageSize int
+	// FailingSchemas are the APIs whose schema requests fail, to test partial failures.
+	FailingSchemas []string
+
+	mu       sync.Mutex
+	services map[string]AzureService
+	apis     map[string]map[string]AzureApi
+	schemas  map[string]map[string]AzureApiSchema
+}
+
+func newFakeAzure() *FakeAzure {
+	f := &FakeAzure{PageSize: 2, services: map[string]AzureService{}, apis: map[string]map[string]AzureApi{}, schemas: map[string]map[string]AzureApiSchema{}}
+	servicePath := "/subscriptions/{subscription}/resourceGroups/{group}/providers/Microsoft.ApiManagement/service/{service}"
+	listServices := f.handle(func(w http.ResponseWriter, r *http.Request) {
+		services := []AzureService{}
+		for _, name := range sortedKeys(f.services) {
+			service := f.services[name]
+			if azureIdSegment(service.Id, "subscriptions") == r.PathValue("subscription") && (r.PathValue("group") == "" || azureResourceGroup(service.Id) == r.PathValue("group")) {
+				services = append(services, service)
+			}
+		}
+
+		page, next := fakePage(services, r.URL.Query().Get("$skip"), f.PageSize)
+		result := map[string]any{"value": page}
+		if next != "" {
+			result["nextLink"] = f.Server.URL + r.URL.Path + "?api-version=" + r.URL.Query().Get("api-version") + "&$skip=" + next
+		}
+		writeFakeJson(w, http.StatusOK, result)
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{tenant}/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		// apimsync doesn't send a form content type, so the body is parsed directly
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		if form.Get("client_id") == "" || form.Get("client_secret") == "" {
+			writeFakeError(w, http.StatusUnauthorized, "missing client credentials")
+			return
+		}
+		writeFakeJson(w, http.StatusOK, AzureTokenResponse{AccessToken: fakeAzureToken, TokenType: "Bearer", Resource: form.Get("resource")})
+	})
+	mux.HandleFunc("GET /subscriptions/{subscription}/providers/Microsoft.ApiManagement/service", listServices)
+	mux.HandleFunc("GET /subscriptions/{subscription}/resourceGroups/{group}/providers/Microsoft.ApiManagement/service", listServices)
+	mux.HandleFunc("GET "+servicePath, f.handle(func(w http.ResponseWriter, r *http.Request) {
+		writeFakeJson(w, http.StatusOK, f.services[r.PathValue("service")])
+	}))
+	mux.HandleFunc("GET "+servicePath+"/apis", f.handle(func(w http.ResponseWriter, r *http.Request) {
+		apis := []AzureApi{}
+		serviceApis := f.apis[r.PathValue("service")]
+		for _, name := range sortedKeys(serviceApis) {
+			apis = append(apis, serviceApis[name])
+		}
+
+		page, next := fakePage(apis, r.URL.Query().Get("$skip"), f.PageSize)
+		result := AzureApis{Value: page}
+		if next != "" {
+			result.NextLink = f.Server.URL + r.URL.Path + "?api-version=" + r.URL.Query().Get("api-version") + "&$skip=" + next
+		}
+		writeFakeJson(w, http.StatusOK, result)
+	}))
+	mux.HandleFunc("GET "+servicePath+"/schemas/{api}", f.handle(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(f.FailingSchemas, r.PathValue("api")) {
+			writeFakeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		schema, ok := f.schemas[r.PathValue("service")][r.PathValue("api")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "schema not found")
+			return
+		}
+		writeFakeJson(w, http.StatusOK, schema)
+	}))
+
+	f.Server = httptest.NewServer(mux)
+	f.Service = f.AddService(fakeResourceGroup, "fake-apim")
+	return f
+}
+
+// handle checks the token and the service name, if the path has one, and locks the state for the handler.
+func (f *FakeAzure) handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeAzureToken {
+			writeFakeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if service, ok := f.services[r.PathValue("service")]; r.PathValue("service") != "" && (!ok || azureResourceGroup(service.Id) != r.PathValue("group")) {
+			writeFakeError(w, http.StatusNotFound, "service not found")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// AddService adds a service to the fake subscription.
+func (f *FakeAzure) AddService(resourceGroup string, name string) AzureService {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	service := AzureService{
+		Id:       "/subscriptions/" + fakeSubscription + "/resourceGroups/" + resourceGroup + "/providers/Microsoft.ApiManagement/service/" + name,
+		Name:     name,
+		Location: "West Europe",
+		Properties: AzureServiceProperties{
+			DeveloperPortalUrl: "https://" + name + ".developer.azure-api.net",
+			GatewayUrl:         "https://" + name + ".azure-api.net",
+			PublisherEmail:     "apis@example.com",
+			PublisherName:      "Example",
+		},
+	}
+	f.services[name] = service
+	f.apis[name] = map[string]AzureApi{}
+	f.schemas[name] = map[string]AzureApiSchema{}
+	return service
+}
+
+// AddApi adds or replaces an API of Service, with an OpenAPI spec if spec is not empty.
+func (f *FakeAzure) AddApi(api AzureApi, spec string) {
+	f.AddServiceApi(f.Service.Name, api, spec)
+}
+
+// AddServiceApi adds or replaces an API of a service, with an OpenAPI spec if spec is not empty.
+func (f *FakeAzure) AddServiceApi(service string, api AzureApi, spec string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	api.Id = f.services[service].Id + "/apis/" + api.Name
+	api.Type_ = "Microsoft.ApiManagement/service/apis"
+	f.apis[service][api.Name] = api
+	if spec != "" {
+		f.schemas[service][api.Name] = AzureApiSchema{
+			Id:         api.Id + "/schemas/" + api.Name,
+			Type:       "Microsoft.ApiManagement/service/apis/schemas",
+			Name:       api.Name,
+			Properties: AzureApiSchemaProperties{SchemaType: "json", Document: spec},
+		}
+	}
+}
+
+// DeleteApi deletes an API of Service.
+func (f *FakeAzure) DeleteApi(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.apis[f.Service.Name], name)
+	delete(f.schemas[f.Service.Name], name)
+}
+
+// FakeApiHub fakes the API Hub resources as a generic tree of named resources, so that
+// apis, versions, specs, deployments and attributes all work the same way.
+type FakeApiHub struct {
+	Server   *httptest.Server
+	PageSize int
+
+	mu        sync.Mutex
+	resources map[string]map[string]any
+	contents  map[string]any
+}
+
+// apiHubIdParams are the query parameters that give the id of a new resource in a collection.
+var apiHubIdParams = map[string]string{
+	"apis":        "apiId",
+	"versions":    "versionId",
+	"specs":       "specId",
+	"deployments": "deploymentId",
+	"attributes":  "attributeId",
+}
+
+func newFakeApiHub() *FakeApiHub {
+	f := &FakeApiHub{PageSize: 2, resources: map[string]map[string]any{}, contents: map[string]any{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		name := r.PathValue("name")
+		if strings.HasSuffix(name, ":contents") && r.Method == http.MethodGet {
+			contents, ok := f.contents[strings.TrimSuffix(name, ":contents")]
+			if !ok {
+				writeFakeError(w, http.StatusNotFound, "spec not found")
+				return
+			}
+			writeFakeJson(w, http.StatusOK, contents)
+			return
+		}
+
+		// names are pairs of collection and id, so an odd number of segments is a collection
+		segments := strings.Split(name, "/")
+		isCollection := len(segments)%2 == 1
+		switch {
+		case isCollection && r.Method == http.MethodGet:
+			f.list(w, r, name)
+		case isCollection && r.Method == http.MethodPost:
+			f.create(w, r, name, segments[len(segments)-1])
+		case !isCollection && r.Method == http.MethodGet:
+			resource, ok := f.resources[name]
+			if !ok {
+				writeFakeError(w, http.StatusNotFound, name+" not found")
+				return
+			}
+			writeFakeJson(w, http.StatusOK, resource)
+		case !isCollection && r.Method == http.MethodPatch:
+			f.update(w, r, name)
+		case !isCollection && r.Method == http.MethodDelete:
+			f.delete(w, r, name)
+		default:
+			writeFakeError(w, http.StatusMethodNotAllowed, r.Method+" is not supported for "+name)
+		}
+	})
+
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *FakeApiHub) list(w http.ResponseWriter, r *http.Request, collection string) {
+	items := []map[string]any{}
+	for _, name := range f.children(collection) {
+		items = append(items, f.resources[name])
+	}
+
+	page, next := fakePage(items, r.URL.Query().Get("pageToken"), f.PageSize)
+	result := map[string]any{collection[strings.LastIndex(collection, "/")+1:]: page}
+	if next != "" {
+		result["nextPageToken"] = next
+	}
+	writeFakeJson(w, http.StatusOK, result)
+}
+
+func (f *FakeApiHub) create(w http.ResponseWriter, r *http.Request, collection string, kind string) {
+	id := r.URL.Query().Get(apiHubIdParams[kind])
+	if id == "" {
+		writeFakeError(w, http.StatusBadRequest, "no id given for the new resource")
+		return
+	}
+
+	parent := collection[:max(strings.LastIndex(collection, "/"), 0)]
+	if strings.Count(parent, "/") > 3 {
+		if _, ok := f.resources[parent]; !ok {
+			writeFakeError(w, http.StatusNotFound, "parent "+parent+" not found")
+			return
+		}
+	}
+
+	name := collection + "/" + id
+	if _, ok := f.resources[name]; ok {
+		writeFakeError(w, http.StatusConflict, name+" already exists")
+		return
+	}
+
+	var resource map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil || resource == nil {
+		writeFakeError(w, http.StatusBadRequest, "invalid resource")
+		return
+	}
+	resource["name"] = name
+	resource["createTime"] = time.Now().UTC().Format(time.RFC3339)
+	if contents, ok := resource["contents"]; ok {
+		f.contents[name] = contents
+		delete(resource, "contents")
+	}
+	f.resources[name] = resource
+	writeFakeJson(w, http.StatusOK, resource)
+}
+
+func (f *FakeApiHub) update(w http.ResponseWriter, r *http.Request, name string) {
+	resource, ok := f.resources[name]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, name+" not found")
+		return
+	}
+
+	var patch map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "invalid resource")
+		return
+	}
+
+	updateMask := r.URL.Query().Get("updateMask")
+	if updateMask == "" {
+		writeFakeError(w, http.StatusBadRequest, "no updateMask given")
+		return
+	}
+	for _, field := range strings.Split(updateMask, ",") {
+		value, set := patch[field]
+		switch {
+		case field == "contents":
+			f.contents[name] = value
+		case set:
+			resource[field] = value
+		default:
+			delete(resource, field)
+		}
+	}
+	resource["updateTime"] = time.Now().UTC().Format(time.RFC3339)
+	writeFakeJson(w, http.StatusOK, resource)
+}
+
+func (f *FakeApiHub) delete(w http.ResponseWriter, r *http.Request, name string) {
+	if _, ok := f.resources[name]; !ok {
+		writeFakeError(w, http.StatusNotFound, name+" not found")
+		return
+	}
+
+	descendants := []string{}
+	for resourceName := range f.resources {
+		if strings.HasPrefix(resourceName, name+"/") {
+			descendants = append(descendants, resourceName)
+		}
+	}
+	if len(descendants) > 0 && r.URL.Query().Get("force") != "true" {
+		writeFakeError(w, http.StatusBadRequest, name+" has child resources, use force to delete them")
+		return
+	}
+
+	for _, resourceName := range append(descendants, name) {
+		delete(f.resources, resourceName)
+		delete(f.contents, resourceName)
+	}
+	writeFakeJson(w, http.StatusOK, map[string]any{})
+}
+
+// children returns the sorted names of the resources directly in a collection.
+func (f *FakeApiHub) children(collection string) []string {
+	names := []string{}
+	for name := range f.resources {
+		if strings.HasPrefix(name, collection+"/") && !strings.Contains(name[len(collection)+1:], "/") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Resource returns a copy of a resource, with the contents of specs.
+func (f *FakeApiHub) Resource(name string) (map[string]any, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resource, ok := f.resources[name]
+	if !ok {
+		return nil, false
+	}
+	result := map[string]any{}
+	for key, value := range resource {
+		result[key] = value
+	}
+	if contents, ok := f.contents[name]; ok {
+		result["contents"] = contents
+	}
+	return result, true
+}
+
+// AddResource adds a resource that wasn't created by apimsync, e.g. a version made by hand in the console.
+func (f *FakeApiHub) AddResource(name string, resource map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource["name"] = name
+	f.resources[name] = resource
+}
+
+// Names returns the sorted names of the resources directly in a collection.
+func (f *FakeApiHub) Names(collection string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.children(collection)
+}
+
+// FakeApigee fakes the Apigee API proxies of an organization, with a bundle per revision.
+type FakeApigee struct {
+	Server   *httptest.Server
+	PageSize int
+
+	mu      sync.Mutex
+	proxies map[string][][]byte
+}
+
+func newFakeApigee() *FakeApigee {
+	f := &FakeApigee{PageSize: 2, proxies: map[string][][]byte{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/organizations/{org}/apis", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		proxies := []ApigeeApi{}
+		for _, name := range sortedKeys(f.proxies) {
+			proxy := ApigeeApi{Name: name, ApiProxyType: "PROGRAMMABLE"}
+			for revision := range f.proxies[name] {
+				proxy.Revision = append(proxy.Revision, strconv.Itoa(revision+1))
+			}
+			proxies = append(proxies, proxy)
+		}
+
+		page, next := fakePage(proxies, r.URL.Query().Get("pageToken"), f.PageSize)
+		writeFakeJson(w, http.StatusOK, ApigeeProxies{Proxies: page, NextPageToken: next})
+	})
+	mux.HandleFunc("GET /v1/organizations/{org}/apis/{api}/revisions/{revision}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		revisions := f.proxies[r.PathValue("api")]
+		revision, _ := strconv.Atoi(r.PathValue("revision"))
+		if revision < 1 || revision > len(revisions) {
+			writeFakeError(w, http.StatusNotFound, "revision not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write(revisions[revision-1])
+	})
+	mux.HandleFunc("POST /v1/organizations/{org}/apis", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" || r.URL.Query().Get("action") != "import" {
+			writeFakeError(w, http.StatusBadRequest, "only importing a bundle is supported")
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, "no bundle file given")
+			return
+		}
+		bundle, _ := io.ReadAll(file)
+		archive, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, "the bundle is not a zip file")
+			return
+		}
+		if _, err := archive.Open("apiproxy/" + name + ".xml"); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "the bundle has no apiproxy/"+name+".xml")
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.proxies[name] = append(f.proxies[name], bundle)
+		writeFakeJson(w, http.StatusOK, map[string]string{"name": name, "revision": strconv.Itoa(len(f.proxies[name]))})
+	})
+	mux.HandleFunc("DELETE /v1/organizations/{org}/apis/{api}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if _, ok := f.proxies[r.PathValue("api")]; !ok {
+			writeFakeError(w, http.StatusNotFound, "proxy not found")
+			return
+		}
+		delete(f.proxies, r.PathValue("api"))
+		writeFakeJson(w, http.StatusOK, map[string]string{"name": r.PathValue("api")})
+	})
+
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// AddProxy adds a new revision of a proxy.
+func (f *FakeApigee) AddProxy(name string, bundle []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.proxies[name] = append(f.proxies[name], bundle)
+}
+
+// Revisions returns how many revisions a proxy has.
+func (f *FakeApigee) Revisions(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.proxies[name])
+}
+
+// FakeAws fakes the API Gateway v2 APIs and their OpenAPI exports in each region, and the EC2 API
+// that lists the enabled regions. The region of a request is taken from its signature.
+type FakeAws struct {
+	Server   *httptest.Server
+	PageSize int
+	// Regions are the regions that are enabled.
+	Regions []string
+
+	mu      sync.Mutex
+	apis    map[string]map[string]any
+	regions map[string]string
+	specs   map[string]string
+}
+
+func newFakeAws() *FakeAws {
+	f := &FakeAws{PageSize: 1, Regions: []string{fakeAwsRegion}, apis: map[string]map[string]any{}, regions: map[string]string{}, specs: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v2/apis", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		apis := []map[string]any{}
+		for _, id := range sortedKeys(f.apis) {
+			if f.regions[id] == fakeAwsRequestRegion(r) {
+				apis = append(apis, f.apis[id])
+			}
+		}
+
+		page, next := fakePage(apis, r.URL.Query().Get("nextToken"), f.PageSize)
+		result := map[string]any{"items": page}
+		if next != "" {
+			result["nextToken"] = next
+		}
+		writeFakeJson(w, http.StatusOK, result)
+	})
+	mux.HandleFunc("GET /v2/apis/{apiId}/exports/{specification}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		spec, ok := f.specs[r.PathValue("apiId")]
+		if !ok {
+			writeFakeJson(w, http.StatusNotFound, map[string]string{"message": "Invalid API identifier specified"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(spec))
+	})
+	mux.HandleFunc("POST /{$}", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		if form.Get("Action") != "DescribeRegions" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		response := `<DescribeRegionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><regionInfo>`
+		for _, region := range f.Regions {
+			response += `<item><regionName>` + region + `</regionName><regionEndpoint>ec2.` + region + `.amazonaws.com</regionEndpoint></item>`
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(response + `</regionInfo></DescribeRegionsResponse>`))
+	})
+
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// fakeAwsRequestRegion returns the region in the credential scope of a signed request.
+func fakeAwsRequestRegion(r *http.Request) string {
+	_, credential, _ := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+	scope := strings.Split(credential, "/")
+	if len(scope) < 3 {
+		return fakeAwsRegion
+	}
+	return scope[2]
+}
+
+// AddApi adds an HTTP API to the default region and returns its id.
+func (f *FakeAws) AddApi(name string, description string, version string, spec string) string {
+	return f.AddRegionApi(fakeAwsRegion, name, description, version, spec)
+}
+
+// AddRegionApi adds an HTTP API to a region and returns its id.
+func (f *FakeAws) AddRegionApi(region string, name string, description string, version string, spec string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := "fake" + strconv.Itoa(len(f.apis)+1)
+	f.apis[id] = map[string]any{
+		"apiId":                    id,
+		"name":                     name,
+		"description":              description,
+		"version":                  version,
+		"protocolType":             "HTTP",
+		"apiEndpoint":              "https://" + id + ".execute-api." + region + ".amazonaws.com",
+		"routeSelectionExpression": "$request.method $request.path",
+		"createdDate":              time.Now().UTC().Format(time.RFC3339),
+	}
+	f.regions[id] = region
+	if spec != "" {
+		f.specs[id] = spec
+	}
+	return id
+}
+
+func (f *FakeAws) DeleteApi(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for id, api := range f.apis {
+		if api["name"] == name {
+			delete(f.apis, id)
+			delete(f.regions, id)
+			delete(f.specs, id)
+		}
+	}
+}
+
+// FakeObjectStore fakes the object and ListObjectsV2 requests of an S3 compatible object store, like a local
+// MinIO. Buckets are created by putting objects into them.
+type FakeObjectStore struct {
+	Server   *httptest.Server
+	PageSize int
+
+	mu      sync.Mutex
+	objects map[string]map[string]fakeObject
+}
+
+type fakeObject struct {
+	data    []byte
+	created time.Time
+}
+
+func (o fakeObject) etag() string {
+	hash := sha256.Sum256(o.data)
+	return "\"" + hex.EncodeToString(hash[:16]) + "\""
+}
+
+func newFakeObjectStore() *FakeObjectStore {
+	f := &FakeObjectStore{PageSize: 2, objects: map[string]map[string]fakeObject{}}
+
+	mux := http.NewServeMux()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential="+fakeStorageKey+"/") || !strings.Contains(r.Header.Get("Authorization"), "/s3/aws4_request") {
+			writeFakeError(w, http.StatusForbidden, "the request is not signed with the storage key")
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		bucket, key := r.PathValue("bucket"), r.PathValue("key")
+		object, exists := f.objects[bucket][key]
+		switch {
+		case r.Method == http.MethodGet && key == "":
+			f.list(w, r, bucket)
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+			w.Header().Set("Last-Modified", object.created.Format(http.TimeFormat))
+			w.Header().Set("ETag", object.etag())
+			w.Write(object.data)
+		case r.Method == http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			hash := sha256.Sum256(data)
+			if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+				writeFakeError(w, http.StatusBadRequest, "the content hash does not match")
+				return
+			}
+			if exists && r.Header.Get("If-None-Match") == "*" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			if f.objects[bucket] == nil {
+				f.objects[bucket] = map[string]fakeObject{}
+			}
+			f.objects[bucket][key] = fakeObject{data: data, created: time.Now().UTC()}
+		case r.Method == http.MethodDelete:
+			if match := r.Header.Get("If-Match"); match != "" && !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			} else if match != "" && match != object.etag() {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			delete(f.objects[bucket], key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+	mux.HandleFunc("/{bucket}", handler)
+	mux.HandleFunc("/{bucket}/{key...}", handler)
+
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// fakeObjectList is a page of the ListObjectsV2 response.
+type fakeObjectList struct {
+	XMLName               xml.Name                  `xml:"ListBucketResult"`
+	IsTruncated           bool                      `xml:"IsTruncated"`
+	KeyCount              int                       `xml:"KeyCount"`
+	NextContinuationToken string                    `xml:"NextContinuationToken,omitempty"`
+	Contents              []fakeObjectListObject    `xml:"Contents"`
+	CommonPrefixes        []fakeObjectListDirectory `xml:"CommonPrefixes"`
+}
+
+type fakeObjectListObject struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type fakeObjectListDirectory struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// list writes a page of the objects with the prefix, after the continuation token, which is the last key or
+// common prefix of the previous page, grouped into common prefixes by the delimiter.
+func (f *FakeObjectStore) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	if r.URL.Query().Get("list-type") != "2" {
+		writeFakeError(w, http.StatusBadRequest, "only ListObjectsV2 is supported")
+		return
+	}
+	prefix, delimiter, marker := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"), r.URL.Query().Get("continuation-token")
+	pageSize := f.PageSize
+	if maxKeys, err := strconv.Atoi(r.URL.Query().Get("max-keys")); err == nil && maxKeys < pageSize {
+		pageSize = maxKeys
+	}
+
+	result := fakeObjectList{}
+	last := ""
+	for _, key := range sortedKeys(f.objects[bucket]) {
+		if !strings.HasPrefix(key, prefix) || key <= marker || (delimiter != "" && strings.HasSuffix(marker, delimiter) && strings.HasPrefix(key, marker)) {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == pageSize {
+			result.IsTruncated = true
+			result.NextContinuationToken = last
+			break
+		}
+		if i := strings.Index(strings.TrimPrefix(key, prefix), delimiter); delimiter != "" && i >= 0 {
+			last = prefix + strings.TrimPrefix(key, prefix)[:i+len(delimiter)]
+			result.CommonPrefixes = append(result.CommonPrefixes, fakeObjectListDirectory{Prefix: last})
+			marker = last
+			continue
+		}
+		last = key
+		object := f.objects[bucket][key]
+		result.Contents = append(result.Contents, fakeObjectListObject{Key: key, Size: int64(len(object.data)), LastModified: object.created})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	bytes, _ := xml.Marshal(result)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(bytes)
+}
+
+// Object returns the content of an object.
+func (f *FakeObjectStore) Object(bucket string, key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[bucket][key]
+	return string(object.data), ok
+}
@@ -2,37 +2,264 @@ package main
 
 import (
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"os"
+	"reflect"
 	"regexp"
+	"slices"
+	"strings"
 )
 
 func generalCleanLocal(flags *GeneralFlags) error {
-	var baseDir = "src/main/general"
-	os.RemoveAll(baseDir)
-	return nil
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+	return storage.RemoveAll("general")
 }
 
-func writeGeneralApi(name string, generalApi GeneralApi) error {
-	baseDir := "src/main/general/apiproxies"
+// mergeGeneralApi merges the platform APIs of a general API, e.g. petstore-azure.json and petstore-aws.json,
+// into the aggregate petstore.json. Each field is taken from the first platform in its precedence order
+// that has a value, and the platform it came from is recorded in the provenance.
+func mergeGeneralApi(storage Storage, name string, precedence GeneralMergePrecedence) error {
+	baseDir := "general/apiproxies"
 
+	var platformNames []string
+	var platformApis []map[string]any
+	fileEntries, err := storage.ReadDir(baseDir + "/" + name)
+	if err != nil {
+		return err
+	}
+	for _, f := range fileEntries {
+		platformName := generalPlatformName(f.Name())
+		if platformName == "" {
+			continue
+		}
+		byteValue, err := storage.ReadFile(baseDir + "/" + name + "/" + f.Name())
+		if err != nil {
+			return err
+		}
+		var platformApi map[string]any
+		json.Unmarshal(byteValue, &platformApi)
+		platformNames = append(platformNames, platformName)
+		platformApis = append(platformApis, platformApi)
+	}
+
+	if len(platformApis) == 0 {
+		return nil
+	}
+
+	merged := map[string]any{}
+	provenance := map[string]string{}
+	for _, field := range generalApiFields() {
+		for _, i := range precedence.order(field, platformNames, platformApis) {
+			if value, ok := platformApis[i][field].(string); ok && value != "" {
+				merged[field] = value
+				provenance[field], _ = platformApis[i]["platformId"].(string)
+				break
+			}
+		}
+	}
+
+	var generalApi GeneralApi
+	mergedBytes, _ := json.Marshal(merged)
+	json.Unmarshal(mergedBytes, &generalApi)
 	generalApi.Name = name
 	var re = regexp.MustCompile(` v\d+`)
 	generalApi.DisplayName = re.ReplaceAllString(generalApi.DisplayName, "")
+	generalApi.Provenance = provenance
 
 	newBytes, _ := json.MarshalIndent(generalApi, "", "  ")
+	return storage.WriteFile(baseDir+"/"+name+"/"+name+".json", newBytes)
+}
+
+// GeneralMergePrecedence lists for each field, or "*" for all fields, the platforms to take the value from first.
+// Platforms can be given by name (azure) or platform ID (azure-api-management).
+type GeneralMergePrecedence map[string][]string
+
+// parseGeneralMergePrecedence parses precedence in the form "*=azure,aws;description=azure;gatewayUrl=aws".
+func parseGeneralMergePrecedence(value string) GeneralMergePrecedence {
+	precedence := GeneralMergePrecedence{}
+	for _, rule := range strings.Split(value, ";") {
+		field, platforms, found := strings.Cut(strings.TrimSpace(rule), "=")
+		if !found {
+			continue
+		}
+		for _, platform := range strings.Split(platforms, ",") {
+			if platform = strings.TrimSpace(platform); platform != "" {
+				precedence[strings.TrimSpace(field)] = append(precedence[strings.TrimSpace(field)], platform)
+			}
+		}
+	}
+	return precedence
+}
 
-	apiFile, err := os.Open(baseDir + "/" + name + "/" + name + ".json")
-	if err == nil {
-		byteValue, _ := io.ReadAll(apiFile)
+func generalMergePrecedenceFromEnv() GeneralMergePrecedence {
+	return parseGeneralMergePrecedence(os.Getenv("APIMSYNC_MERGE_PRECEDENCE"))
+}
 
-		// only overwrite if new file is larger, meaning it has more information
-		if len(newBytes) > len(byteValue) {
-			os.WriteFile(baseDir+"/"+name+"/"+name+".json", newBytes, 0644)
+// order returns the indexes of the platform APIs in the order they should be used for a field.
+func (precedence GeneralMergePrecedence) order(field string, platformNames []string, platformApis []map[string]any) []int {
+	result := []int{}
+	for _, preferred := range append(precedence[field], precedence["*"]...) {
+		for i := range platformApis {
+			platformId, _ := platformApis[i]["platformId"].(string)
+			if (platformNames[i] == preferred || platformId == preferred) && !slices.Contains(result, i) {
+				result = append(result, i)
+			}
 		}
-	} else {
-		os.WriteFile(baseDir+"/"+name+"/"+name+".json", newBytes, 0644)
 	}
+	// platforms without a precedence follow in file name order
+	for i := range platformApis {
+		if !slices.Contains(result, i) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// generalPlatformName returns the platform of a general platform API file, e.g. azure for petstore-azure.json.
+func generalPlatformName(fileName string) string {
+	for _, name := range sourcePlatformNames() {
+		if strings.HasSuffix(fileName, "-"+name+".json") {
+			return name
+		}
+	}
+	return ""
+}
 
-	return nil
+// generalApiFields returns the JSON names of the GeneralApi fields that are merged.
+func generalApiFields() []string {
+	fields := []string{}
+	apiType := reflect.TypeOf(GeneralApi{})
+	for i := 0; i < apiType.NumField(); i++ {
+		field, _, _ := strings.Cut(apiType.Field(i).Tag.Get("json"), ",")
+		if field != "name" && field != "instance" && field != "provenance" && apiType.Field(i).Type.Kind() == reflect.String {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func generalMerge(flags *GeneralFlags) error {
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+	precedence := generalMergePrecedenceFromEnv()
+	if flags.Precedence != "" {
+		precedence = parseGeneralMergePrecedence(flags.Precedence)
+	}
+
+	entries, err := storage.ReadDir("general/apiproxies")
+	if err != nil {
+		return errors.New("no general APIs found, cannot merge")
+	}
+
+	var errs []error
+	for _, e := range entries {
+		if e.IsDir() && (flags.ApiName == "" || flags.ApiName == e.Name()) {
+			fmt.Println("Merging " + e.Name() + "...")
+			if err := mergeGeneralApi(storage, e.Name(), precedence); err != nil {
+				errs = append(errs, errors.New(e.Name()+": "+err.Error()))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// pruneLocalApis removes exported API files whose API was not found in the current export, keyed by "folder/apiName".
+func pruneLocalApis(storage Storage, baseDir string, current map[string]bool) []string {
+	removed := []string{}
+	entries, err := storage.ReadDir(baseDir)
+	if err != nil {
+		return removed
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		fileEntries, _ := storage.ReadDir(baseDir + "/" + e.Name())
+		for _, f := range fileEntries {
+			if strings.Contains(f.Name(), "-oas") || !strings.HasSuffix(f.Name(), ".json") {
+				continue
+			}
+			apiName := strings.TrimSuffix(f.Name(), ".json")
+			if !current[e.Name()+"/"+apiName] {
+				removeApiFiles(storage, baseDir+"/"+e.Name(), apiName)
+				removed = append(removed, apiName)
+			}
+		}
+		removeDirIfEmpty(storage, baseDir+"/"+e.Name())
+	}
+
+	return removed
+}
+
+// pruneGeneralApis removes the general platform APIs of a platform, e.g. azure, whose exported source API no longer exists.
+func pruneGeneralApis(storage Storage, platform string) []string {
+	baseDir := "general/apiproxies"
+	removed := []string{}
+	entries, err := storage.ReadDir(baseDir)
+	if err != nil {
+		return removed
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		fileEntries, _ := storage.ReadDir(baseDir + "/" + e.Name())
+		for _, f := range fileEntries {
+			if !strings.HasSuffix(f.Name(), "-"+platform+".json") {
+				continue
+			}
+			generalName := strings.TrimSuffix(f.Name(), ".json")
+			generalApi, _ := readGeneralPlatformApi(storage, baseDir+"/"+e.Name()+"/"+f.Name())
+			generalApi.Name = generalName
+			sourceFile := sourceInstanceDir(platform, generalApi.Instance) + "/" + e.Name() + "/" + generalApiVersionName(generalApi, platform) + ".json"
+			if _, err := storage.Stat(sourceFile); err != nil {
+				removeApiFiles(storage, baseDir+"/"+e.Name(), generalName)
+				removed = append(removed, generalName)
+			}
+		}
+
+		// remove the aggregate API if no platform APIs are left, otherwise merge what is left
+		if !hasGeneralPlatformApis(storage, baseDir+"/"+e.Name()) {
+			storage.RemoveAll(baseDir + "/" + e.Name())
+		} else {
+			mergeGeneralApi(storage, e.Name(), generalMergePrecedenceFromEnv())
+		}
+	}
+
+	return removed
+}
+
+func hasGeneralPlatformApis(storage Storage, dir string) bool {
+	fileEntries, _ := storage.ReadDir(dir)
+	for _, f := range fileEntries {
+		if generalPlatformName(f.Name()) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func removeApiFiles(storage Storage, dir string, apiName string) {
+	fileEntries, _ := storage.ReadDir(dir)
+	for _, f := range fileEntries {
+		if f.Name() == apiName+".json" || strings.HasPrefix(f.Name(), apiName+"-oas") {
+			storage.Remove(dir + "/" + f.Name())
+		}
+	}
+}
+
+func removeDirIfEmpty(storage Storage, dir string) {
+	fileEntries, err := storage.ReadDir(dir)
+	if err == nil && len(fileEntries) == 0 {
+		storage.Remove(dir)
+	}
 }
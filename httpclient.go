@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// httpClient is the client all platform calls go through, also the ones of the AWS SDK. It has a timeout, retries
+// 429 and 5xx responses with backoff, limits the request rate per host, and measures and traces every request.
+var httpClient = newHttpClient()
+
+func newHttpClient() *http.Client {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.ResponseHeaderTimeout = time.Duration(envInt("APIMSYNC_HTTP_TIMEOUT", 60)) * time.Second
+
+	// every attempt is a client span, which sends the traceparent header
+	traced := otelhttp.NewTransport(base, otelhttp.WithSpanNameFormatter(clientSpanName))
+
+	transport := &retryTransport{
+		base:       traced,
+		maxRetries: envInt("APIMSYNC_HTTP_RETRIES", 4),
+		baseDelay:  500 * time.Millisecond,
+		maxDelay:   30 * time.Second,
+		interval:   time.Second / time.Duration(max(envInt("APIMSYNC_HTTP_RATE", 10), 1)),
+		hosts:      map[string]time.Time{},
+		verbose:    os.Getenv("APIMSYNC_HTTP_LOG") == "true",
+	}
+
+	// the overall timeout includes all retries
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Minute,
+	}
+}
+
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+
+	// interval is the minimum time between two requests to the same host
+	interval time.Duration
+	hostsMu  sync.Mutex
+	hosts    map[string]time.Time
+
+	// verbose logs every request, which is only useful for debugging, the metrics and traces have the same data
+	verbose bool
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		latency := time.Since(start)
+		observeUpstream(req, resp, err, latency)
+
+		if t.verbose && err != nil {
+			log.Printf("%s %s error %s (%v)", req.Method, req.URL.Host, err.Error(), latency)
+		} else if t.verbose {
+			log.Printf("%s %s %d (%v)", req.Method, req.URL.Host, resp.StatusCode, latency)
+		}
+
+		if attempt >= t.maxRetries || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		// the body has to be sent again, which is only possible if it can be recreated
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		delay := t.backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// wait blocks until the next request to the host is allowed by the rate limit.
+func (t *retryTransport) wait(req *http.Request) error {
+	t.hostsMu.Lock()
+	now := time.Now()
+	next := t.hosts[req.URL.Host]
+	if next.Before(now) {
+		next = now
+	}
+	t.hosts[req.URL.Host] = next.Add(t.interval)
+	t.hostsMu.Unlock()
+
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-time.After(time.Until(next)):
+		return nil
+	}
+}
+
+// backoff returns how long to wait before the next attempt, using Retry-After if the server sent it,
+// or else exponential backoff with full jitter.
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil {
+				return min(time.Duration(seconds)*time.Second, t.maxDelay)
+			}
+			if date, err := http.ParseTime(retryAfter); err == nil {
+				return min(max(time.Until(date), 0), t.maxDelay)
+			}
+		}
+	}
+
+	delay := min(t.baseDelay<<attempt, t.maxDelay)
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// retryable returns true if the request can be sent again. Requests that aren't idempotent, like creating an Apigee
+// revision, may have been applied by the server when the connection failed or the gateway timed out, so they are only
+// retried when the server said it didn't process them: 429, or 503 with Retry-After.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if !idempotent(req.Method) {
+		return err == nil && (resp.StatusCode == http.StatusTooManyRequests ||
+			(resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != ""))
+	}
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func envInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
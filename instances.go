@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Source platforms can export from several instances in one run, e.g. the API Management services of
+// several Azure subscriptions, or all enabled AWS regions. The APIs of a single instance given by name
+// are stored like before, the APIs of several instances are namespaced by their instance, so that APIs
+// with the same name in different instances don't collide.
+
+// sourceInstances parses a comma separated list of instances, where "*" selects all instances. The
+// instances are namespaced unless a single instance is given.
+func sourceInstances(value string) (names []string, all bool, namespaced bool) {
+	for _, name := range splitList(value) {
+		if name == "*" {
+			all = true
+		} else if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, all, all || len(names) > 1
+}
+
+// sourceInstanceName makes the name of an instance safe to use in file and API Hub resource names.
+func sourceInstanceName(name string) string {
+	var re = regexp.MustCompile(`[^a-z0-9-]+`)
+	return strings.Trim(re.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// sourceInstanceDir returns the directory in the workspace the APIs of an instance are exported to, azure/apiproxies
+// for a single instance and azure/instances/contoso/apiproxies for the namespaced instance contoso.
+func sourceInstanceDir(platform string, instance string) string {
+	if instance == "" {
+		return platform + "/apiproxies"
+	}
+	return platform + "/instances/" + instance + "/apiproxies"
+}
+
+// exportedSourceInstances returns the namespaced instances of a platform that APIs were exported from.
+func exportedSourceInstances(storage Storage, platform string) []string {
+	instances := []string{}
+	entries, _ := storage.ReadDir(platform + "/instances")
+	for _, e := range entries {
+		if e.IsDir() {
+			instances = append(instances, e.Name())
+		}
+	}
+	return instances
+}
+
+// instanceApiName returns the name of an API in the stage results, which includes its instance if it has one.
+func instanceApiName(instance string, name string) string {
+	if instance == "" {
+		return name
+	}
+	return instance + "/" + name
+}
+
+// pruneSourceInstances removes the exported APIs of the instances that were not part of a run, which exported
+// the current instances. After a run with namespaced instances, the APIs of a single instance are removed too,
+// and the other way around.
+func pruneSourceInstances(storage Storage, platform string, current []string) []string {
+	removed := []string{}
+	for _, instance := range append(exportedSourceInstances(storage, platform), "") {
+		dir := sourceInstanceDir(platform, instance)
+		if slices.Contains(current, instance) {
+			continue
+		}
+		if _, err := storage.Stat(dir); err != nil {
+			continue
+		}
+		if instance != "" {
+			dir = strings.TrimSuffix(dir, "/apiproxies")
+		}
+		storage.RemoveAll(dir)
+		removed = append(removed, dir)
+	}
+	return removed
+}
+
+// generalPlatformApiName returns the name of the general platform API of a source API, e.g. petstore-v1-azure,
+// or petstore-v1-contoso-azure for the instance contoso.
+func generalPlatformApiName(name string, instance string, platform string) string {
+	if instance == "" {
+		return name + "-" + platform
+	}
+	return name + "-" + instance + "-" + platform
+}
+
+// generalApiVersionName returns the name of a general platform API without its instance and platform, e.g.
+// petstore-v1 for petstore-v1-contoso-azure. This is the API version the platform API is a deployment of,
+// and the name of the exported source API.
+func generalApiVersionName(api GeneralApi, platform string) string {
+	name := strings.TrimSuffix(api.Name, "-"+platform)
+	if api.Instance != "" {
+		name = strings.TrimSuffix(name, "-"+api.Instance)
+	}
+	return name
+}
+
+// readGeneralPlatformApi reads a general platform API file.
+func readGeneralPlatformApi(storage Storage, file string) (GeneralApi, error) {
+	var api GeneralApi
+	byteValue, err := storage.ReadFile(file)
+	if err != nil {
+		return api, err
+	}
+	return api, json.Unmarshal(byteValue, &api)
+}
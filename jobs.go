@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Job is a sync, offramp or onramp run by the web server in the background.
+type Job struct {
+	Id       string        `json:"id" example:"3f2a9c1b7d4e8f60" doc:"The job ID."`
+	Kind     string        `json:"kind" enum:"sync,offramp,onramp" doc:"What the job does."`
+	Offramp  string        `json:"offramp,omitempty" example:"azure" doc:"The platform the APIs are offramped from."`
+	Onramp   string        `json:"onramp,omitempty" example:"apihub" doc:"The platform the APIs are onramped to."`
+	Status   string        `json:"status" enum:"pending,running,succeeded,failed" doc:"The status of the job."`
+	Message  string        `json:"message,omitempty" example:"Sync from azure to apihub finished, 3 change(s) applied." doc:"The outcome of the job."`
+	Errors   []string      `json:"errors" doc:"The errors that occurred while running the job."`
+	Apis     []JobApi      `json:"apis" doc:"The progress of each API the job touched."`
+	Stages   []StageResult `json:"stages" doc:"The result of each stage the job ran."`
+	Created  time.Time     `json:"created" doc:"When the job was created."`
+	Started  *time.Time    `json:"started,omitempty" doc:"When the job started running."`
+	Finished *time.Time    `json:"finished,omitempty" doc:"When the job finished."`
+	TraceId  string        `json:"traceId,omitempty" example:"4bf92f3577b34da6a3ce929d0e0e4736" doc:"The trace ID of the job, if tracing is on."`
+
+	// events are all events of the job so far, so that late subscribers get them too
+	events []any
+	// workspace is the workspace the job runs in
+	workspace string
+}
+
+// JobApi is the progress of one API in a job.
+type JobApi struct {
+	Name   string `json:"name" example:"petstore" doc:"The API name."`
+	Stage  string `json:"stage" enum:"exported,offramped,onramped,imported" doc:"The last stage the API reached."`
+	Failed bool   `json:"failed" doc:"If the API failed in its last stage."`
+	Error  string `json:"error,omitempty" doc:"Why the API failed."`
+}
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// ApiEvent is sent over SSE when an API reaches a stage of a job, or fails in it.
+type ApiEvent struct {
+	Job   string    `json:"job" doc:"The job ID."`
+	Api   string    `json:"api" example:"petstore" doc:"The API name."`
+	Stage string    `json:"stage" enum:"exported,offramped,onramped,imported" doc:"The stage the API reached, or failed in."`
+	Error string    `json:"error,omitempty" doc:"Why the API failed."`
+	Time  time.Time `json:"time" doc:"When the event happened."`
+}
+
+// The SSE event types of API events, one per stage so that clients can listen for each of them.
+type (
+	ApiExportedEvent  ApiEvent
+	ApiOfframpedEvent ApiEvent
+	ApiOnrampedEvent  ApiEvent
+	ApiImportedEvent  ApiEvent
+	ApiFailedEvent    ApiEvent
+)
+
+// JobEvent is sent over SSE when a job starts and finishes.
+type JobEvent struct {
+	Job     string    `json:"job" doc:"The job ID."`
+	Status  string    `json:"status" enum:"pending,running,succeeded,failed" doc:"The status of the job."`
+	Message string    `json:"message,omitempty" doc:"The outcome of the job."`
+	Errors  []string  `json:"errors,omitempty" doc:"The errors of the job."`
+	Time    time.Time `json:"time" doc:"When the event happened."`
+}
+
+// jobEventTypes maps the SSE event names to their types.
+var jobEventTypes = map[string]any{
+	"job":       JobEvent{},
+	"exported":  ApiExportedEvent{},
+	"offramped": ApiOfframpedEvent{},
+	"onramped":  ApiOnrampedEvent{},
+	"imported":  ApiImportedEvent{},
+	"failed":    ApiFailedEvent{},
+}
+
+// maxJobs is how many finished jobs are kept in memory.
+const maxJobs = 100
+
+var jobs = map[string]*Job{}
+var jobsMutex sync.Mutex
+
+// jobsChanged is broadcast when a job gets a new event.
+var jobsChanged = sync.NewCond(&jobsMutex)
+
+// startJob creates a job that runs in workspace in the background. The run function reports progress on the job,
+// and its error makes the job fail. The job is traced as child of the span in ctx and canceled with it, so ctx is
+// the context of the run lock, not of the request that started the job.
+func startJob(ctx context.Context, kind string, offramp string, onramp string, workspace string, run func(ctx context.Context, job *Job) error) Job {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	job := &Job{Id: hex.EncodeToString(id), Kind: kind, Offramp: offramp, Onramp: onramp, Status: JobPending, Errors: []string{}, Apis: []JobApi{}, Stages: []StageResult{}, Created: time.Now().UTC(), workspace: workspace}
+	ctx, span := startSpan(ctx, "job "+kind, "apimsync.job.id", job.Id, "apimsync.job.kind", kind,
+		"apimsync.offramp", offramp, "apimsync.onramp", onramp)
+	job.TraceId = span.TraceId()
+
+	jobsMutex.Lock()
+	jobs[job.Id] = job
+	pruneJobs()
+	snapshot := job.snapshot()
+	jobsMutex.Unlock()
+
+	go func() {
+		job.update(func() {
+			now := time.Now().UTC()
+			job.Started = &now
+			job.Status = JobRunning
+			job.publish(JobEvent{Job: job.Id, Status: job.Status, Time: now})
+		})
+
+		err := run(ctx, job)
+		if lostErr := runLockLost(ctx); lostErr != nil {
+			err = errors.Join(err, lostErr)
+		}
+
+		job.update(func() {
+			now := time.Now().UTC()
+			job.Finished = &now
+			if err != nil {
+				job.Errors = append(job.Errors, err.Error())
+			}
+			if len(job.Errors) > 0 {
+				job.Status = JobFailed
+			} else {
+				job.Status = JobSucceeded
+			}
+			observeJob(job)
+			job.publish(JobEvent{Job: job.Id, Status: job.Status, Message: job.Message, Errors: job.Errors, Time: now})
+		})
+		if err == nil && len(job.Errors) > 0 {
+			err = errors.New(strconv.Itoa(len(job.Errors)) + " error(s)")
+		}
+		span.end(err)
+	}()
+
+	return snapshot
+}
+
+// pruneJobs removes the oldest finished jobs when there are more than maxJobs, jobsMutex must be held.
+func pruneJobs() {
+	if len(jobs) <= maxJobs {
+		return
+	}
+
+	finished := []*Job{}
+	for _, job := range jobs {
+		if job.Finished != nil {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Created.Before(finished[j].Created)
+	})
+	for _, job := range finished[:max(min(len(jobs)-maxJobs, len(finished)), 0)] {
+		delete(jobs, job.Id)
+	}
+}
+
+func (job *Job) update(change func()) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	change()
+}
+
+// snapshot returns a copy of the job that can be returned while the job keeps running, jobsMutex must be held.
+func (job *Job) snapshot() Job {
+	result := *job
+	result.Errors = append([]string{}, job.Errors...)
+	result.Apis = append([]JobApi{}, job.Apis...)
+	result.Stages = append([]StageResult{}, job.Stages...)
+	result.events = nil
+	return result
+}
+
+// publish adds an event to the job and wakes up its subscribers, jobsMutex must be held.
+func (job *Job) publish(event any) {
+	job.events = append(job.events, event)
+	jobsChanged.Broadcast()
+}
+
+// watchJob calls send with every event of the job, starting with the ones that already happened,
+// until the job finished, send fails or ctx is done. It returns false if the job doesn't exist.
+func watchJob(ctx context.Context, id string, send func(event any) error) bool {
+	jobsMutex.Lock()
+	job, ok := jobs[id]
+	jobsMutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	// wake up the wait below when the client goes away
+	stop := context.AfterFunc(ctx, func() {
+		jobsMutex.Lock()
+		defer jobsMutex.Unlock()
+		jobsChanged.Broadcast()
+	})
+	defer stop()
+
+	sent := 0
+	for {
+		jobsMutex.Lock()
+		for sent == len(job.events) && job.Finished == nil && ctx.Err() == nil {
+			jobsChanged.Wait()
+		}
+		events := job.events[sent:]
+		finished := job.Finished != nil
+		jobsMutex.Unlock()
+
+		if ctx.Err() != nil {
+			return true
+		}
+		for _, event := range events {
+			if send(event) != nil {
+				return true
+			}
+		}
+		sent += len(events)
+		if finished && len(events) == 0 {
+			return true
+		}
+	}
+}
+
+// setApi records the stage an API reached, and the error if it failed in that stage.
+func (job *Job) setApi(name string, stage string, err error) {
+	job.update(func() {
+		api := JobApi{Name: name, Stage: stage}
+		event := ApiEvent{Job: job.Id, Api: name, Stage: stage, Time: time.Now().UTC()}
+		if err != nil {
+			api.Failed = true
+			api.Error = err.Error()
+			job.Errors = append(job.Errors, name+": "+err.Error())
+			event.Error = err.Error()
+			job.publish(ApiFailedEvent(event))
+		} else {
+			switch stage {
+			case "exported":
+				job.publish(ApiExportedEvent(event))
+			case "offramped":
+				job.publish(ApiOfframpedEvent(event))
+			case "onramped":
+				job.publish(ApiOnrampedEvent(event))
+			case "imported":
+				job.publish(ApiImportedEvent(event))
+			}
+		}
+
+		for i := range job.Apis {
+			if job.Apis[i].Name == name {
+				job.Apis[i] = api
+				return
+			}
+		}
+		job.Apis = append(job.Apis, api)
+	})
+}
+
+// setStage records the result of a stage, and the stage each of its APIs reached or failed in.
+func (job *Job) setStage(result StageResult, stage string) {
+	job.update(func() {
+		job.Stages = append(job.Stages, result)
+	})
+	for _, api := range result.Apis {
+		job.setApi(api, stage, nil)
+	}
+	for _, failure := range result.Failed {
+		job.setApi(failure.Api, stage, errors.New(failure.Error))
+	}
+}
+
+func (job *Job) setMessage(message string) {
+	job.update(func() {
+		job.Message = message
+	})
+}
+
+func getJob(id string) (Job, bool) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	job, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.snapshot(), true
+}
+
+// listJobs returns the most recent jobs first.
+func listJobs(limit int) []Job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	result := []Job{}
+	for _, job := range jobs {
+		result = append(result, job.snapshot())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.After(result[j].Created)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// runOfframpJob exports and offramps the APIs of a source platform. APIs that failed are recorded on
+// the job, and the others continue to the next stage.
+func runOfframpJob(ctx context.Context, job *Job, source SourcePlatform) error {
+	exported, err := source.Export(ctx)
+	job.setStage(exported, "exported")
+	if err != nil && !onlyApisFailed(err) {
+		return err
+	}
+
+	offramped, err := source.Offramp(ctx)
+	job.setStage(offramped, "offramped")
+	if err != nil && !onlyApisFailed(err) {
+		return err
+	}
+
+	message := strconv.Itoa(len(offramped.Apis)) + " API(s) offramped from " + job.Offramp + "."
+	if failed := len(exported.Failed) + len(offramped.Failed); failed > 0 {
+		message = message + " " + strconv.Itoa(failed) + " API(s) failed."
+	}
+	// a temporary job workspace only has the APIs of the job, so only the web server workspace is snapshotted
+	if job.workspace == webServerWorkspace {
+		commit, err := snapshotWorkspace(job.workspace, job.Kind+" from "+job.Offramp)
+		if err != nil {
+			job.update(func() {
+				job.Errors = append(job.Errors, "could not snapshot the workspace: "+err.Error())
+			})
+		} else if commit != "" {
+			message = message + " Snapshot " + commit[:7] + " committed."
+		}
+	}
+	job.setMessage(message)
+	return nil
+}
+
+// runOnrampJob onramps and imports the general APIs to a target platform. Targets that support plans
+// are synced with a plan, so that each API's result is known.
+func runOnrampJob(ctx context.Context, job *Job, target TargetPlatform) error {
+	onramped, err := target.Onramp(ctx)
+	job.setStage(onramped, "onramped")
+	if err != nil && !onlyApisFailed(err) {
+		return err
+	}
+
+	planningTarget, ok := target.(PlanningTarget)
+	if !ok {
+		imported, err := target.Import(ctx)
+		job.setStage(imported, "imported")
+		if err != nil && !onlyApisFailed(err) {
+			return err
+		}
+		job.setMessage("Onramp to " + job.Onramp + " finished. " + imported.Summary())
+		return nil
+	}
+
+	ctx, span := startStage(ctx, "import", job.Onramp)
+	plan, err := planningTarget.Plan(ctx)
+	if err != nil {
+		span.end(err)
+		return errors.New("could not compute sync plan: " + err.Error())
+	}
+	apis := []string{}
+	for _, action := range plan.Actions {
+		if action.Api != "" && !slices.Contains(apis, action.Api) {
+			apis = append(apis, action.Api)
+		}
+	}
+
+	applyErr := applySyncPlan(ctx, job.workspace, planningTarget, plan)
+	actionErrs := syncActionErrors(applyErr)
+	if applyErr != nil && len(actionErrs) == 0 {
+		span.end(applyErr)
+		return applyErr
+	}
+
+	failed := map[string]error{}
+	for _, actionErr := range actionErrs {
+		if actionErr.Action.Api != "" && failed[actionErr.Action.Api] == nil {
+			failed[actionErr.Action.Api] = actionErr
+		} else if actionErr.Action.Api == "" {
+			job.update(func() {
+				job.Errors = append(job.Errors, actionErr.Error())
+			})
+		}
+	}
+
+	imported := newStageResult("import", job.Onramp)
+	for _, api := range apis {
+		if failed[api] != nil {
+			imported.Failed = append(imported.Failed, ApiFailure{Api: api, Error: failed[api].Error()})
+		} else {
+			imported.ok(api)
+		}
+	}
+	job.setStage(imported, "imported")
+	span.endStage(imported, applyErr)
+
+	message := "Onramp to " + job.Onramp + " finished. " + plan.Summary()
+	if failures := len(onramped.Failed) + len(failed); failures > 0 {
+		message = message + " " + strconv.Itoa(failures) + " API(s) failed."
+	}
+	job.setMessage(message)
+	return nil
+}
+
+// runSyncJob offramps the APIs from the source and onramps them to the target.
+func runSyncJob(ctx context.Context, job *Job, source SourcePlatform, target TargetPlatform) error {
+	if err := runOfframpJob(ctx, job, source); err != nil {
+		return err
+	}
+	return runOnrampJob(ctx, job, target)
+}
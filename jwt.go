@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JwtClaims are the claims of a verified JWT.
+type JwtClaims map[string]any
+
+func (c JwtClaims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns a claim that is either a list or a single string, split by spaces like the OAuth scope claim.
+func (c JwtClaims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []any:
+		values := []string{}
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return []string{}
+}
+
+// JwtValidator verifies JWTs signed with the keys of a JWKS URL, and checks their issuer and audience.
+type JwtValidator struct {
+	JwksUrl   string
+	Issuers   []string
+	Audiences []string
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+type Jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksMaxAge is how long keys are cached, and jwksMinAge how long to wait before fetching keys again for an unknown key ID.
+const (
+	jwksMaxAge = time.Hour
+	jwksMinAge = time.Minute
+)
+
+// jwtLeeway is the clock skew allowed when checking exp and nbf.
+const jwtLeeway = time.Minute
+
+// Validate verifies the signature and the exp, nbf, iss and aud claims of a token, and returns its claims.
+func (v *JwtValidator) Validate(token string) (JwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return nil, errors.New("invalid token header: " + err.Error())
+	}
+	var claims JwtClaims
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return nil, errors.New("invalid token claims: " + err.Error())
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid token signature: " + err.Error())
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJwtSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if len(v.Issuers) > 0 && !slices.Contains(v.Issuers, claims.String("iss")) {
+		return nil, errors.New("token issuer " + claims.String("iss") + " is not accepted")
+	}
+	if len(v.Audiences) > 0 && !slices.ContainsFunc(claims.Strings("aud"), func(aud string) bool { return slices.Contains(v.Audiences, aud) }) {
+		return nil, errors.New("token audience is not accepted")
+	}
+
+	return claims, nil
+}
+
+func decodeJwtPart(part string, result any) error {
+	bytes, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, result)
+}
+
+func verifyJwtSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("token key is not an RSA key")
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash[:], signature) != nil {
+			return errors.New("invalid token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("token key is not a P-256 key")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, hash[:], r, s) {
+			return errors.New("invalid token signature")
+		}
+	default:
+		return errors.New("token algorithm " + alg + " is not supported")
+	}
+	return nil
+}
+
+// key returns the public key with the key ID, fetching the JWKS when the cache is old or the key is unknown.
+func (v *JwtValidator) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, ok := v.keys[kid]
+	age := time.Since(v.fetched)
+	if ok && age < jwksMaxAge {
+		return key, nil
+	}
+	if ok || age >= jwksMinAge {
+		keys, err := fetchJwks(v.JwksUrl)
+		if err != nil {
+			return nil, errors.New("could not get token keys: " + err.Error())
+		}
+		v.keys = keys
+		v.fetched = time.Now()
+	}
+
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, errors.New("token key " + kid + " is unknown")
+	}
+	return key, nil
+}
+
+func fetchJwks(url string) (map[string]crypto.PublicKey, error) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+
+	var jwks Jwks
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (jwk Jwk) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, errors.New("curve " + jwk.Crv + " is not supported")
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, errors.New("key type " + jwk.Kty + " is not supported")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testJwtKeys are an RSA and a P-256 key, served as a JWKS.
+type testJwtKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	server  *httptest.Server
+	fetches atomic.Int32
+}
+
+func newTestJwtKeys(t *testing.T) *testJwtKeys {
+	t.Helper()
+	keys := &testJwtKeys{}
+	var err error
+	if keys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if keys.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks := Jwks{Keys: []Jwk{
+		{Kid: "rsa", Kty: "RSA", Use: "sig", N: encode(keys.rsa.N.Bytes()), E: encode(big.NewInt(int64(keys.rsa.E)).Bytes())},
+		{Kid: "ec", Kty: "EC", Crv: "P-256", X: encode(keys.ec.X.FillBytes(make([]byte, 32))), Y: encode(keys.ec.Y.FillBytes(make([]byte, 32)))},
+		{Kid: "enc", Kty: "RSA", Use: "enc", N: encode(keys.rsa.N.Bytes()), E: encode(big.NewInt(int64(keys.rsa.E)).Bytes())},
+	}}
+	keys.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys.fetches.Add(1)
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(keys.server.Close)
+	return keys
+}
+
+// sign returns a JWT with the claims, signed with the key of alg and the key ID kid.
+func (k *testJwtKeys) sign(t *testing.T, alg string, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, hash[:])
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		signature = []byte("signature")
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJwtValidate(t *testing.T) {
+	keys := newTestJwtKeys(t)
+	now := time.Now().Unix()
+	claims := func(changes map[string]any) map[string]any {
+		result := map[string]any{"iss": "https://issuer.example.com", "aud": "apimsync", "sub": "ci", "exp": now + 300}
+		for name, value := range changes {
+			if value == nil {
+				delete(result, name)
+			} else {
+				result[name] = value
+			}
+		}
+		return result
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"RS256", keys.sign(t, "RS256", "rsa", claims(nil)), ""},
+		{"ES256", keys.sign(t, "ES256", "ec", claims(nil)), ""},
+		{"audience list", keys.sign(t, "RS256", "rsa", claims(map[string]any{"aud": []string{"other", "apimsync"}})), ""},
+		{"expired within the leeway", keys.sign(t, "RS256", "rsa", claims(map[string]any{"exp": now - 30})), ""},
+		{"not before within the leeway", keys.sign(t, "RS256", "rsa", claims(map[string]any{"nbf": now + 30})), ""},
+		{"expired", keys.sign(t, "RS256", "rsa", claims(map[string]any{"exp": now - 300})), "token is expired"},
+		{"no expiry", keys.sign(t, "RS256", "rsa", claims(map[string]any{"exp": nil})), "token is expired"},
+		{"not valid yet", keys.sign(t, "RS256", "rsa", claims(map[string]any{"nbf": now + 300})), "token is not valid yet"},
+		{"other issuer", keys.sign(t, "RS256", "rsa", claims(map[string]any{"iss": "https://other.example.com"})), "token issuer https://other.example.com is not accepted"},
+		{"other audience", keys.sign(t, "RS256", "rsa", claims(map[string]any{"aud": "other"})), "token audience is not accepted"},
+		{"no audience", keys.sign(t, "RS256", "rsa", claims(map[string]any{"aud": nil})), "token audience is not accepted"},
+		{"unknown key", keys.sign(t, "RS256", "unknown", claims(nil)), "token key unknown is unknown"},
+		{"encryption key", keys.sign(t, "RS256", "enc", claims(nil)), "token key enc is unknown"},
+		{"key of another type", keys.sign(t, "RS256", "ec", claims(nil)), "token key is not an RSA key"},
+		{"unsupported algorithm", keys.sign(t, "HS256", "rsa", claims(nil)), "token algorithm HS256 is not supported"},
+		{"no algorithm", keys.sign(t, "none", "rsa", claims(nil)), "token algorithm none is not supported"},
+		{"not a JWT", "token", "token is not a JWT"},
+		{"no key ID", "e30.e30.e30", "token key  is unknown"},
+		{"invalid claims", "eyJhbGciOiJSUzI1NiJ9.invalid.e30", "invalid token claims"},
+	}
+
+	validator := &JwtValidator{JwksUrl: keys.server.URL, Issuers: []string{"https://issuer.example.com"}, Audiences: []string{"apimsync"}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := validator.Validate(test.token)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("expected a valid token, got %v", err)
+				}
+				if result.String("sub") != "ci" {
+					t.Errorf("expected the claims of the token, got %v", result)
+				}
+			} else if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("expected %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("tampered signature", func(t *testing.T) {
+		parts := strings.Split(keys.sign(t, "RS256", "rsa", claims(nil)), ".")
+		tampered, _ := json.Marshal(claims(map[string]any{"sub": "admin"}))
+		parts[1] = base64.RawURLEncoding.EncodeToString(tampered)
+		if _, err := validator.Validate(strings.Join(parts, ".")); err == nil || err.Error() != "invalid token signature" {
+			t.Errorf("expected an invalid signature, got %v", err)
+		}
+	})
+}
+
+func TestJwtKeyCache(t *testing.T) {
+	keys := newTestJwtKeys(t)
+	validator := &JwtValidator{JwksUrl: keys.server.URL}
+	token := keys.sign(t, "RS256", "rsa", map[string]any{"exp": time.Now().Unix() + 300})
+
+	for range 3 {
+		if _, err := validator.Validate(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// unknown key IDs don't fetch the keys again until jwksMinAge passed
+	validator.Validate(keys.sign(t, "RS256", "unknown", map[string]any{"exp": time.Now().Unix() + 300}))
+	if fetches := keys.fetches.Load(); fetches != 1 {
+		t.Errorf("expected the keys to be fetched once, got %d fetches", fetches)
+	}
+
+	validator.fetched = time.Now().Add(-jwksMaxAge)
+	if _, err := validator.Validate(token); err != nil {
+		t.Fatal(err)
+	}
+	if fetches := keys.fetches.Load(); fetches != 2 {
+		t.Errorf("expected the keys to be fetched again after jwksMaxAge, got %d fetches", fetches)
+	}
+}
+
+func TestJwtClaimsStrings(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected []string
+	}{
+		{"read write", []string{"read", "write"}},
+		{[]any{"read", 1, "write"}, []string{"read", "write"}},
+		{nil, []string{}},
+		{true, []string{}},
+	}
+	for _, test := range tests {
+		if values := (JwtClaims{"scope": test.value}).Strings("scope"); !slices.Equal(values, test.expected) {
+			t.Errorf("Strings(%v): expected %v, got %v", test.value, test.expected, values)
+		}
+	}
+}
+
+func TestNewWebServerAuth(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            map[string]string
+		noAuth         bool
+		authenticators int
+		err            bool
+	}{
+		{"no authentication", map[string]string{}, false, 0, true},
+		{"no authentication with noauth", map[string]string{}, true, 0, false},
+		{"no authentication with APIMSYNC_AUTH", map[string]string{"APIMSYNC_AUTH": "none"}, false, 0, false},
+		{"noauth with api keys", map[string]string{"APIMSYNC_API_KEYS": "key=apis.read"}, true, 0, true},
+		{"api keys", map[string]string{"APIMSYNC_API_KEYS": "key=apis.read"}, false, 1, false},
+		{"google", map[string]string{"APIMSYNC_GOOGLE_AUDIENCE": "https://apimsync.example.com"}, false, 1, false},
+		{"jwt", map[string]string{"APIMSYNC_JWT_JWKS_URL": "https://issuer.example.com/jwks", "APIMSYNC_JWT_ISSUER": "https://issuer.example.com", "APIMSYNC_JWT_AUDIENCE": "apimsync"}, false, 1, false},
+		{"jwt without issuer", map[string]string{"APIMSYNC_JWT_JWKS_URL": "https://issuer.example.com/jwks", "APIMSYNC_JWT_AUDIENCE": "apimsync"}, false, 0, true},
+		{"jwt without audience", map[string]string{"APIMSYNC_JWT_JWKS_URL": "https://issuer.example.com/jwks", "APIMSYNC_JWT_ISSUER": "https://issuer.example.com"}, false, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"APIMSYNC_API_KEYS", "APIMSYNC_GOOGLE_AUDIENCE", "APIMSYNC_JWT_JWKS_URL", "APIMSYNC_JWT_ISSUER", "APIMSYNC_JWT_AUDIENCE", "APIMSYNC_AUTH"} {
+				t.Setenv(name, test.env[name])
+			}
+			auth, err := newWebServerAuth(test.noAuth)
+			if test.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(auth.Authenticators) != test.authenticators {
+				t.Errorf("expected %d authenticators, got %d", test.authenticators, len(auth.Authenticators))
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RunLock makes sure that only one run at a time writes to a workspace, across the web server and CLI commands.
+// The file lock works for processes that share the storage of the workspace, other backends can be registered
+// with registerRunLock.
+type RunLock interface {
+	// Acquire takes the lock for holder, or returns a *RunLockedError if another run holds it.
+	// The lock is held until release is called. If the run loses the lock before, e.g. because it couldn't
+	// refresh it and another run took it over as stale, lost is called with a *RunLockLostError.
+	Acquire(holder RunLockHolder, lost func(err error)) (release func(), err error)
+}
+
+// RunLockHolder describes the run holding the lock.
+type RunLockHolder struct {
+	Id        string    `json:"id" doc:"The ID of the run."`
+	Run       string    `json:"run" example:"sync from azure to apihub" doc:"What the run does."`
+	Host      string    `json:"host" doc:"The host the run is on."`
+	Pid       int       `json:"pid" doc:"The process ID of the run."`
+	Acquired  time.Time `json:"acquired" doc:"When the run took the lock."`
+	Refreshed time.Time `json:"refreshed" doc:"When the run last confirmed it is still active."`
+}
+
+// RunLockedError is returned when another run holds the lock.
+type RunLockedError struct {
+	Holder RunLockHolder
+}
+
+func (e *RunLockedError) Error() string {
+	return "another run is active: " + e.Holder.Run + " on " + e.Holder.Host + " (pid " + strconv.Itoa(e.Holder.Pid) + ") since " + e.Holder.Acquired.Format(time.RFC3339)
+}
+
+// RunLockLostError is the cause of the cancelled context of a run that lost its lock while it was running.
+type RunLockLostError struct {
+	Lock string
+	Run  string
+}
+
+func (e *RunLockLostError) Error() string {
+	return "lost the run lock " + e.Lock + " of " + e.Run + ", another run may have taken it over"
+}
+
+var runLocks = map[string]func(workspace string) RunLock{}
+
+// registerRunLock adds a lock backend that can be selected with APIMSYNC_LOCK. The backend returns the lock
+// of a workspace.
+func registerRunLock(name string, backend func(workspace string) RunLock) {
+	runLocks[name] = backend
+}
+
+func init() {
+	registerRunLock("file", newFileRunLock)
+}
+
+func runLockNames() []string {
+	names := []string{}
+	for name := range runLocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// acquireRunLock takes the run lock of the workspace from the backend in APIMSYNC_LOCK, by default the file lock.
+// The returned context is cancelled with a *RunLockLostError as cause if the run loses the lock, so that it
+// stops changing the workspace and the platforms, and when the lock is released.
+func acquireRunLock(ctx context.Context, workspace string, run string) (context.Context, func(), error) {
+	name := os.Getenv("APIMSYNC_LOCK")
+	if name == "" {
+		name = "file"
+	}
+	backend, ok := runLocks[name]
+	if !ok {
+		return nil, nil, errors.New("unknown lock backend " + name + ", use one of " + fmt.Sprint(runLockNames()))
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+	ctx, cancel := context.WithCancelCause(ctx)
+	release, err := backend(workspace).Acquire(RunLockHolder{Id: hex.EncodeToString(id), Run: run, Host: host, Pid: os.Getpid(), Acquired: now, Refreshed: now}, cancel)
+	if err != nil {
+		cancel(nil)
+		return nil, nil, err
+	}
+	return ctx, func() {
+		release()
+		cancel(nil)
+	}, nil
+}
+
+// runLockLost returns the *RunLockLostError of a run whose context was cancelled because it lost its lock, or nil.
+func runLockLost(ctx context.Context) error {
+	var lostErr *RunLockLostError
+	if errors.As(context.Cause(ctx), &lostErr) {
+		return lostErr
+	}
+	return nil
+}
+
+// locked wraps a CLI command so that it holds the run lock while it runs, the lock of the workspace in the
+// Workspace field of its flags if they have one. The command fails if it lost the lock while it ran.
+func locked[T any](run string, command func(flags *T) error) func(flags *T) error {
+	return func(flags *T) error {
+		ctx, release, err := acquireRunLock(context.Background(), flagsWorkspace(flags), run)
+		if err != nil {
+			return err
+		}
+		defer release()
+		err = command(flags)
+		if lostErr := runLockLost(ctx); lostErr != nil {
+			return errors.Join(err, lostErr)
+		}
+		return err
+	}
+}
+
+// flagsWorkspace returns the Workspace field of command flags, or empty if they don't have one.
+func flagsWorkspace(flags any) string {
+	if field := reflect.ValueOf(flags).Elem().FieldByName("Workspace"); field.IsValid() && field.Kind() == reflect.String {
+		return field.String()
+	}
+	return ""
+}
+
+// fileRunLock is a lock file in the storage of the workspace that is created exclusively, and refreshed while
+// the run is active so that the lock of a run that crashed can be taken over once it is stale.
+type fileRunLock struct {
+	storage Storage
+	name    string
+	stale   time.Duration
+	err     error
+}
+
+// fileRunLockMutex serializes taking over stale locks within the process.
+var fileRunLockMutex sync.Mutex
+
+// newFileRunLock returns the lock file .apimsync.lock of the workspace, or the local file in APIMSYNC_LOCK_FILE.
+func newFileRunLock(workspace string) RunLock {
+	lock := &fileRunLock{name: ".apimsync.lock", stale: time.Duration(envInt("APIMSYNC_LOCK_STALE", 120)) * time.Second}
+	if file := os.Getenv("APIMSYNC_LOCK_FILE"); file != "" {
+		lock.storage, lock.name = &localStorage{root: filepath.Dir(file)}, filepath.Base(file)
+	} else {
+		lock.storage, lock.err = workspaceStorage(workspace)
+	}
+	return lock
+}
+
+// Acquire creates the lock file, or takes it over if it is stale. Only the version of the lock file that was read
+// as stale is removed, so that if several runs take over the same lock at once, only one of them gets it.
+func (l *fileRunLock) Acquire(holder RunLockHolder, lost func(err error)) (func(), error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	fileRunLockMutex.Lock()
+	defer fileRunLockMutex.Unlock()
+
+	for {
+		bytes, _ := json.Marshal(holder)
+		err := l.storage.CreateFile(l.name, bytes)
+		if err == nil {
+			return l.keepAlive(holder, lost), nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		current, version, err := l.holder()
+		if errors.Is(err, fs.ErrNotExist) {
+			// released in the meantime
+			continue
+		}
+		if err == nil && time.Since(current.Refreshed) < l.stale {
+			return nil, &RunLockedError{Holder: current}
+		}
+		if err != nil && version == "" {
+			return nil, errors.New("could not read the run lock " + l.String() + ": " + err.Error())
+		}
+		fmt.Println("Taking over the stale run lock " + l.String() + ".")
+		if err := l.storage.RemoveVersion(l.name, version); err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errFileChanged) {
+			return nil, err
+		}
+	}
+}
+
+func (l *fileRunLock) String() string {
+	return l.storage.String() + "/" + l.name
+}
+
+// holder returns the holder of the lock file with the version of the file, which is also returned if the
+// file can't be parsed.
+func (l *fileRunLock) holder() (RunLockHolder, string, error) {
+	var holder RunLockHolder
+	bytes, version, err := l.storage.ReadFileVersion(l.name)
+	if err != nil {
+		return holder, "", err
+	}
+	return holder, version, json.Unmarshal(bytes, &holder)
+}
+
+// keepAlive refreshes the lock file until the returned release function is called, which removes it. If the lock
+// file was removed or taken over by another run, it stops and calls lost.
+func (l *fileRunLock) keepAlive(holder RunLockHolder, lost func(err error)) func() {
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(l.stale / 4):
+			}
+			if current, _, err := l.holder(); err != nil || current.Id != holder.Id {
+				lostErr := &RunLockLostError{Lock: l.String(), Run: holder.Run}
+				fmt.Println("Error: " + lostErr.Error() + ".")
+				lost(lostErr)
+				return
+			}
+			holder.Refreshed = time.Now().UTC()
+			bytes, _ := json.Marshal(holder)
+			l.storage.WriteFile(l.name, bytes)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			if current, version, err := l.holder(); err == nil && current.Id == holder.Id {
+				l.storage.RemoveVersion(l.name, version)
+			}
+		})
+	}
+}
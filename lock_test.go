@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunLock(t *testing.T) {
+	tests := []struct {
+		name      string
+		workspace string
+	}{
+		{"directory", ""},
+		{"object store", "s3://apimsync-e2e/workspace"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newE2eSuite(t, test.workspace)
+			_, release, err := acquireRunLock(context.Background(), s.workspace, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lockedErr *RunLockedError
+			if _, _, err := acquireRunLock(context.Background(), s.workspace, "test conflict"); !errors.As(err, &lockedErr) || lockedErr.Holder.Run != "test" {
+				t.Errorf("expected the lock to be held by test, got %v", err)
+			}
+			err = locked("test command", func(flags *GeneralFlags) error { return nil })(&GeneralFlags{Workspace: s.workspace})
+			if !errors.As(err, &lockedErr) {
+				t.Errorf("expected the locked command to fail, got %v", err)
+			}
+
+			release()
+			_, release, err = acquireRunLock(context.Background(), s.workspace, "test after release")
+			if err != nil {
+				t.Fatalf("expected the lock to be free after release, got %v", err)
+			}
+			release()
+		})
+	}
+}
+
+// takeoverStorage lets another run take over the lock file right before the stale lock is removed.
+type takeoverStorage struct {
+	Storage
+	other RunLockHolder
+}
+
+func (s *takeoverStorage) RemoveVersion(name string, version string) error {
+	bytes, _ := json.Marshal(s.other)
+	s.Storage.WriteFile(name, bytes)
+	return s.Storage.RemoveVersion(name, version)
+}
+
+func TestRunLockStaleTakeover(t *testing.T) {
+	tests := []struct {
+		name      string
+		workspace string
+	}{
+		{"directory", ""},
+		{"memory", "mem://stale-takeover"},
+		{"object store", "s3://apimsync-e2e/stale-takeover"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newE2eSuite(t, test.workspace)
+			stale := RunLockHolder{Id: "stale", Run: "crashed run", Refreshed: time.Now().Add(-time.Hour)}
+			other := RunLockHolder{Id: "other", Run: "other run", Refreshed: time.Now()}
+			writeHolder := func(holder RunLockHolder) {
+				bytes, _ := json.Marshal(holder)
+				if err := s.storage.WriteFile(".apimsync.lock", bytes); err != nil {
+					t.Fatal(err)
+				}
+			}
+			readHolder := func() RunLockHolder {
+				var holder RunLockHolder
+				bytes, _ := s.storage.ReadFile(".apimsync.lock")
+				json.Unmarshal(bytes, &holder)
+				return holder
+			}
+
+			// another run takes over the stale lock first, so its lock is kept
+			writeHolder(stale)
+			lock := newFileRunLock(s.workspace).(*fileRunLock)
+			lock.storage = &takeoverStorage{Storage: lock.storage, other: other}
+			var lockedErr *RunLockedError
+			if _, err := lock.Acquire(RunLockHolder{Id: "new", Run: "new run"}, func(error) {}); !errors.As(err, &lockedErr) || lockedErr.Holder.Id != "other" {
+				t.Errorf("expected the lock to be held by the other run, got %v", err)
+			}
+			if holder := readHolder(); holder.Id != "other" {
+				t.Errorf("expected the lock of the other run to be kept, got %+v", holder)
+			}
+
+			writeHolder(stale)
+			_, release, err := acquireRunLock(context.Background(), s.workspace, "new run")
+			if err != nil {
+				t.Fatalf("expected the stale lock to be taken over, got %v", err)
+			}
+			if holder := readHolder(); holder.Run != "new run" {
+				t.Errorf("expected the lock to be held by the new run, got %+v", holder)
+			}
+			release()
+			if _, err := s.storage.Stat(".apimsync.lock"); err == nil {
+				t.Errorf("expected the lock to be removed on release")
+			}
+		})
+	}
+}
+
+// TestRunLockLost checks that the context of a run is canceled when another run takes over its lock.
+func TestRunLockLost(t *testing.T) {
+	s := newE2eSuite(t, "")
+	t.Setenv("APIMSYNC_LOCK_STALE", "1")
+	ctx, release, err := acquireRunLock(context.Background(), s.workspace, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	bytes, _ := json.Marshal(RunLockHolder{Id: "other", Run: "other run", Refreshed: time.Now()})
+	s.storage.WriteFile(".apimsync.lock", bytes)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the context to be canceled")
+	}
+	var lostErr *RunLockLostError
+	if err := runLockLost(ctx); !errors.As(err, &lostErr) || lostErr.Run != "test" {
+		t.Errorf("expected the run to have lost the lock, got %v", err)
+	}
+
+	// the other run keeps its lock
+	release()
+	if _, err := s.storage.Stat(".apimsync.lock"); err != nil {
+		t.Errorf("expected the lock of the other run to be kept, got %v", err)
+	}
+}
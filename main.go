@@ -1,7 +1,8 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"os"
 
 	"github.com/leaanthony/clir"
 )
@@ -19,15 +20,24 @@ type GeneralApi struct {
 	PlatformId          string `json:"platformId"`
 	PlatformName        string `json:"platformName"`
 	PlatformResourceUri string `json:"platformResourceUri"`
+
+	// Instance is the source instance a platform API was exported from, if several instances are exported.
+	Instance string `json:"instance,omitempty"`
+
+	// Provenance records for each merged field the platform ID it was taken from.
+	Provenance map[string]string `json:"provenance,omitempty"`
 }
 
 type PlatformStatus struct {
 	Connected bool   `json:"connected"`
 	Message   string `json:"message"`
+	Apis      int    `json:"apis"`
 }
 
 type GeneralFlags struct {
-	ApiName string `name:"api" description:"A specific Azure API Management API."`
+	ApiName    string `name:"api" description:"A specific Azure API Management API."`
+	Precedence string `name:"precedence" description:"The platforms each field is merged from first, e.g. \"*=azure,aws;gatewayUrl=aws\"."`
+	Workspace  string `name:"workspace" description:"The workspace the files are in, a directory or e.g. s3://bucket/prefix, default is APIMSYNC_WORKSPACE or src/main."`
 }
 
 func main() {
@@ -36,43 +46,49 @@ func main() {
 
 	generalCommand := cli.NewSubCommand("general", "Functions for general offramped APIs.")
 	generalApisCommand := generalCommand.NewSubCommand("apis", "Functions for General API resources.")
-	generalApisCommand.NewSubCommandFunction("cleanlocal", "Removes all APIs from offramped general definitions in local storage.", generalCleanLocal)
+	generalApisCommand.NewSubCommandFunction("merge", "Merges the platform APIs of each general API into the aggregate API.", locked("general apis merge", generalMerge))
+	generalApisCommand.NewSubCommandFunction("cleanlocal", "Removes all APIs from offramped general definitions in local storage.", locked("general apis cleanlocal", generalCleanLocal))
+	generalCommand.NewSubCommandFunction("search", "Searches the general APIs by their fields and the paths, operations and schemas of their specs.", generalSearch)
+
+	syncCommand := cli.NewSubCommand("sync", "Functions to sync general APIs to a target platform.")
+	syncCommand.NewSubCommandFunction("plan", "Shows the changes a sync would make to the target platform.", syncPlan)
+	syncCommand.NewSubCommandFunction("apply", "Applies a sync plan to the target platform.", locked("sync apply", syncApply))
+	syncCommand.NewSubCommandFunction("status", "Shows when general APIs were last synced and if they changed since.", syncStatus)
+
+	historyCommand := cli.NewSubCommand("history", "Shows how a general API and its specs changed over the snapshots of the workspace.")
+	historyFlags := &HistoryFlags{}
+	historyCommand.AddFlags(historyFlags)
+	historyCommand.Action(func() error { return history(historyFlags, historyCommand.OtherArgs()) })
 
 	webServerCommand := cli.NewSubCommand("ws", "Functions for the web server.")
 	webServerCommand.NewSubCommandFunction("start", "Start a web server to listen for commands.", webServerStart)
 
-	apigeeCommand := cli.NewSubCommand("apigee", "Functions for Apigee.")
-	apigeeApisCommand := apigeeCommand.NewSubCommand("apis", "Functions for Apigee API resources.")
-	apigeeApisCommand.NewSubCommandFunction("export", "Exports Apigee APIs from a given project.", apigeeExport)
-	apigeeApisCommand.NewSubCommandFunction("import", "Imports APIs to an Apigee project.", apigeeImport)
-	apigeeApisCommand.NewSubCommandFunction("clean", "Removes all of the Apigee APIs from a given project.", apigeeClean)
-	apigeeTestCommand := apigeeCommand.NewSubCommand("test", "Local test commands.")
-	apigeeTestCommand.NewSubCommandFunction("init", "Initializes local test data for an environment.", initApigeeTest)
-
-	apiHubCommand := cli.NewSubCommand("apihub", "Functions for Apigee API Hub.")
-	apiHubApisCommand := apiHubCommand.NewSubCommand("apis", "Functions for API Hub API resources.")
-	apiHubApisCommand.NewSubCommandFunction("onramp", "Onramps APIs from general to API Hub.", apiHubOnramp)
-	apiHubApisCommand.NewSubCommandFunction("import", "Imports APIs to API Hub.", apiHubImport)
-	apiHubApisCommand.NewSubCommandFunction("clean", "Removes all APIs from API Hub.", apiHubClean)
-	apiHubApisCommand.NewSubCommandFunction("cleanlocal", "Removes all API Hub APIs from local storage.", apiHubCleanLocal)
-
-	azureCommand := cli.NewSubCommand("azure", "Functions for Azure API Management.")
-	azureCommand.NewSubCommandFunction("export", "Exports Azure API Management service info.", azureServiceExport)
-	azureApisCommand := azureCommand.NewSubCommand("apis", "Functions for Azure API Management API resources.")
-	azureApisCommand.NewSubCommandFunction("export", "Exports Azure API Management APIs.", azureExportMin)
-	azureApisCommand.NewSubCommandFunction("offramp", "Migrates Azure API Management APIs out to general.", azureOfframp)
-	azureApisCommand.NewSubCommandFunction("cleanlocal", "Removes all exported Azure APIs from local storage.", azureCleanLocal)
-
-	awsCommand := cli.NewSubCommand("aws", "Functions for AWS API Gateway.")
-	awsApisCommand := awsCommand.NewSubCommand("apis", "Functions for AWS API Gateway API resources.")
-	awsApisCommand.NewSubCommandFunction("export", "Exports AWS API Gateway APIs.", awsExportMin)
-	awsApisCommand.NewSubCommandFunction("offramp", "Offramp AWS API Gateway APIs.", awsOfframp)
-	awsApisCommand.NewSubCommandFunction("cleanlocal", "Removes all exported AWS APIs from local storage.", awsCleanLocal)
+	configCommand := cli.NewSubCommand("config", "Functions for the apimsync.yaml config file.")
+	configCommand.NewSubCommand("validate", "Checks the profiles of the config file and shows the env variables they set.").Action(configValidate)
+
+	registerPlatformCommands(cli)
 
+	// --profile and --config apply to all commands, also the web server
+	args, selectedProfile, file := profileArgs(os.Args)
+	os.Args = args
+	if file != "" {
+		os.Setenv("APIMSYNC_CONFIG", file)
+	}
+	if err := useProfile(selectedProfile); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+
+	if err := initTracing(); err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
 	err := cli.Run()
+	shutdownTracing()
 
 	if err != nil {
-		// We had an error
-		log.Fatal(err)
+		// We had an error, exit with 2 if only some APIs failed
+		fmt.Println("Error: " + err.Error())
+		os.Exit(exitCode(err))
 	}
 }
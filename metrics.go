@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// The metrics of the web server, exposed at /metrics in the Prometheus text format.
+var (
+	apisTotal = newMetric("counter", "apimsync_apis_total",
+		"APIs processed successfully, by platform and stage (export, offramp, onramp or import).", "platform", "stage")
+	apiFailuresTotal = newMetric("counter", "apimsync_api_failures_total",
+		"APIs that failed, by platform and stage.", "platform", "stage")
+	upstreamErrorsTotal = newMetric("counter", "apimsync_upstream_errors_total",
+		"Failed calls to the platforms, by platform and HTTP status, or \"error\" if there was no response.", "platform", "status")
+	upstreamDuration = newHistogram("apimsync_upstream_request_duration_seconds",
+		"Latency of the calls to the platforms, by platform.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "platform")
+	jobsTotal = newMetric("counter", "apimsync_jobs_total",
+		"Finished jobs, by kind (offramp, onramp or sync) and status.", "kind", "status")
+	jobDuration = newHistogram("apimsync_sync_duration_seconds",
+		"Duration of the finished jobs, by kind and status.", []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}, "kind", "status")
+	platformApis = newMetric("gauge", "apimsync_platform_apis",
+		"APIs found on each platform, as listed by /v1/apim/status.", "platform")
+	platformConnected = newMetric("gauge", "apimsync_platform_connected",
+		"1 if apimsync could connect to the platform, otherwise 0.", "platform")
+)
+
+var metricsMutex sync.Mutex
+var metrics = []*Metric{}
+
+// Metric is a metric family with its series, one for each combination of label values.
+type Metric struct {
+	kind    string
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	// counts of the histogram buckets, the last one is +Inf
+	counts []uint64
+	count  uint64
+}
+
+func newMetric(kind string, name string, help string, labels ...string) *Metric {
+	metric := &Metric{kind: kind, name: name, help: help, labels: labels, series: map[string]*metricSeries{}}
+	metricsMutex.Lock()
+	metrics = append(metrics, metric)
+	metricsMutex.Unlock()
+	return metric
+}
+
+func newHistogram(name string, help string, buckets []float64, labels ...string) *Metric {
+	metric := newMetric("histogram", name, help, labels...)
+	metric.buckets = buckets
+	return metric
+}
+
+// get returns the series of the label values, metricsMutex must be held.
+func (m *Metric) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	series, ok := m.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues, counts: make([]uint64, len(m.buckets)+1)}
+		m.series[key] = series
+	}
+	return series
+}
+
+// add increases a counter.
+func (m *Metric) add(value float64, labelValues ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	m.get(labelValues).value += value
+}
+
+// set sets a gauge.
+func (m *Metric) set(value float64, labelValues ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	m.get(labelValues).value = value
+}
+
+// observe adds a value to a histogram.
+func (m *Metric) observe(value float64, labelValues ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	series := m.get(labelValues)
+	series.value += value
+	series.count++
+	for i, bucket := range m.buckets {
+		if value <= bucket {
+			series.counts[i]++
+		}
+	}
+	series.counts[len(m.buckets)]++
+}
+
+// writeMetrics writes all metrics in the Prometheus text format.
+func writeMetrics(w io.Writer) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	for _, metric := range metrics {
+		io.WriteString(w, "# HELP "+metric.name+" "+metric.help+"\n")
+		io.WriteString(w, "# TYPE "+metric.name+" "+metric.kind+"\n")
+
+		keys := []string{}
+		for key := range metric.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := metric.series[key]
+			if metric.kind != "histogram" {
+				io.WriteString(w, metric.name+metricLabels(metric.labels, series.labelValues, "")+" "+formatMetricValue(series.value)+"\n")
+				continue
+			}
+			for i, bucket := range metric.buckets {
+				io.WriteString(w, metric.name+"_bucket"+metricLabels(metric.labels, series.labelValues, formatMetricValue(bucket))+" "+strconv.FormatUint(series.counts[i], 10)+"\n")
+			}
+			io.WriteString(w, metric.name+"_bucket"+metricLabels(metric.labels, series.labelValues, "+Inf")+" "+strconv.FormatUint(series.counts[len(metric.buckets)], 10)+"\n")
+			io.WriteString(w, metric.name+"_sum"+metricLabels(metric.labels, series.labelValues, "")+" "+formatMetricValue(series.value)+"\n")
+			io.WriteString(w, metric.name+"_count"+metricLabels(metric.labels, series.labelValues, "")+" "+strconv.FormatUint(series.count, 10)+"\n")
+		}
+	}
+}
+
+// metricLabels formats the labels of a series, with the le label of a histogram bucket if given.
+func metricLabels(names []string, values []string, le string) string {
+	labels := []string{}
+	for i, name := range names {
+		labels = append(labels, name+"=\""+escapeMetricLabel(values[i])+"\"")
+	}
+	if le != "" {
+		labels = append(labels, "le=\""+le+"\"")
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// apimMetrics serves the metrics in the Prometheus text format.
+func apimMetrics(ctx context.Context, input *struct{}) (*huma.StreamResponse, error) {
+	return &huma.StreamResponse{Body: func(ctx huma.Context) {
+		ctx.SetHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(ctx.BodyWriter())
+	}}, nil
+}
+
+// observeUpstream records the latency of a platform call, and counts it as an error if it failed.
+func observeUpstream(req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	platform := upstreamPlatform(req.URL.Host)
+	upstreamDuration.observe(latency.Seconds(), platform)
+	if err != nil {
+		upstreamErrorsTotal.add(1, platform, "error")
+	} else if resp.StatusCode >= 400 {
+		upstreamErrorsTotal.add(1, platform, strconv.Itoa(resp.StatusCode))
+	}
+}
+
+// upstreamPlatform returns the platform a host belongs to, using the configured endpoints.
+func upstreamPlatform(host string) string {
+	endpoints := []struct {
+		platform string
+		url      string
+	}{
+		{"apigee", apigeeUrl()},
+		{"apihub", apiHubUrl()},
+		{"azure", azureManagementUrl()},
+		{"azure", azureLoginUrl()},
+		{"aws", awsUrl()},
+		{"aws", awsEc2Url()},
+		{"google", googleCertsUrl()},
+		{"storage", objectStorageUrl()},
+	}
+	for _, endpoint := range endpoints {
+		if parsed, err := url.Parse(endpoint.url); err == nil && parsed.Host == host {
+			return endpoint.platform
+		}
+	}
+	if strings.HasSuffix(host, ".amazonaws.com") {
+		return "aws"
+	}
+	return "other"
+}
+
+// observeJob records the outcome and duration of a finished job.
+func observeJob(job *Job) {
+	if job.Started == nil || job.Finished == nil {
+		return
+	}
+	jobsTotal.add(1, job.Kind, job.Status)
+	jobDuration.observe(job.Finished.Sub(*job.Started).Seconds(), job.Kind, job.Status)
+}
+
+// observePlatformStatus sets the gauges of a platform status.
+func observePlatformStatus(name string, status PlatformStatus) {
+	connected := 0.0
+	if status.Connected {
+		connected = 1
+	}
+	platformConnected.set(connected, name)
+	// keep the last count if the platform can't be reached
+	if status.Connected {
+		platformApis.set(float64(status.Apis), name)
+	}
+}
+
+// startStatusMetrics refreshes the platform status gauges in the background, until ctx is done.
+func startStatusMetrics(ctx context.Context, interval time.Duration) {
+	go func() {
+		for {
+			for _, name := range platformNames() {
+				observePlatformStatus(name, platforms[name].New(PlatformOptions{}).Status())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
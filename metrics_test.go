@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withMetrics makes writeMetrics write only the given metrics until the test is done.
+func withMetrics(t *testing.T, list ...*Metric) {
+	metricsMutex.Lock()
+	previous := metrics
+	metrics = list
+	metricsMutex.Unlock()
+	t.Cleanup(func() {
+		metricsMutex.Lock()
+		metrics = previous
+		metricsMutex.Unlock()
+	})
+}
+
+func TestWriteMetrics(t *testing.T) {
+	counter := &Metric{kind: "counter", name: "test_total", help: "A counter.", labels: []string{"platform", "stage"}, series: map[string]*metricSeries{}}
+	gauge := &Metric{kind: "gauge", name: "test_gauge", help: "A gauge.", series: map[string]*metricSeries{}}
+	histogram := &Metric{kind: "histogram", name: "test_seconds", help: "A histogram.", labels: []string{"kind"}, buckets: []float64{0.5, 1}, series: map[string]*metricSeries{}}
+	withMetrics(t, counter, gauge, histogram)
+
+	counter.add(1, "azure", "export")
+	counter.add(2, "azure", "export")
+	counter.add(1, "a\"b\\c\nd", "import")
+	gauge.set(5)
+	gauge.set(math.Inf(1))
+	histogram.observe(0.25, "sync")
+	histogram.observe(0.75, "sync")
+	histogram.observe(3, "sync")
+
+	expected := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{platform="a\"b\\c\nd",stage="import"} 1
+test_total{platform="azure",stage="export"} 3
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge +Inf
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{kind="sync",le="0.5"} 1
+test_seconds_bucket{kind="sync",le="1"} 2
+test_seconds_bucket{kind="sync",le="+Inf"} 3
+test_seconds_sum{kind="sync"} 4
+test_seconds_count{kind="sync"} 3
+`
+	recorder := httptest.NewRecorder()
+	newWebServerRouter(&WebServerAuth{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Body.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("expected the Prometheus text format, got %s", contentType)
+	}
+}
+
+func TestUpstreamPlatform(t *testing.T) {
+	t.Setenv("APIMSYNC_APIHUB_URL", "http://127.0.0.1:8081/")
+	t.Setenv("APIMSYNC_AWS_URL", "http://127.0.0.1:8082")
+	t.Setenv("APIMSYNC_APIGEE_URL", "")
+
+	tests := []struct {
+		host     string
+		platform string
+	}{
+		{"127.0.0.1:8081", "apihub"},
+		{"127.0.0.1:8082", "aws"},
+		{"apigee.googleapis.com", "apigee"},
+		{"management.azure.com", "azure"},
+		{"login.microsoftonline.com", "azure"},
+		{"ec2.eu-west-1.amazonaws.com", "aws"},
+		{"www.googleapis.com", "google"},
+		{"apihub.googleapis.com", "other"},
+		{"example.com", "other"},
+	}
+	for _, test := range tests {
+		if platform := upstreamPlatform(test.host); platform != test.platform {
+			t.Errorf("upstreamPlatform(%s): expected %s, got %s", test.host, test.platform, platform)
+		}
+	}
+}
+
+func TestObserveUpstream(t *testing.T) {
+	t.Setenv("APIMSYNC_APIGEE_URL", "")
+	count := func(status string) float64 {
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		if series, ok := upstreamErrorsTotal.series["apigee\xff"+status]; ok {
+			return series.value
+		}
+		return 0
+	}
+	before := map[string]float64{"error": count("error"), "503": count("503"), "200": count("200")}
+
+	req := httptest.NewRequest(http.MethodGet, "https://apigee.googleapis.com/v1/organizations", nil)
+	observeUpstream(req, &http.Response{StatusCode: 200}, nil, time.Second)
+	observeUpstream(req, &http.Response{StatusCode: 503}, nil, time.Second)
+	observeUpstream(req, nil, errors.New("connection refused"), time.Second)
+
+	for status, expected := range map[string]float64{"error": 1, "503": 1, "200": 0} {
+		if got := count(status) - before[status]; got != expected {
+			t.Errorf("expected %v upstream errors with status %s, got %v", expected, status, got)
+		}
+	}
+}
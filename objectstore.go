@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// objectStorage keeps the files of a workspace as objects in a bucket, with the S3 API that AWS S3, Google
+// Cloud Storage (with HMAC keys) and MinIO all support. Workspaces are given as s3://bucket/prefix or
+// gs://bucket/prefix, so that the exports and the sync state survive the container, e.g. on Cloud Run.
+type objectStorage struct {
+	scheme string
+	bucket string
+	prefix string
+	client *s3.Client
+}
+
+func init() {
+	registerStorage("s3", func(location string) (Storage, error) {
+		return newObjectStorage("s3", location)
+	})
+	registerStorage("gs", func(location string) (Storage, error) {
+		return newObjectStorage("gs", location)
+	})
+}
+
+// objectStorageUrl returns the custom endpoint of the object store, or an empty string to use the
+// endpoint of AWS S3 or Google Cloud Storage.
+func objectStorageUrl() string {
+	return endpointUrl("APIMSYNC_STORAGE_URL", "")
+}
+
+// objectStorageClient retries and measures the object requests like httpClient, but doesn't limit their rate,
+// since a run reads and writes every file of the workspace and object stores take many requests per second.
+var objectStorageClient = newObjectStorageClient()
+
+func newObjectStorageClient() *http.Client {
+	client := newHttpClient()
+	client.Transport.(*retryTransport).interval = 0
+	return client
+}
+
+func newObjectStorage(scheme string, location string) (Storage, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, errors.New("no bucket given in workspace " + scheme + "://" + location)
+	}
+	s := &objectStorage{scheme: scheme, bucket: bucket}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		s.prefix = prefix + "/"
+	}
+
+	region, endpoint := os.Getenv("APIMSYNC_STORAGE_REGION"), objectStorageUrl()
+	if region == "" && scheme == "gs" {
+		region = "auto"
+	} else if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" && scheme == "gs" {
+		endpoint = "https://storage.googleapis.com"
+	}
+
+	// the requests are retried by objectStorageClient, and checksums are only sent where S3 requires them, since
+	// Cloud Storage and older S3 compatible stores reject the newer checksum headers
+	options := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithRetryer(func() aws.Retryer { return aws.NopRetryer{} }),
+		config.WithRequestChecksumCalculation(aws.RequestChecksumCalculationWhenRequired),
+		config.WithResponseChecksumValidation(aws.ResponseChecksumValidationWhenRequired),
+	}
+	// HMAC keys of the object store, or else the default AWS credentials
+	if accessKey := os.Getenv("APIMSYNC_STORAGE_ACCESS_KEY"); accessKey != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, os.Getenv("APIMSYNC_STORAGE_SECRET_KEY"), "")))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, errors.New("could not load the object store credentials: " + err.Error())
+	}
+	// custom endpoints like MinIO and the interoperability endpoint of Cloud Storage take the bucket in the path
+	s.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.HTTPClient = objectStorageClient
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return s, nil
+}
+
+// objectError returns the error of a failed object request, which wraps fs.ErrNotExist if the object doesn't
+// exist, or failedCondition if the condition of the request failed.
+func objectError(op string, name string, err error, failedCondition error) error {
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		switch responseErr.HTTPStatusCode() {
+		case http.StatusNotFound:
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		case http.StatusPreconditionFailed:
+			return &fs.PathError{Op: op, Path: name, Err: failedCondition}
+		}
+	}
+	return errors.New(op + " " + name + ": " + err.Error())
+}
+
+// withObjectHeader sets a header of a request, for the conditions of Cloud Storage that the S3 API doesn't have.
+func withObjectHeader(name string, value string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue(name, value))
+	}
+}
+
+func (s *objectStorage) key(name string) *string {
+	return aws.String(s.prefix + storageName(name))
+}
+
+func (s *objectStorage) ReadFile(name string) ([]byte, error) {
+	data, _, err := s.ReadFileVersion(name)
+	return data, err
+}
+
+// ReadFileVersion returns the object with its ETag, or its generation on Google Cloud Storage.
+func (s *objectStorage) ReadFileVersion(name string) ([]byte, string, error) {
+	output, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: &s.bucket, Key: s.key(name)})
+	if err != nil {
+		return nil, "", objectError("read", name, err, fs.ErrExist)
+	}
+	defer output.Body.Close()
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.scheme == "gs" {
+		if resp, ok := awsmiddleware.GetRawResponse(output.ResultMetadata).(*smithyhttp.Response); ok {
+			return body, resp.Header.Get("x-goog-generation"), nil
+		}
+	}
+	return body, aws.ToString(output.ETag), nil
+}
+
+func (s *objectStorage) WriteFile(name string, data []byte) error {
+	_, err := s.client.PutObject(context.Background(), s.putInput(name, data))
+	if err != nil {
+		return objectError("write", name, err, fs.ErrExist)
+	}
+	return nil
+}
+
+// CreateFile puts the object only if it doesn't exist, which the object store checks atomically.
+func (s *objectStorage) CreateFile(name string, data []byte) error {
+	input := s.putInput(name, data)
+	input.IfNoneMatch = aws.String("*")
+	var options []func(*s3.Options)
+	if s.scheme == "gs" {
+		options = append(options, withObjectHeader("x-goog-if-generation-match", "0"))
+	}
+	if _, err := s.client.PutObject(context.Background(), input, options...); err != nil {
+		return objectError("create", name, err, fs.ErrExist)
+	}
+	return nil
+}
+
+func (s *objectStorage) putInput(name string, data []byte) *s3.PutObjectInput {
+	contentType := "application/octet-stream"
+	if strings.HasSuffix(name, ".json") {
+		contentType = "application/json"
+	}
+	return &s3.PutObjectInput{Bucket: &s.bucket, Key: s.key(name), Body: bytes.NewReader(data), ContentType: &contentType}
+}
+
+// list lists the objects with the prefix page by page, grouped into common prefixes by the delimiter if one is given.
+func (s *objectStorage) list(prefix string, delimiter string, page func(*s3.ListObjectsV2Output)) error {
+	input := &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &prefix}
+	if delimiter != "" {
+		input.Delimiter = &delimiter
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return objectError("list", prefix, err, fs.ErrExist)
+		}
+		page(output)
+	}
+	return nil
+}
+
+// dirPrefix returns the key prefix of the objects in a directory.
+func (s *objectStorage) dirPrefix(name string) string {
+	if name = storageName(name); name != "" {
+		return s.prefix + name + "/"
+	}
+	return s.prefix
+}
+
+func (s *objectStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := s.dirPrefix(name)
+	entries := []fs.DirEntry{}
+	err := s.list(prefix, "/", func(page *s3.ListObjectsV2Output) {
+		for _, dir := range page.CommonPrefixes {
+			entries = append(entries, storageEntry{name: strings.TrimSuffix(strings.TrimPrefix(aws.ToString(dir.Prefix), prefix), "/"), dir: true})
+		}
+		for _, object := range page.Contents {
+			// skip the empty objects some tools create for directories
+			if key := aws.ToString(object.Key); key != prefix {
+				entries = append(entries, storageEntry{name: strings.TrimPrefix(key, prefix), size: aws.ToInt64(object.Size), modTime: aws.ToTime(object.LastModified)})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (s *objectStorage) Stat(name string) (fs.FileInfo, error) {
+	if storageName(name) != "" {
+		output, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: &s.bucket, Key: s.key(name)})
+		if err == nil {
+			return storageEntry{name: path.Base(storageName(name)), size: aws.ToInt64(output.ContentLength), modTime: aws.ToTime(output.LastModified)}, nil
+		}
+		if err = objectError("stat", name, err, fs.ErrExist); !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	// a directory exists if there are objects in it
+	output, err := s.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: aws.String(s.dirPrefix(name)), MaxKeys: aws.Int32(1)})
+	if err != nil {
+		return nil, objectError("stat", name, err, fs.ErrExist)
+	}
+	if len(output.Contents) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return storageEntry{name: path.Base(storageName(name)), dir: true}, nil
+}
+
+func (s *objectStorage) Remove(name string) error {
+	if _, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: &s.bucket, Key: s.key(name)}); err != nil {
+		return objectError("remove", name, err, fs.ErrExist)
+	}
+	return nil
+}
+
+// RemoveVersion deletes the object only if it still has the ETag or generation, which the object store checks atomically.
+func (s *objectStorage) RemoveVersion(name string, version string) error {
+	input := &s3.DeleteObjectInput{Bucket: &s.bucket, Key: s.key(name)}
+	var options []func(*s3.Options)
+	if s.scheme == "gs" {
+		options = append(options, withObjectHeader("x-goog-if-generation-match", version))
+	} else {
+		input.IfMatch = &version
+	}
+	if _, err := s.client.DeleteObject(context.Background(), input, options...); err != nil {
+		return objectError("remove", name, err, errFileChanged)
+	}
+	return nil
+}
+
+func (s *objectStorage) RemoveAll(name string) error {
+	keys := []string{}
+	err := s.list(s.dirPrefix(name), "", func(page *s3.ListObjectsV2Output) {
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(object.Key), s.prefix))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if storageName(name) != "" {
+		keys = append(keys, storageName(name))
+	}
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, s.Remove(key))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *objectStorage) String() string {
+	return strings.TrimSuffix(s.scheme+"://"+s.bucket+"/"+s.prefix, "/")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/leaanthony/clir"
+)
+
+// Platform is anything apimsync can connect to and report a status for.
+type Platform interface {
+	Status() PlatformStatus
+}
+
+// SourcePlatform is a platform that APIs can be exported from and offramped to general.
+// The stages return the result for each API, and an error if the stage or any of its APIs failed.
+// The context carries the span the stages are traced under.
+type SourcePlatform interface {
+	Platform
+	Export(ctx context.Context) (StageResult, error)
+	Offramp(ctx context.Context) (StageResult, error)
+}
+
+// TargetPlatform is a platform that general APIs can be onramped and imported to.
+type TargetPlatform interface {
+	Platform
+	Onramp(ctx context.Context) (StageResult, error)
+	Import(ctx context.Context) (StageResult, error)
+	Clean() error
+}
+
+// PlanningTarget is a target platform that can compute a plan of changes against its current state and apply it.
+type PlanningTarget interface {
+	TargetPlatform
+	Plan(ctx context.Context) (SyncPlan, error)
+	Apply(ctx context.Context, plan SyncPlan) error
+}
+
+// PlatformOptions are the options passed to a platform when it is created for a run.
+type PlatformOptions struct {
+	ApiName string
+	OnlyNew bool
+	// Prune removes APIs that no longer exist in their source platform.
+	Prune bool
+	// PruneSource limits pruning to the resources created from this source platform, for runs in a temporary
+	// job workspace that only has the APIs of that source.
+	PruneSource string
+	// Protected lists API and deployment names that are never removed by pruning.
+	Protected []string
+	// Refresh compares all APIs with the target, also the ones unchanged since the last sync.
+	Refresh bool
+	// Workspace is the directory the files of the run are in, see workspaceRoot.
+	Workspace string
+}
+
+// PlatformRegistration describes a platform, how to create it, and its CLI commands.
+type PlatformRegistration struct {
+	Name        string
+	Description string
+	New         func(options PlatformOptions) Platform
+	Commands    func(cli *clir.Cli)
+	// Source and Target tell if New returns a SourcePlatform or a TargetPlatform,
+	// so that the names can be listed without creating the platforms from the env.
+	Source bool
+	Target bool
+	// PlatformId is the ID a source platform sets in the general APIs it offramps, e.g. azure-api-management.
+	PlatformId string
+}
+
+var platforms = map[string]PlatformRegistration{}
+
+func registerPlatform(registration PlatformRegistration) {
+	platforms[registration.Name] = registration
+}
+
+func platformNames() []string {
+	names := []string{}
+	for name := range platforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sourcePlatformNames() []string {
+	names := []string{}
+	for _, name := range platformNames() {
+		if platforms[name].Source {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func targetPlatformNames() []string {
+	names := []string{}
+	for _, name := range platformNames() {
+		if platforms[name].Target {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sourcePlatformId returns the platform ID of a source platform, or the name if it is no known source.
+func sourcePlatformId(name string) string {
+	if registration, ok := platforms[name]; ok && registration.PlatformId != "" {
+		return registration.PlatformId
+	}
+	return name
+}
+
+func newSourcePlatform(name string, options PlatformOptions) (SourcePlatform, bool) {
+	registration, ok := platforms[name]
+	if !ok {
+		return nil, false
+	}
+	source, ok := registration.New(options).(SourcePlatform)
+	return source, ok
+}
+
+func newTargetPlatform(name string, options PlatformOptions) (TargetPlatform, bool) {
+	registration, ok := platforms[name]
+	if !ok {
+		return nil, false
+	}
+	target, ok := registration.New(options).(TargetPlatform)
+	return target, ok
+}
+
+func registerPlatformCommands(cli *clir.Cli) {
+	for _, name := range platformNames() {
+		if platforms[name].Commands != nil {
+			platforms[name].Commands(cli)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestPlatformRegistrations checks that the registrations tell the capabilities of the platforms they create.
+func TestPlatformRegistrations(t *testing.T) {
+	for _, name := range platformNames() {
+		platform := platforms[name].New(PlatformOptions{})
+		if _, ok := platform.(SourcePlatform); ok != platforms[name].Source {
+			t.Errorf("%s: registered as source %v, but creates a source platform %v", name, platforms[name].Source, ok)
+		}
+		if _, ok := platform.(TargetPlatform); ok != platforms[name].Target {
+			t.Errorf("%s: registered as target %v, but creates a target platform %v", name, platforms[name].Target, ok)
+		}
+	}
+
+	if names := sourcePlatformNames(); !slices.Equal(names, []string{"aws", "azure"}) {
+		t.Errorf("expected the sources aws and azure, got %v", names)
+	}
+	if names := targetPlatformNames(); !slices.Equal(names, []string{"apihub"}) {
+		t.Errorf("expected the target apihub, got %v", names)
+	}
+}
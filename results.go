@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StageResult is the result of a pipeline stage, like export, offramp, onramp or import, on one platform.
+// It lists the APIs the stage touched and the ones that failed.
+type StageResult struct {
+	Stage    string       `json:"stage" enum:"export,offramp,onramp,import,clean" doc:"The pipeline stage."`
+	Platform string       `json:"platform" example:"azure" doc:"The platform the stage ran on."`
+	Apis     []string     `json:"apis" example:"[\"petstore\"]" doc:"The APIs the stage processed successfully."`
+	Failed   []ApiFailure `json:"failed" doc:"The APIs the stage failed for, with the reason."`
+}
+
+type ApiFailure struct {
+	Api   string `json:"api" example:"petstore" doc:"The API name."`
+	Error string `json:"error" doc:"Why the API failed."`
+}
+
+func newStageResult(stage string, platform string) StageResult {
+	return StageResult{Stage: stage, Platform: platform, Apis: []string{}, Failed: []ApiFailure{}}
+}
+
+func (r *StageResult) ok(api string) {
+	r.Apis = append(r.Apis, api)
+	apisTotal.add(1, r.Platform, r.Stage)
+}
+
+func (r *StageResult) fail(api string, err error) {
+	fmt.Println("  >> Error in " + r.Stage + " of " + api + ": " + err.Error())
+	r.Failed = append(r.Failed, ApiFailure{Api: api, Error: err.Error()})
+	apiFailuresTotal.add(1, r.Platform, r.Stage)
+}
+
+// Err returns a StageError if any API failed, or nil.
+func (r StageResult) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	return &StageError{Result: r}
+}
+
+// Summary returns a one line description of the result.
+func (r StageResult) Summary() string {
+	summary := r.Platform + " " + r.Stage + ": " + strconv.Itoa(len(r.Apis)) + " API(s) succeeded"
+	if len(r.Failed) > 0 {
+		summary = summary + ", " + strconv.Itoa(len(r.Failed)) + " failed"
+	}
+	return summary + "."
+}
+
+// StageError is returned by a stage when some of its APIs failed. The other APIs were processed.
+type StageError struct {
+	Result StageResult
+}
+
+func (e *StageError) Error() string {
+	failures := []string{}
+	for _, failure := range e.Result.Failed {
+		failures = append(failures, failure.Api+": "+failure.Error)
+	}
+	return e.Result.Summary() + " " + strings.Join(failures, "; ")
+}
+
+// Partial returns true if some APIs of the stage succeeded.
+func (e *StageError) Partial() bool {
+	return len(e.Result.Apis) > 0
+}
+
+// onlyApisFailed returns true if err is a StageError, so the stage ran and only some of its APIs failed.
+func onlyApisFailed(err error) bool {
+	var stageErr *StageError
+	return errors.As(err, &stageErr)
+}
+
+// printStageResult prints the summary of a result and returns its error, for the CLI commands.
+func printStageResult(result StageResult, err error) error {
+	fmt.Println(result.Summary())
+	return err
+}
+
+// exitCode returns the exit code for the error of a command: 2 if only some APIs failed, or else 1.
+func exitCode(err error) int {
+	var stageErr *StageError
+	if errors.As(err, &stageErr) && stageErr.Partial() {
+		return 2
+	}
+	return 1
+}
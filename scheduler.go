@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScheduleConfig is a sync from a source to a target platform that the web server runs on a cron schedule,
+// or only when it is triggered if it has no cron expression.
+type ScheduleConfig struct {
+	Name     string `json:"name,omitempty" example:"azure-to-apihub" doc:"The schedule name, by default offramp-to-onramp."`
+	Cron     string `json:"cron,omitempty" example:"*/30 * * * *" doc:"The cron expression (minute hour day month weekday), or @hourly, @daily, @weekly, @monthly or @every <duration>. Without it the sync only runs when it is triggered."`
+	Timezone string `json:"timezone,omitempty" example:"Europe/Berlin" doc:"The time zone of the cron expression, by default the local time zone."`
+	Offramp  string `json:"offramp" example:"azure" doc:"The platform to offramp the APIs from."`
+	Onramp   string `json:"onramp" example:"apihub" doc:"The platform to onramp the APIs to."`
+	Prune    bool   `json:"prune,omitempty" doc:"Remove APIs created by apimsync whose source no longer exists."`
+	Paused   bool   `json:"paused,omitempty" doc:"If the schedule is paused, it can still be triggered."`
+}
+
+type ScheduleConfigs struct {
+	Schedules []ScheduleConfig `json:"schedules"`
+}
+
+// Schedule is the state of a schedule of the web server.
+type Schedule struct {
+	ScheduleConfig
+	Running     bool         `json:"running" doc:"If a sync of the schedule is running."`
+	NextRun     *time.Time   `json:"nextRun,omitempty" doc:"When the schedule runs next, if it isn't paused."`
+	LastRun     *ScheduleRun `json:"lastRun,omitempty" doc:"The outcome of the last run."`
+	LastSkipped *time.Time   `json:"lastSkipped,omitempty" doc:"When a run was last skipped, because the previous run was still running."`
+
+	cron CronSchedule
+	// location is the time zone of the cron expression
+	location *time.Location
+}
+
+// ScheduleRun is the outcome of a run of a schedule.
+type ScheduleRun struct {
+	Job      string     `json:"job,omitempty" example:"3f2a9c1b7d4e8f60" doc:"The job of the run."`
+	Trigger  string     `json:"trigger" enum:"cron,manual" doc:"What started the run."`
+	Status   string     `json:"status" enum:"running,succeeded,failed" doc:"The status of the run."`
+	Message  string     `json:"message,omitempty" doc:"The outcome of the run."`
+	Started  time.Time  `json:"started" doc:"When the run started."`
+	Finished *time.Time `json:"finished,omitempty" doc:"When the run finished."`
+}
+
+var schedules = map[string]*Schedule{}
+var schedulesMutex sync.Mutex
+
+// schedulesChanged wakes up the scheduler when schedules are paused or resumed.
+var schedulesChanged = make(chan bool, 1)
+
+var errScheduleRunning = errors.New("the schedule is already running")
+
+// loadScheduleConfigs reads the schedules from a JSON file.
+func loadScheduleConfigs(path string) ([]ScheduleConfig, error) {
+	byteValue, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs ScheduleConfigs
+	if err := json.Unmarshal(byteValue, &configs); err != nil {
+		return nil, errors.New("could not parse " + path + ": " + err.Error())
+	}
+	return configs.Schedules, nil
+}
+
+// newSchedules checks the cron expressions and platforms of the schedules, and that their names are unique.
+func newSchedules(configs []ScheduleConfig) ([]*Schedule, error) {
+	result := []*Schedule{}
+	names := map[string]bool{}
+	for _, config := range configs {
+		schedule, err := newSchedule(config)
+		if err != nil {
+			return nil, err
+		}
+		if names[schedule.Name] {
+			return nil, errors.New("schedule " + schedule.Name + " is defined more than once")
+		}
+		names[schedule.Name] = true
+		result = append(result, schedule)
+	}
+	return result, nil
+}
+
+func newSchedule(config ScheduleConfig) (*Schedule, error) {
+	if config.Name == "" {
+		config.Name = config.Offramp + "-to-" + config.Onramp
+	}
+	var cron CronSchedule
+	var err error
+	if config.Cron != "" {
+		if cron, err = parseCron(config.Cron); err != nil {
+			return nil, errors.New("schedule " + config.Name + ": " + err.Error())
+		}
+	}
+	location := time.Local
+	if config.Timezone != "" {
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, errors.New("schedule " + config.Name + ": unknown time zone " + config.Timezone)
+		}
+	}
+	if !platforms[config.Offramp].Source {
+		return nil, errors.New("schedule " + config.Name + ": unknown offramp platform " + config.Offramp)
+	}
+	if !platforms[config.Onramp].Target {
+		return nil, errors.New("schedule " + config.Name + ": unknown onramp platform " + config.Onramp)
+	}
+
+	return &Schedule{ScheduleConfig: config, cron: cron, location: location}, nil
+}
+
+// startScheduler runs the schedules in the background, until ctx is done.
+func startScheduler(ctx context.Context, loaded []*Schedule) {
+	schedulesMutex.Lock()
+	now := time.Now()
+	for _, schedule := range loaded {
+		schedules[schedule.Name] = schedule
+		schedule.setNextRun(now)
+		if schedule.Cron == "" {
+			fmt.Println("Added sync " + schedule.Name + " from " + schedule.Offramp + " to " + schedule.Onramp + ", it runs when it is triggered.")
+		} else {
+			fmt.Println("Scheduled sync " + schedule.Name + " from " + schedule.Offramp + " to " + schedule.Onramp + " at " + schedule.Cron + ".")
+		}
+	}
+	schedulesMutex.Unlock()
+
+	go func() {
+		for {
+			timer := time.NewTimer(runDueSchedules(time.Now()))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-schedulesChanged:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// runDueSchedules starts the schedules whose next run is due, and returns how long to wait for the next one.
+func runDueSchedules(now time.Time) time.Duration {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	wait := time.Hour
+	for _, schedule := range schedules {
+		if schedule.Paused || schedule.NextRun == nil {
+			continue
+		}
+		if !schedule.NextRun.After(now) {
+			schedule.run("cron")
+			schedule.setNextRun(now)
+		}
+		if schedule.NextRun != nil {
+			wait = min(wait, schedule.NextRun.Sub(now))
+		}
+	}
+	return max(wait, time.Second)
+}
+
+// setNextRun computes the next run after now, schedulesMutex must be held.
+func (schedule *Schedule) setNextRun(now time.Time) {
+	next := time.Time{}
+	if schedule.Cron != "" {
+		next = schedule.cron.Next(now.In(schedule.location))
+	}
+	if next.IsZero() {
+		schedule.NextRun = nil
+	} else {
+		schedule.NextRun = &next
+	}
+}
+
+// run starts a sync job for the schedule, unless the last one or another run is still running.
+// schedulesMutex must be held.
+func (schedule *Schedule) run(trigger string) (Job, error) {
+	now := time.Now().UTC()
+	if schedule.Running {
+		fmt.Println("Skipping sync " + schedule.Name + ", the last run is still running.")
+		schedule.LastSkipped = &now
+		return Job{}, errScheduleRunning
+	}
+
+	workspace, cleanup, err := newJobWorkspace()
+	if err != nil {
+		fmt.Println("Skipping sync " + schedule.Name + ", " + err.Error() + ".")
+		schedule.LastSkipped = &now
+		return Job{}, err
+	}
+	ctx, release, err := acquireRunLock(context.Background(), webServerWorkspace, "schedule "+schedule.Name+" from "+schedule.Offramp+" to "+schedule.Onramp)
+	if err != nil {
+		cleanup()
+		fmt.Println("Skipping sync " + schedule.Name + ", " + err.Error() + ".")
+		schedule.LastSkipped = &now
+		return Job{}, err
+	}
+
+	source, _ := newSourcePlatform(schedule.Offramp, PlatformOptions{Prune: schedule.Prune, Workspace: workspace})
+	target, _ := newTargetPlatform(schedule.Onramp, PlatformOptions{Prune: schedule.Prune, PruneSource: jobPruneSource(workspace, schedule.Offramp), Workspace: workspace})
+	job := startJob(ctx, "sync", schedule.Offramp, schedule.Onramp, workspace, func(ctx context.Context, job *Job) error {
+		defer cleanup()
+		defer release()
+		return runSyncJob(ctx, job, source, target)
+	})
+
+	schedule.Running = true
+	schedule.LastRun = &ScheduleRun{Job: job.Id, Trigger: trigger, Status: JobRunning, Started: now}
+	run := schedule.LastRun
+
+	// record the outcome when the job finished
+	go func() {
+		watchJob(context.Background(), job.Id, func(event any) error { return nil })
+		finished, _ := getJob(job.Id)
+
+		schedulesMutex.Lock()
+		defer schedulesMutex.Unlock()
+		schedule.Running = false
+		run.Status = finished.Status
+		run.Message = finished.Message
+		run.Finished = finished.Finished
+	}()
+
+	return job, nil
+}
+
+func listSchedules() []Schedule {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	result := []Schedule{}
+	for _, schedule := range schedules {
+		result = append(result, schedule.snapshot())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// snapshot returns a copy of the schedule, schedulesMutex must be held.
+func (schedule *Schedule) snapshot() Schedule {
+	result := *schedule
+	if schedule.Paused {
+		result.NextRun = nil
+	}
+	if schedule.LastRun != nil {
+		lastRun := *schedule.LastRun
+		result.LastRun = &lastRun
+	}
+	return result
+}
+
+// pauseSchedule pauses or resumes a schedule, it returns false if the schedule doesn't exist.
+func pauseSchedule(name string, paused bool) (Schedule, bool) {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	schedule, ok := schedules[name]
+	if !ok {
+		return Schedule{}, false
+	}
+	schedule.Paused = paused
+	if !paused {
+		schedule.setNextRun(time.Now())
+	}
+
+	select {
+	case schedulesChanged <- true:
+	default:
+	}
+	return schedule.snapshot(), true
+}
+
+// triggerSchedule runs a schedule now, also if it is paused.
+func triggerSchedule(name string) (Job, bool, error) {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	schedule, ok := schedules[name]
+	if !ok {
+		return Job{}, false, nil
+	}
+	job, err := schedule.run("manual")
+	return job, true, err
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// withSchedules makes the schedules of the configs the schedules of the web server until the test is done,
+// with their next runs computed from now. The scheduler itself isn't started, the tests call runDueSchedules.
+func withSchedules(t *testing.T, now time.Time, configs ...ScheduleConfig) {
+	t.Helper()
+	loaded, err := newSchedules(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedulesMutex.Lock()
+	previous := schedules
+	schedules = map[string]*Schedule{}
+	for _, schedule := range loaded {
+		schedule.location = time.UTC
+		schedule.setNextRun(now)
+		schedules[schedule.Name] = schedule
+	}
+	schedulesMutex.Unlock()
+	t.Cleanup(func() {
+		schedulesMutex.Lock()
+		schedules = previous
+		schedulesMutex.Unlock()
+	})
+}
+
+// getSchedule returns a copy of a schedule.
+func getSchedule(name string) Schedule {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+	return schedules[name].snapshot()
+}
+
+// waitForSchedule waits until the last run of a schedule finished and was recorded.
+func waitForSchedule(t *testing.T, name string) Schedule {
+	t.Helper()
+	deadline := time.Now().Add(time.Minute)
+	for {
+		schedule := getSchedule(name)
+		if schedule.LastRun == nil {
+			t.Fatalf("schedule %s has not run", name)
+		}
+		if !schedule.Running {
+			return schedule
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("schedule %s is still running", name)
+		}
+		watchJob(context.Background(), schedule.LastRun.Job, func(event any) error { return nil })
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunDueSchedules(t *testing.T) {
+	newE2eSuite(t, "").webServer(JobWorkspaceShared)
+	now := time.Date(2024, 3, 8, 10, 0, 0, 0, time.UTC)
+	withSchedules(t, now,
+		ScheduleConfig{Name: "half-hourly", Cron: "*/30 * * * *", Offramp: "azure", Onramp: "apihub"},
+		ScheduleConfig{Name: "hourly", Cron: "@hourly", Offramp: "aws", Onramp: "apihub"},
+		ScheduleConfig{Name: "paused", Cron: "*/30 * * * *", Offramp: "aws", Onramp: "apihub", Paused: true},
+		ScheduleConfig{Name: "manual", Offramp: "azure", Onramp: "apihub"},
+	)
+
+	// nothing is due yet, the next run is in 30 minutes
+	if wait := runDueSchedules(now); wait != 30*time.Minute {
+		t.Errorf("expected to wait 30m, got %s", wait)
+	}
+	if manual := getSchedule("manual"); manual.NextRun != nil {
+		t.Errorf("expected no next run without cron, got %s", manual.NextRun)
+	}
+
+	due := now.Add(30 * time.Minute)
+	if wait := runDueSchedules(due); wait != 30*time.Minute {
+		t.Errorf("expected to wait 30m for both schedules, got %s", wait)
+	}
+	schedule := waitForSchedule(t, "half-hourly")
+	if schedule.LastRun.Trigger != "cron" || schedule.LastRun.Status != JobSucceeded || schedule.LastRun.Finished == nil {
+		t.Errorf("expected a succeeded cron run, got %+v", schedule.LastRun)
+	}
+	if !schedule.NextRun.Equal(due.Add(30 * time.Minute)) {
+		t.Errorf("expected the next run at %s, got %s", due.Add(30*time.Minute), schedule.NextRun)
+	}
+	for _, name := range []string{"hourly", "paused", "manual"} {
+		if getSchedule(name).LastRun != nil {
+			t.Errorf("expected %s not to run", name)
+		}
+	}
+}
+
+func TestRunDueSchedulesRunning(t *testing.T) {
+	newE2eSuite(t, "").webServer(JobWorkspaceShared)
+	now := time.Date(2024, 3, 8, 10, 0, 0, 0, time.UTC)
+	withSchedules(t, now, ScheduleConfig{Name: "azure-to-apihub", Cron: "*/30 * * * *", Offramp: "azure", Onramp: "apihub"})
+
+	// the last run is still running, so the due run is skipped
+	schedulesMutex.Lock()
+	schedules["azure-to-apihub"].Running = true
+	schedulesMutex.Unlock()
+	runDueSchedules(now.Add(30 * time.Minute))
+
+	schedule := getSchedule("azure-to-apihub")
+	if schedule.LastSkipped == nil || schedule.LastRun != nil {
+		t.Errorf("expected the run to be skipped, got %+v", schedule)
+	}
+	if !schedule.NextRun.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the next run after the skipped one, got %s", schedule.NextRun)
+	}
+	if _, found, err := triggerSchedule("azure-to-apihub"); !found || !errors.Is(err, errScheduleRunning) {
+		t.Errorf("expected a trigger to fail while the schedule runs, got %v", err)
+	}
+}
+
+func TestPauseSchedule(t *testing.T) {
+	newE2eSuite(t, "").webServer(JobWorkspaceShared)
+	now := time.Date(2024, 3, 8, 10, 0, 0, 0, time.UTC)
+	withSchedules(t, now, ScheduleConfig{Name: "azure-to-apihub", Cron: "*/30 * * * *", Offramp: "azure", Onramp: "apihub"})
+
+	paused, found := pauseSchedule("azure-to-apihub", true)
+	if !found || !paused.Paused || paused.NextRun != nil {
+		t.Errorf("expected a paused schedule without a next run, got %+v", paused)
+	}
+	runDueSchedules(now.Add(30 * time.Minute))
+	if schedule := getSchedule("azure-to-apihub"); schedule.LastRun != nil || schedule.LastSkipped != nil {
+		t.Errorf("expected a paused schedule not to run, got %+v", schedule)
+	}
+
+	resumed, _ := pauseSchedule("azure-to-apihub", false)
+	if resumed.Paused || resumed.NextRun == nil || !resumed.NextRun.After(time.Now()) {
+		t.Errorf("expected a resumed schedule to run next after now, got %+v", resumed)
+	}
+	if _, found := pauseSchedule("missing", true); found {
+		t.Error("expected a missing schedule not to be found")
+	}
+}
+
+func TestTriggerSchedule(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.webServer(JobWorkspaceShared)
+	withSchedules(t, time.Now(), ScheduleConfig{Name: "azure-to-apihub", Offramp: "azure", Onramp: "apihub", Paused: true})
+
+	job, found, err := triggerSchedule("azure-to-apihub")
+	if !found || err != nil {
+		t.Fatalf("expected the paused schedule to be triggered, got %v", err)
+	}
+	schedule := waitForSchedule(t, "azure-to-apihub")
+	if schedule.LastRun.Job != job.Id || schedule.LastRun.Trigger != "manual" || schedule.LastRun.Status != JobSucceeded {
+		t.Errorf("expected a succeeded manual run of job %s, got %+v", job.Id, schedule.LastRun)
+	}
+	s.checkNames("apis", "orders", "petstore")
+
+	if _, found, _ := triggerSchedule("missing"); found {
+		t.Error("expected a missing schedule not to be found")
+	}
+}
+
+// TestTriggerScheduleLocked checks that a run is skipped while another run holds the lock of the web server workspace.
+func TestTriggerScheduleLocked(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.webServer(JobWorkspaceShared)
+	withSchedules(t, time.Now(), ScheduleConfig{Name: "azure-to-apihub", Offramp: "azure", Onramp: "apihub"})
+
+	_, release, err := acquireRunLock(context.Background(), s.workspace, "another run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, _, err := triggerSchedule("azure-to-apihub"); err == nil {
+		t.Error("expected the run to be skipped while the workspace is locked")
+	}
+	if schedule := getSchedule("azure-to-apihub"); schedule.LastSkipped == nil || schedule.Running {
+		t.Errorf("expected a skipped run, got %+v", schedule)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type SearchFlags struct {
+	Query     string `name:"query" description:"The words to search for, e.g. \"customers\" or \"/customers/{id}\". Without a query all APIs match."`
+	Platform  string `name:"platform" description:"Only APIs deployed to this platform, by name or platform ID."`
+	Owner     string `name:"owner" description:"Only APIs of this owner, by name or email."`
+	Version   string `name:"version" description:"Only APIs with this version."`
+	Limit     int    `name:"limit" description:"The maximum number of APIs to show, default is all."`
+	Workspace string `name:"workspace" description:"The workspace the files are in, a directory or e.g. s3://bucket/prefix, default is APIMSYNC_WORKSPACE or src/main."`
+}
+
+// SearchFilters restricts a search to the APIs with a facet value.
+type SearchFilters struct {
+	Platform string
+	Owner    string
+	Version  string
+}
+
+// SearchResult is the outcome of a search, with the facets of all APIs that matched the query.
+type SearchResult struct {
+	Query  string                   `json:"query" example:"customers" doc:"The query that was searched for."`
+	Total  int                      `json:"total" doc:"The number of APIs that matched, across all pages."`
+	Hits   []SearchHit              `json:"hits" doc:"The APIs that matched, best match first."`
+	Facets map[string][]SearchFacet `json:"facets" doc:"The number of APIs matching the query for each platform, owner and version, before the filters are applied."`
+}
+
+// SearchHit is a general API that matched a search, with the fields that matched the query.
+type SearchHit struct {
+	Name        string        `json:"name" example:"petstore" doc:"The general API name."`
+	DisplayName string        `json:"displayName" example:"Petstore" doc:"The display name of the API."`
+	Description string        `json:"description" doc:"The description of the API."`
+	Platforms   []string      `json:"platforms" example:"[\"azure\",\"aws\"]" doc:"The platforms the API is deployed to."`
+	Score       int           `json:"score" doc:"How well the API matched, higher is better."`
+	Matches     []SearchMatch `json:"matches" doc:"The fields that matched the query."`
+}
+
+// SearchMatch is a field of an API that matched a search, e.g. the path /customers/{id}.
+type SearchMatch struct {
+	Field string `json:"field" enum:"name,displayName,description,owner,version,basePath,gatewayUrl,path,operation,schema" doc:"The field that matched."`
+	Value string `json:"value" example:"/customers/{id}" doc:"The value of the field."`
+}
+
+// SearchFacet is a facet value with the number of matching APIs that have it.
+type SearchFacet struct {
+	Value string `json:"value" example:"azure" doc:"The facet value."`
+	Count int    `json:"count" doc:"The number of matching APIs with this value."`
+}
+
+// searchFieldWeights is how much a match in each field counts, so that APIs named after the query come first.
+var searchFieldWeights = map[string]int{
+	"name":        4,
+	"displayName": 4,
+	"path":        3,
+	"operation":   2,
+	"schema":      2,
+	"basePath":    2,
+}
+
+// maxSearchMatches is how many matching fields are listed for each hit.
+const maxSearchMatches = 10
+
+// SearchIndex is an inverted index of the general APIs, from the tokens of their fields to the fields.
+type SearchIndex struct {
+	apis     []CatalogApi
+	fields   [][]SearchMatch
+	tokens   []string
+	postings map[string][]searchPosting
+}
+
+type searchPosting struct {
+	api   int
+	field int
+}
+
+// buildSearchIndex indexes the general APIs of the workspace, with the paths, operations and schema names of
+// their OpenAPI documents.
+func buildSearchIndex(storage Storage) *SearchIndex {
+	index := &SearchIndex{apis: listCatalogApis(storage, ""), postings: map[string][]searchPosting{}}
+	for i, api := range index.apis {
+		fields := searchFields(api)
+		index.fields = append(index.fields, fields)
+		for j, field := range fields {
+			for _, token := range searchTokens(field.Value) {
+				postings := index.postings[token]
+				if len(postings) > 0 && postings[len(postings)-1] == (searchPosting{i, j}) {
+					continue
+				}
+				index.postings[token] = append(postings, searchPosting{i, j})
+			}
+		}
+	}
+	index.tokens = sortedKeys(index.postings)
+	return index
+}
+
+// searchFields returns the distinct fields of a general API and its platform APIs that are searched.
+func searchFields(api CatalogApi) []SearchMatch {
+	fields := []SearchMatch{}
+	add := func(field string, value string) {
+		if value != "" && !slices.Contains(fields, SearchMatch{field, value}) {
+			fields = append(fields, SearchMatch{field, value})
+		}
+	}
+
+	for _, generalApi := range append([]GeneralApi{api.GeneralApi}, api.deploymentApis()...) {
+		add("name", generalApi.Name)
+		add("displayName", generalApi.DisplayName)
+		add("description", generalApi.Description)
+		add("owner", generalApi.OwnerName)
+		add("owner", generalApi.OwnerEmail)
+		add("version", generalApi.Version)
+		add("basePath", generalApi.BasePath)
+		add("gatewayUrl", generalApi.GatewayUrl)
+	}
+
+	for _, deployment := range api.Deployments {
+		if !deployment.HasSpec {
+			continue
+		}
+		byteValue, err := api.storage.ReadFile(api.dir + "/" + deployment.Name + "-oas.json")
+		if err != nil {
+			continue
+		}
+		paths, operations, schemas := specSearchFields(byteValue)
+		for _, path := range paths {
+			add("path", path)
+		}
+		for _, operation := range operations {
+			add("operation", operation)
+		}
+		for _, schema := range schemas {
+			add("schema", schema)
+		}
+	}
+
+	return fields
+}
+
+func (api CatalogApi) deploymentApis() []GeneralApi {
+	result := []GeneralApi{}
+	for _, deployment := range api.Deployments {
+		result = append(result, deployment.GeneralApi)
+	}
+	return result
+}
+
+// specSearchFields returns the paths, operations and schema names of an OpenAPI 3 or Swagger 2 document.
+// Operations are listed as "GET /customers", followed by their operation ID and summary if they have one.
+func specSearchFields(byteValue []byte) ([]string, []string, []string) {
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+		Definitions map[string]json.RawMessage `json:"definitions"`
+	}
+	if json.Unmarshal(byteValue, &spec) != nil {
+		return nil, nil, nil
+	}
+
+	paths := sortedKeys(spec.Paths)
+	operations := []string{}
+	for _, path := range paths {
+		for _, method := range []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"} {
+			operationBytes, ok := spec.Paths[path][method]
+			if !ok {
+				continue
+			}
+			var operation struct {
+				OperationId string `json:"operationId"`
+				Summary     string `json:"summary"`
+			}
+			json.Unmarshal(operationBytes, &operation)
+			operations = append(operations, strings.Join(strings.Fields(strings.ToUpper(method)+" "+path+" "+operation.OperationId+" "+operation.Summary), " "))
+		}
+	}
+	schemas := append(sortedKeys(spec.Components.Schemas), sortedKeys(spec.Definitions)...)
+
+	return paths, operations, schemas
+}
+
+// searchTokens splits a text into lower case words, and camel case words also into their parts,
+// so that getCustomers is found by "getcustomers" and "customers".
+func searchTokens(text string) []string {
+	tokens := []string{}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		tokens = append(tokens, strings.ToLower(word))
+		start := 0
+		runes := []rune(word)
+		for i := 1; i < len(runes); i++ {
+			if unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1]) {
+				tokens = append(tokens, strings.ToLower(string(runes[start:i])))
+				start = i
+			}
+		}
+		if start > 0 {
+			tokens = append(tokens, strings.ToLower(string(runes[start:])))
+		}
+	}
+	return tokens
+}
+
+// search returns the APIs that match all words of the query as a prefix of a word in their fields, and the filters.
+func (index *SearchIndex) search(query string, filters SearchFilters) SearchResult {
+	result := SearchResult{Query: query, Hits: []SearchHit{}}
+
+	// the score of each API, and the fields that matched
+	scores := map[int]int{}
+	matched := map[int][]int{}
+	for i := range index.apis {
+		scores[i] = 0
+	}
+	for _, term := range searchTokens(query) {
+		termScores := map[int]int{}
+		start := sort.SearchStrings(index.tokens, term)
+		for _, token := range index.tokens[start:] {
+			if !strings.HasPrefix(token, term) {
+				break
+			}
+			for _, posting := range index.postings[token] {
+				if _, ok := scores[posting.api]; !ok {
+					continue
+				}
+				weight := searchFieldWeights[index.fields[posting.api][posting.field].Field]
+				termScores[posting.api] += max(weight, 1)
+				if !slices.Contains(matched[posting.api], posting.field) {
+					matched[posting.api] = append(matched[posting.api], posting.field)
+				}
+			}
+		}
+		// every word must match
+		for i := range scores {
+			if termScores[i] == 0 {
+				delete(scores, i)
+			} else {
+				scores[i] += termScores[i]
+			}
+		}
+	}
+
+	// the facets count the APIs that match the query before the filters, so that the other values of a filtered
+	// facet can still be selected
+	facets := map[string]map[string]int{"platform": {}, "owner": {}, "version": {}}
+	for i, score := range scores {
+		api := index.apis[i]
+		values := searchFacetValues(api)
+		for facet, facetValues := range values {
+			for _, value := range facetValues {
+				facets[facet][value]++
+			}
+		}
+		if !filters.matches(api) {
+			continue
+		}
+
+		hit := SearchHit{Name: api.Name, DisplayName: api.DisplayName, Description: api.Description, Platforms: values["platform"], Score: score, Matches: []SearchMatch{}}
+		sort.Ints(matched[i])
+		for _, field := range matched[i] {
+			if len(hit.Matches) < maxSearchMatches {
+				hit.Matches = append(hit.Matches, index.fields[i][field])
+			}
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+	sort.Slice(result.Hits, func(i, j int) bool {
+		if result.Hits[i].Score != result.Hits[j].Score {
+			return result.Hits[i].Score > result.Hits[j].Score
+		}
+		return result.Hits[i].Name < result.Hits[j].Name
+	})
+	result.Total = len(result.Hits)
+
+	result.Facets = map[string][]SearchFacet{}
+	for facet, counts := range facets {
+		result.Facets[facet] = []SearchFacet{}
+		for _, value := range sortedKeys(counts) {
+			result.Facets[facet] = append(result.Facets[facet], SearchFacet{Value: value, Count: counts[value]})
+		}
+		sort.SliceStable(result.Facets[facet], func(i, j int) bool {
+			return result.Facets[facet][i].Count > result.Facets[facet][j].Count
+		})
+	}
+
+	return result
+}
+
+// searchFacetValues returns the platforms, owners and versions of a general API and its platform APIs.
+func searchFacetValues(api CatalogApi) map[string][]string {
+	values := map[string][]string{"platform": {}, "owner": {}, "version": {}}
+	add := func(facet string, value string) {
+		if value != "" && !slices.Contains(values[facet], value) {
+			values[facet] = append(values[facet], value)
+		}
+	}
+	for _, deployment := range api.Deployments {
+		add("platform", deployment.Platform)
+	}
+	for _, generalApi := range append([]GeneralApi{api.GeneralApi}, api.deploymentApis()...) {
+		if generalApi.OwnerName != "" {
+			add("owner", generalApi.OwnerName)
+		} else {
+			add("owner", generalApi.OwnerEmail)
+		}
+		add("version", generalApi.Version)
+	}
+	return values
+}
+
+// matches returns true if a general API or one of its platform APIs has the values of the filters.
+// Platforms can be given by name or platform ID, and owners by name or email.
+func (filters SearchFilters) matches(api CatalogApi) bool {
+	if filters.Platform != "" && len(api.deploymentsOn(filters.Platform)) == 0 {
+		return false
+	}
+	owner, version := filters.Owner == "", filters.Version == ""
+	for _, generalApi := range append([]GeneralApi{api.GeneralApi}, api.deploymentApis()...) {
+		owner = owner || strings.EqualFold(generalApi.OwnerName, filters.Owner) || strings.EqualFold(generalApi.OwnerEmail, filters.Owner)
+		version = version || generalApi.Version == filters.Version
+	}
+	return owner && version
+}
+
+func generalSearch(flags *SearchFlags) error {
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+
+	index := buildSearchIndex(storage)
+	if len(index.apis) == 0 {
+		return errors.New("no general APIs found, offramp APIs first")
+	}
+
+	result := index.search(flags.Query, SearchFilters{Platform: flags.Platform, Owner: flags.Owner, Version: flags.Version})
+	fmt.Println(strconv.Itoa(result.Total) + " API(s) found.")
+	for i, hit := range result.Hits {
+		if flags.Limit > 0 && i >= flags.Limit {
+			break
+		}
+		fmt.Println(hit.Name + " (" + hit.DisplayName + ") on " + strings.Join(hit.Platforms, ", "))
+		if flags.Query != "" {
+			for _, match := range hit.Matches {
+				fmt.Println("  " + match.Field + ": " + match.Value)
+			}
+		}
+	}
+
+	for _, facet := range []string{"platform", "owner", "version"} {
+		values := []string{}
+		for _, value := range result.Facets[facet] {
+			values = append(values, value.Value+" ("+strconv.Itoa(value.Count)+")")
+		}
+		if len(values) > 0 {
+			fmt.Println(facet + ": " + strings.Join(values, ", "))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// newSearchWorkspace writes general APIs with their platform APIs and specs to a mem:// workspace.
+func newSearchWorkspace(t *testing.T) Storage {
+	storage, err := workspaceStorage("mem://" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, value any) {
+		byteValue, _ := json.Marshal(value)
+		if err := storage.WriteFile("general/apiproxies/"+name, byteValue); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	customers := GeneralApi{Name: "customers", DisplayName: "Customers", Description: "Manage the customer accounts.", OwnerName: "Jane", Version: "v1", BasePath: "/crm"}
+	write("customers/customers.json", customers)
+	write("customers/customers-v1-azure.json", GeneralApi{Name: "customers-v1", PlatformId: "azure-api-management", OwnerName: "Jane", Version: "v1"})
+	write("customers/customers-v1-azure-oas.json", map[string]any{
+		"openapi":    "3.0.1",
+		"paths":      map[string]any{"/customers/{id}": map[string]any{"get": map[string]any{"operationId": "getCustomerById", "summary": "Read a customer"}}},
+		"components": map[string]any{"schemas": map[string]any{"Customer": map[string]any{}}},
+	})
+
+	write("orders/orders.json", GeneralApi{Name: "orders", DisplayName: "Orders", Description: "The orders of the customers.", OwnerEmail: "ops@example.com", Version: "v2"})
+	write("orders/orders-v2-aws.json", GeneralApi{Name: "orders-v2", PlatformId: "aws-api-gateway", OwnerEmail: "ops@example.com", Version: "v2"})
+
+	write("petstore/petstore.json", GeneralApi{Name: "petstore", DisplayName: "Petstore", OwnerName: "Jane", Version: "v1"})
+	write("petstore/petstore-v1-azure.json", GeneralApi{Name: "petstore-v1", PlatformId: "azure-api-management", Version: "v1"})
+	write("petstore/petstore-v1-aws.json", GeneralApi{Name: "petstore-v1", PlatformId: "aws-api-gateway", Version: "v1"})
+	write("petstore/petstore-v1-aws-oas.json", map[string]any{
+		"swagger":     "2.0",
+		"paths":       map[string]any{"/pets": map[string]any{"get": map[string]any{"operationId": "listPets"}, "post": map[string]any{}}},
+		"definitions": map[string]any{"Pet": map[string]any{}},
+	})
+	return storage
+}
+
+func TestSearchTokens(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"getCustomerById", []string{"getcustomerbyid", "get", "customer", "by", "id"}},
+		{"/customers/{id}", []string{"customers", "id"}},
+		{"GET /pets listPets", []string{"get", "pets", "listpets", "list", "pets"}},
+		{"HTTPServer", []string{"httpserver"}},
+		{"Orders v2, ops@example.com", []string{"orders", "v2", "ops", "example", "com"}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		if tokens := searchTokens(test.text); !reflect.DeepEqual(tokens, test.expected) {
+			t.Errorf("searchTokens(%q): expected %v, got %v", test.text, test.expected, tokens)
+		}
+	}
+}
+
+func TestBuildSearchIndex(t *testing.T) {
+	index := buildSearchIndex(newSearchWorkspace(t))
+	if len(index.apis) != 3 {
+		t.Fatalf("expected 3 APIs, got %d", len(index.apis))
+	}
+
+	expected := []SearchMatch{
+		{"name", "customers"}, {"displayName", "Customers"}, {"description", "Manage the customer accounts."},
+		{"owner", "Jane"}, {"version", "v1"}, {"basePath", "/crm"}, {"name", "customers-v1-azure"},
+		{"path", "/customers/{id}"}, {"operation", "GET /customers/{id} getCustomerById Read a customer"}, {"schema", "Customer"},
+	}
+	if !reflect.DeepEqual(index.fields[0], expected) {
+		t.Errorf("expected the fields %v, got %v", expected, index.fields[0])
+	}
+	// Swagger 2 paths, operations and definitions are indexed too
+	for _, token := range []string{"pets", "listpets", "pet", "post"} {
+		if postings := index.postings[token]; len(postings) == 0 || postings[0].api != 2 {
+			t.Errorf("expected %s to be indexed for petstore, got %v", token, postings)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	index := buildSearchIndex(newSearchWorkspace(t))
+	tests := []struct {
+		name    string
+		query   string
+		filters SearchFilters
+		hits    []string
+	}{
+		{"all APIs", "", SearchFilters{}, []string{"customers", "orders", "petstore"}},
+		// the name and paths of customers count more than the description of orders
+		{"scoring order", "customer", SearchFilters{}, []string{"customers", "orders"}},
+		{"prefix", "cust", SearchFilters{}, []string{"customers", "orders"}},
+		{"joined camel case parts", "byid", SearchFilters{}, []string{}},
+		{"camel case word", "getCustomer", SearchFilters{}, []string{"customers"}},
+		{"all words", "customers orders", SearchFilters{}, []string{"orders"}},
+		{"schema", "pet", SearchFilters{}, []string{"petstore"}},
+		{"no match", "invoices", SearchFilters{}, []string{}},
+		{"platform", "", SearchFilters{Platform: "aws"}, []string{"orders", "petstore"}},
+		{"platform id", "", SearchFilters{Platform: "azure-api-management"}, []string{"customers", "petstore"}},
+		{"owner name", "", SearchFilters{Owner: "jane"}, []string{"customers", "petstore"}},
+		{"owner email", "customer", SearchFilters{Owner: "ops@example.com"}, []string{"orders"}},
+		{"version", "", SearchFilters{Version: "v2"}, []string{"orders"}},
+		{"filters", "", SearchFilters{Platform: "azure", Version: "v2"}, []string{}},
+	}
+	for _, test := range tests {
+		result := index.search(test.query, test.filters)
+		names := []string{}
+		for _, hit := range result.Hits {
+			names = append(names, hit.Name)
+		}
+		if !reflect.DeepEqual(names, test.hits) || result.Total != len(test.hits) {
+			t.Errorf("%s: expected %v, got %v (total %d)", test.name, test.hits, names, result.Total)
+		}
+	}
+
+	result := index.search("customer", SearchFilters{})
+	customers, orders := result.Hits[0], result.Hits[1]
+	if customers.Score <= orders.Score || !reflect.DeepEqual(orders.Matches, []SearchMatch{{"description", "The orders of the customers."}}) {
+		t.Errorf("expected orders to match only in its description, got %+v and %+v", customers, orders)
+	}
+	if !reflect.DeepEqual(customers.Platforms, []string{"azure"}) || len(customers.Matches) != 7 || customers.Matches[0] != (SearchMatch{"name", "customers"}) {
+		t.Errorf("expected the matching fields of customers, got %+v", customers)
+	}
+}
+
+// TestSearchFacets checks that the facets count the APIs matching the query, also the ones a filter hides.
+func TestSearchFacets(t *testing.T) {
+	index := buildSearchIndex(newSearchWorkspace(t))
+	expected := map[string][]SearchFacet{
+		"platform": {{"aws", 2}, {"azure", 2}},
+		"owner":    {{"Jane", 2}, {"ops@example.com", 1}},
+		"version":  {{"v1", 2}, {"v2", 1}},
+	}
+	for _, filters := range []SearchFilters{{}, {Platform: "azure"}, {Owner: "ops@example.com", Version: "v2"}} {
+		if result := index.search("", filters); !reflect.DeepEqual(result.Facets, expected) {
+			t.Errorf("%+v: expected the facets %v, got %v", filters, expected, result.Facets)
+		}
+	}
+
+	expected = map[string][]SearchFacet{
+		"platform": {{"aws", 1}, {"azure", 1}},
+		"owner":    {{"Jane", 1}},
+		"version":  {{"v1", 1}},
+	}
+	if result := index.search("pets", SearchFilters{Platform: "azure"}); !reflect.DeepEqual(result.Facets, expected) || len(result.Hits) != 1 {
+		t.Errorf("expected the facets of petstore, got %v", result.Facets)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncState records which general APIs were synced to which target resources, and with what content.
+type SyncState struct {
+	Apis map[string]SyncStateApi `json:"apis"`
+}
+
+type SyncStateApi struct {
+	Name        string                     `json:"name" example:"petstore" doc:"The general API name."`
+	Sources     []string                   `json:"sources" example:"[\"azure-api-management\"]" doc:"The platform IDs the API was offramped from."`
+	ContentHash string                     `json:"contentHash" doc:"The hash of the general API content when it was last synced."`
+	Targets     map[string]SyncStateTarget `json:"targets" doc:"The sync state per target platform."`
+}
+
+type SyncStateTarget struct {
+	ContentHash string    `json:"contentHash" doc:"The hash of the general API content that was synced to the target."`
+	Resources   []string  `json:"resources" doc:"The target resource names that were created or updated."`
+	LastSynced  time.Time `json:"lastSynced" doc:"When the API was last synced to the target."`
+}
+
+type SyncStatusFlags struct {
+	ApiName   string `name:"api" description:"A specific general API."`
+	Target    string `name:"target" description:"The target platform, default is apihub."`
+	Workspace string `name:"workspace" description:"The workspace the files are in, a directory or e.g. s3://bucket/prefix, default is APIMSYNC_WORKSPACE or src/main."`
+}
+
+var syncStateMutex sync.Mutex
+
+// syncStateFile returns the storage and name of the sync state file of a workspace, syncstate.json in the workspace
+// unless APIMSYNC_STATE_FILE gives a local file. Temporary job workspaces keep their state in the web server
+// workspace, so that it outlives them.
+func syncStateFile(workspace string) (Storage, string, error) {
+	if file := os.Getenv("APIMSYNC_STATE_FILE"); file != "" {
+		return &localStorage{root: filepath.Dir(file)}, filepath.Base(file), nil
+	}
+	storage, err := workspaceStorage(stateWorkspace(workspace))
+	return storage, "syncstate.json", err
+}
+
+// loadSyncState reads the sync state of a workspace, which is empty before the first sync.
+func loadSyncState(workspace string) (SyncState, error) {
+	state := SyncState{Apis: map[string]SyncStateApi{}}
+	stateStorage, file, err := syncStateFile(workspace)
+	if err != nil {
+		return state, err
+	}
+	byteValue, err := stateStorage.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return state, errors.New("could not read the sync state: " + err.Error())
+	}
+	if err == nil {
+		json.Unmarshal(byteValue, &state)
+	}
+	if state.Apis == nil {
+		state.Apis = map[string]SyncStateApi{}
+	}
+	return state, nil
+}
+
+func saveSyncState(workspace string, state SyncState) error {
+	bytes, _ := json.MarshalIndent(state, "", "  ")
+	stateStorage, file, err := syncStateFile(workspace)
+	if err != nil {
+		return err
+	}
+	return stateStorage.WriteFile(file, bytes)
+}
+
+// generalApiHash hashes all of the files of a general API, so that any change to it changes the hash.
+func generalApiHash(storage Storage, apiName string) (string, []string) {
+	baseDir := "general/apiproxies/" + apiName
+	hash := sha256.New()
+	sources := []string{}
+
+	fileEntries, _ := storage.ReadDir(baseDir)
+	for _, f := range fileEntries {
+		byteValue, err := storage.ReadFile(baseDir + "/" + f.Name())
+		if err != nil {
+			continue
+		}
+		hash.Write([]byte(f.Name()))
+		hash.Write(byteValue)
+
+		if strings.HasSuffix(f.Name(), "-aws.json") || strings.HasSuffix(f.Name(), "-azure.json") {
+			var generalApi GeneralApi
+			json.Unmarshal(byteValue, &generalApi)
+			if generalApi.PlatformId != "" && !slices.Contains(sources, generalApi.PlatformId) {
+				sources = append(sources, generalApi.PlatformId)
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), sources
+}
+
+// syncedUnchanged returns true if the general API was already synced to the target with the same content.
+func syncedUnchanged(storage Storage, state SyncState, target string, apiName string) bool {
+	api, ok := state.Apis[apiName]
+	if !ok {
+		return false
+	}
+	targetState, ok := api.Targets[target]
+	if !ok {
+		return false
+	}
+	hash, _ := generalApiHash(storage, apiName)
+	return targetState.ContentHash == hash
+}
+
+// applySyncPlan applies a plan to the target and records the APIs that were synced without errors in the
+// sync state of the workspace, with the content hashes of the plan. Nothing is recorded if the plan could not
+// be applied at all.
+func applySyncPlan(ctx context.Context, workspace string, target PlanningTarget, plan SyncPlan) error {
+	storage, err := workspaceStorage(workspace)
+	if err != nil {
+		return err
+	}
+	err = target.Apply(ctx, plan)
+	if err != nil && len(syncActionErrors(err)) == 0 {
+		return err
+	}
+
+	failedApis := map[string]bool{}
+	for _, actionErr := range syncActionErrors(err) {
+		failedApis[actionErr.Action.Api] = true
+	}
+
+	syncStateMutex.Lock()
+	defer syncStateMutex.Unlock()
+
+	state, stateErr := loadSyncState(workspace)
+	if stateErr != nil {
+		return errors.Join(err, stateErr)
+	}
+	now := time.Now().UTC()
+	resources := map[string][]string{}
+	deleted := map[string]bool{}
+	for _, action := range plan.Actions {
+		if action.Kind == "api" && action.Action == SyncActionDelete {
+			deleted[action.Api] = true
+		} else if action.Action != SyncActionDelete && action.Api != "" {
+			resources[action.Api] = append(resources[action.Api], action.Name)
+		}
+	}
+
+	for apiName := range deleted {
+		if api, ok := state.Apis[apiName]; ok && !failedApis[apiName] {
+			delete(api.Targets, plan.Target)
+			if len(api.Targets) == 0 {
+				delete(state.Apis, apiName)
+			}
+		}
+	}
+
+	for apiName, apiResources := range resources {
+		// plans without hashes were computed by an older version, their APIs are compared again by the next plan
+		hash, planned := plan.Hashes[apiName]
+		if failedApis[apiName] || !planned {
+			continue
+		}
+
+		_, sources := generalApiHash(storage, apiName)
+		api, ok := state.Apis[apiName]
+		if !ok {
+			api = SyncStateApi{Name: apiName, Targets: map[string]SyncStateTarget{}}
+		}
+		api.Sources = sources
+		api.ContentHash = hash
+		api.Targets[plan.Target] = SyncStateTarget{ContentHash: hash, Resources: apiResources, LastSynced: now}
+		state.Apis[apiName] = api
+	}
+
+	if saveErr := saveSyncState(workspace, state); saveErr != nil {
+		return errors.Join(err, errors.New("could not save the sync state: "+saveErr.Error()))
+	}
+	return err
+}
+
+// SyncActionError is the error returned by a target when applying one action of a plan fails.
+type SyncActionError struct {
+	Action SyncAction
+	Err    error
+}
+
+func (e *SyncActionError) Error() string {
+	return e.Action.Action + " " + e.Action.Kind + " " + e.Action.Name + ": " + e.Err.Error()
+}
+
+func (e *SyncActionError) Unwrap() error {
+	return e.Err
+}
+
+func syncActionErrors(err error) []*SyncActionError {
+	result := []*SyncActionError{}
+	if err == nil {
+		return result
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			result = append(result, syncActionErrors(e)...)
+		}
+	} else {
+		var actionErr *SyncActionError
+		if errors.As(err, &actionErr) {
+			result = append(result, actionErr)
+		}
+	}
+	return result
+}
+
+func syncStatus(flags *SyncStatusFlags) error {
+	if flags.Target == "" {
+		flags.Target = "apihub"
+	}
+
+	storage, err := workspaceStorage(flags.Workspace)
+	if err != nil {
+		return err
+	}
+
+	state, err := loadSyncState(flags.Workspace)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	entries, _ := storage.ReadDir("general/apiproxies")
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	for name := range state.Apis {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flags.ApiName != "" && flags.ApiName != name {
+			continue
+		}
+
+		api, ok := state.Apis[name]
+		targetState, synced := api.Targets[flags.Target]
+		hash, _ := generalApiHash(storage, name)
+		if !ok || !synced {
+			fmt.Println(name + ": never synced to " + flags.Target + ".")
+		} else if _, err := storage.Stat("general/apiproxies/" + name); err != nil {
+			fmt.Println(name + ": removed from general, last synced to " + flags.Target + " at " + targetState.LastSynced.Format(time.RFC3339) + ".")
+		} else if targetState.ContentHash != hash {
+			fmt.Println(name + ": changed since last sync to " + flags.Target + " at " + targetState.LastSynced.Format(time.RFC3339) + ".")
+		} else {
+			fmt.Println(name + ": in sync, last synced to " + flags.Target + " at " + targetState.LastSynced.Format(time.RFC3339) + ".")
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
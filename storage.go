@@ -0,0 +1,426 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage keeps the files of a workspace. Names are slash separated paths relative to the workspace, like
+// general/apiproxies/petstore/petstore.json. Directories exist as long as they have files, so that object
+// stores without directories can be used too.
+type Storage interface {
+	ReadFile(name string) ([]byte, error)
+	// WriteFile replaces the file, or creates it and its directories.
+	WriteFile(name string, data []byte) error
+	// CreateFile creates the file only if it doesn't exist yet, otherwise it returns an error wrapping fs.ErrExist.
+	CreateFile(name string, data []byte) error
+	// ReadDir returns the files and directories in a directory sorted by name.
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Remove(name string) error
+	// ReadFileVersion returns the file with its version, which changes whenever the file is written.
+	ReadFileVersion(name string) ([]byte, string, error)
+	// RemoveVersion removes the file only if it still has the version, otherwise it returns an error wrapping
+	// errFileChanged, so that a file that was replaced since it was read isn't removed.
+	RemoveVersion(name string, version string) error
+	// RemoveAll removes a file or a directory with all its files, it doesn't fail if they don't exist.
+	RemoveAll(name string) error
+	// String returns where the files are stored, for messages.
+	String() string
+}
+
+var errFileChanged = errors.New("the file was changed")
+
+// storageVersion returns the version of a file in storages without versions of their own, the hash of its content.
+func storageVersion(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// storages are the backends of workspaces given as scheme://location, e.g. mem://e2e or s3://bucket/prefix.
+// Workspaces without a scheme are local directories.
+var storages = map[string]func(location string) (Storage, error){}
+
+// registerStorage adds a storage backend for the workspaces with the scheme.
+func registerStorage(scheme string, backend func(location string) (Storage, error)) {
+	storages[scheme] = backend
+}
+
+func init() {
+	registerStorage("mem", newMemoryStorage)
+}
+
+func storageSchemes() []string {
+	schemes := []string{}
+	for scheme := range storages {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// workspaceStorage returns the storage of a workspace, see workspaceRoot.
+func workspaceStorage(workspace string) (Storage, error) {
+	root := workspaceRoot(workspace)
+	scheme, location, found := strings.Cut(root, "://")
+	if !found {
+		return &localStorage{root: root}, nil
+	}
+	backend, ok := storages[scheme]
+	if !ok {
+		return nil, errors.New("unknown workspace storage " + scheme + "://, use a directory or one of " + fmt.Sprint(storageSchemes()))
+	}
+	return backend(location)
+}
+
+// storageName cleans a file name, so that "./a//b/" and "a/b" are the same file and "." or "" is the root.
+func storageName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return name
+}
+
+// walkStorage calls fn with the name of every file below dir, in name order.
+func walkStorage(storage Storage, dir string, fn func(name string) error) error {
+	entries, err := storage.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := path.Join(dir, e.Name())
+		if e.IsDir() {
+			err = walkStorage(storage, name, fn)
+		} else {
+			err = fn(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localStorage keeps the files in a local directory.
+type localStorage struct {
+	root string
+}
+
+func (s *localStorage) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(storageName(name)))
+}
+
+func (s *localStorage) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(s.path(name))
+}
+
+// WriteFile writes to a temporary file that is renamed into place, so that the file is never read half written.
+func (s *localStorage) WriteFile(name string, data []byte) error {
+	file, err := s.writeTemp(name, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(file, s.path(name)); err != nil {
+		os.Remove(file)
+		return err
+	}
+	return nil
+}
+
+// CreateFile links a complete temporary file into place, which fails if the file exists.
+func (s *localStorage) CreateFile(name string, data []byte) error {
+	file, err := s.writeTemp(name, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file)
+	return os.Link(file, s.path(name))
+}
+
+func (s *localStorage) writeTemp(name string, data []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(s.path(name)), 0755); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(filepath.Dir(s.path(name)), "."+filepath.Base(s.path(name))+".*")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func (s *localStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	// skip the temporary files of writes in progress
+	result := []fs.DirEntry{}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".") || e.IsDir() {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+func (s *localStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(s.path(name))
+}
+
+func (s *localStorage) Remove(name string) error {
+	return os.Remove(s.path(name))
+}
+
+func (s *localStorage) ReadFileVersion(name string) ([]byte, string, error) {
+	data, err := s.ReadFile(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, storageVersion(data), nil
+}
+
+// RemoveVersion renames the file to a temporary file first, which only one process can do, and checks the version
+// of what it renamed. A file that was replaced since it was read is put back, unless yet another one was created.
+func (s *localStorage) RemoveVersion(name string, version string) error {
+	moved, err := s.writeTemp(name, nil)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(moved)
+	if err := os.Rename(s.path(name), moved); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(moved)
+	if err != nil {
+		return err
+	}
+	if storageVersion(data) != version {
+		os.Link(moved, s.path(name))
+		return &fs.PathError{Op: "remove", Path: name, Err: errFileChanged}
+	}
+	return nil
+}
+
+func (s *localStorage) RemoveAll(name string) error {
+	return os.RemoveAll(s.path(name))
+}
+
+func (s *localStorage) String() string {
+	return s.root
+}
+
+// storageEntry is a file or directory of a storage without a file system, it is both a fs.DirEntry and a fs.FileInfo.
+type storageEntry struct {
+	name    string
+	dir     bool
+	size    int64
+	modTime time.Time
+}
+
+func (e storageEntry) Name() string               { return e.name }
+func (e storageEntry) IsDir() bool                { return e.dir }
+func (e storageEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e storageEntry) Size() int64                { return e.size }
+func (e storageEntry) ModTime() time.Time         { return e.modTime }
+func (e storageEntry) Sys() any                   { return nil }
+
+func (e storageEntry) Type() fs.FileMode {
+	return e.Mode().Type()
+}
+
+func (e storageEntry) Mode() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// storageEntries returns the entries of a directory from the names of all files below it, relative to it.
+func storageEntries(files map[string]storageEntry) []fs.DirEntry {
+	children := map[string]storageEntry{}
+	for name, file := range files {
+		child, _, nested := strings.Cut(name, "/")
+		if nested {
+			children[child] = storageEntry{name: child, dir: true}
+		} else if _, ok := children[child]; !ok {
+			file.name = child
+			children[child] = file
+		}
+	}
+
+	entries := []fs.DirEntry{}
+	for _, name := range sortedKeys(children) {
+		entries = append(entries, children[name])
+	}
+	return entries
+}
+
+// memoryStorage keeps the files in memory, workspaces with the same mem:// name share them within the process.
+type memoryStorage struct {
+	name  string
+	mutex sync.Mutex
+	files map[string]storageEntry
+	data  map[string][]byte
+}
+
+var memoryStorages = map[string]*memoryStorage{}
+var memoryStoragesMutex sync.Mutex
+
+func newMemoryStorage(location string) (Storage, error) {
+	memoryStoragesMutex.Lock()
+	defer memoryStoragesMutex.Unlock()
+
+	storage, ok := memoryStorages[location]
+	if !ok {
+		storage = &memoryStorage{name: location, files: map[string]storageEntry{}, data: map[string][]byte{}}
+		memoryStorages[location] = storage
+	}
+	return storage, nil
+}
+
+func (s *memoryStorage) ReadFile(name string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, ok := s.data[storageName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (s *memoryStorage) WriteFile(name string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.write(storageName(name), data)
+	return nil
+}
+
+func (s *memoryStorage) CreateFile(name string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.data[storageName(name)]; ok {
+		return &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	s.write(storageName(name), data)
+	return nil
+}
+
+func (s *memoryStorage) write(name string, data []byte) {
+	s.files[name] = storageEntry{name: path.Base(name), size: int64(len(data)), modTime: time.Now().UTC()}
+	s.data[name] = append([]byte{}, data...)
+}
+
+// below returns the files below a directory, by their names relative to it.
+func (s *memoryStorage) below(dir string) map[string]storageEntry {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	files := map[string]storageEntry{}
+	for name, file := range s.files {
+		if strings.HasPrefix(name, prefix) {
+			files[strings.TrimPrefix(name, prefix)] = file
+		}
+	}
+	return files
+}
+
+func (s *memoryStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files := s.below(storageName(name))
+	if len(files) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return storageEntries(files), nil
+}
+
+func (s *memoryStorage) Stat(name string) (fs.FileInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if file, ok := s.files[storageName(name)]; ok {
+		return file, nil
+	}
+	if len(s.below(storageName(name))) > 0 {
+		return storageEntry{name: path.Base(storageName(name)), dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (s *memoryStorage) Remove(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.files[storageName(name)]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(s.files, storageName(name))
+	delete(s.data, storageName(name))
+	return nil
+}
+
+func (s *memoryStorage) ReadFileVersion(name string) ([]byte, string, error) {
+	data, err := s.ReadFile(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, storageVersion(data), nil
+}
+
+func (s *memoryStorage) RemoveVersion(name string, version string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, ok := s.data[storageName(name)]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if storageVersion(data) != version {
+		return &fs.PathError{Op: "remove", Path: name, Err: errFileChanged}
+	}
+	delete(s.files, storageName(name))
+	delete(s.data, storageName(name))
+	return nil
+}
+
+func (s *memoryStorage) RemoveAll(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	name = storageName(name)
+	for file := range s.files {
+		if file == name || name == "" || strings.HasPrefix(file, name+"/") {
+			delete(s.files, file)
+			delete(s.data, file)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) String() string {
+	return "mem://" + s.name
+}
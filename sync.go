@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type SyncFlags struct {
+	Target    string `name:"target" description:"The platform to sync the general APIs to, default is apihub."`
+	ApiName   string `name:"api" description:"A specific general API."`
+	File      string `name:"file" description:"The file to write the plan to, or to read the plan from when applying."`
+	Prune     bool   `name:"prune" description:"If resources created by apimsync whose source no longer exists should be removed."`
+	Protected string `name:"protected" description:"Comma-separated API and deployment names that are never removed by pruning."`
+	Refresh   bool   `name:"refresh" description:"Compare all APIs with the target, also the ones unchanged since the last sync."`
+	Workspace string `name:"workspace" description:"The workspace the files are in, a directory or e.g. s3://bucket/prefix, default is APIMSYNC_WORKSPACE or src/main."`
+}
+
+type SyncPlan struct {
+	Target  string       `json:"target" example:"apihub" doc:"The platform the plan was computed against."`
+	Actions []SyncAction `json:"actions" doc:"The actions needed to bring the target in sync with the general APIs."`
+	// Hashes are recorded in the sync state when the plan is applied, so that a plan applied later records what was planned.
+	Hashes map[string]string `json:"hashes,omitempty" doc:"The content hash of each general API when the plan was computed."`
+}
+
+type SyncAction struct {
+	Action   string          `json:"action" enum:"create,update,delete,noop" doc:"What will be done to the resource."`
+	Kind     string          `json:"kind" example:"api" doc:"The kind of resource, for example api, version, deployment or spec."`
+	Api      string          `json:"api" example:"petstore" doc:"The general API the resource belongs to."`
+	Name     string          `json:"name" doc:"The full resource name on the target platform."`
+	Fields   []string        `json:"fields,omitempty" doc:"The fields that will be updated."`
+	Resource json.RawMessage `json:"resource,omitempty" doc:"The resource that will be created or updated."`
+}
+
+const (
+	SyncActionCreate = "create"
+	SyncActionUpdate = "update"
+	SyncActionDelete = "delete"
+	SyncActionNoop   = "noop"
+)
+
+func syncPlan(flags *SyncFlags) error {
+	target, err := newPlanningTarget(flags)
+	if err != nil {
+		return err
+	}
+
+	plan, err := target.Plan(context.Background())
+	if err != nil {
+		return errors.New("could not compute sync plan: " + err.Error())
+	}
+
+	printSyncPlan(plan)
+
+	if flags.File != "" {
+		bytes, _ := json.MarshalIndent(plan, "", "  ")
+		if err := os.WriteFile(flags.File, bytes, 0644); err != nil {
+			return errors.New("could not write plan file " + flags.File + ": " + err.Error())
+		}
+		fmt.Println("Plan written to " + flags.File + ".")
+	}
+
+	return nil
+}
+
+func syncApply(flags *SyncFlags) error {
+	target, err := newPlanningTarget(flags)
+	if err != nil {
+		return err
+	}
+
+	var plan SyncPlan
+	if flags.File != "" {
+		byteValue, err := os.ReadFile(flags.File)
+		if err != nil {
+			return errors.New("could not read plan file " + flags.File + ": " + err.Error())
+		}
+		if err := json.Unmarshal(byteValue, &plan); err != nil {
+			return errors.New("could not parse plan file " + flags.File + ": " + err.Error())
+		}
+		if plan.Target != flags.Target {
+			return errors.New("plan file " + flags.File + " was computed for " + plan.Target + ", cannot apply it to " + flags.Target)
+		}
+	} else {
+		plan, err = target.Plan(context.Background())
+		if err != nil {
+			return errors.New("could not compute sync plan: " + err.Error())
+		}
+	}
+
+	printSyncPlan(plan)
+	return applySyncPlan(context.Background(), flags.Workspace, target, plan)
+}
+
+func newPlanningTarget(flags *SyncFlags) (PlanningTarget, error) {
+	if flags.Target == "" {
+		flags.Target = "apihub"
+	}
+
+	options := PlatformOptions{ApiName: flags.ApiName, Prune: flags.Prune, Refresh: flags.Refresh, Workspace: flags.Workspace}
+	if flags.Protected != "" {
+		options.Protected = strings.Split(flags.Protected, ",")
+	}
+
+	target, ok := newTargetPlatform(flags.Target, options)
+	if !ok {
+		return nil, fmt.Errorf("unknown target platform %s", flags.Target)
+	}
+
+	planningTarget, ok := target.(PlanningTarget)
+	if !ok {
+		return nil, fmt.Errorf("target platform %s does not support sync plans", flags.Target)
+	}
+
+	return planningTarget, nil
+}
+
+func printSyncPlan(plan SyncPlan) {
+	fmt.Println("Sync plan for " + plan.Target + ":")
+	for _, action := range plan.Actions {
+		switch action.Action {
+		case SyncActionCreate:
+			fmt.Println("  + create " + action.Kind + " " + action.Name)
+		case SyncActionUpdate:
+			fmt.Println("  ~ update " + action.Kind + " " + action.Name + " " + fmt.Sprint(action.Fields))
+		case SyncActionDelete:
+			fmt.Println("  - delete " + action.Kind + " " + action.Name)
+		default:
+			fmt.Println("    no-op  " + action.Kind + " " + action.Name)
+		}
+	}
+	fmt.Println(plan.Summary())
+}
+
+// Summary returns a one line description of the changes in the plan.
+func (plan SyncPlan) Summary() string {
+	counts := map[string]int{}
+	for _, action := range plan.Actions {
+		counts[action.Action]++
+	}
+
+	return "Plan: " + strconv.Itoa(counts[SyncActionCreate]) + " to create, " + strconv.Itoa(counts[SyncActionUpdate]) + " to update, " + strconv.Itoa(counts[SyncActionDelete]) + " to delete, " + strconv.Itoa(counts[SyncActionNoop]) + " unchanged."
+}
+
+// Changes returns the number of actions in the plan that change the target.
+func (plan SyncPlan) Changes() int {
+	changes := 0
+	for _, action := range plan.Actions {
+		if action.Action != SyncActionNoop {
+			changes++
+		}
+	}
+	return changes
+}
+
+func newSyncAction(action string, kind string, api string, name string, fields []string, resource any) SyncAction {
+	result := SyncAction{Action: action, Kind: kind, Api: api, Name: name, Fields: fields}
+	if resource != nil && action != SyncActionDelete && action != SyncActionNoop {
+		result.Resource, _ = json.Marshal(resource)
+	}
+	return result
+}
+
+// changedFields compares the given top-level JSON fields of two resources, ignoring empty values.
+func changedFields(desired any, current any, fields []string) []string {
+	var desiredMap, currentMap map[string]any
+	desiredBytes, _ := json.Marshal(desired)
+	currentBytes, _ := json.Marshal(current)
+	json.Unmarshal(desiredBytes, &desiredMap)
+	json.Unmarshal(currentBytes, &currentMap)
+
+	changed := []string{}
+	for _, field := range fields {
+		if !reflect.DeepEqual(normalizeJsonValue(desiredMap[field]), normalizeJsonValue(currentMap[field])) {
+			changed = append(changed, field)
+		}
+	}
+	return changed
+}
+
+// normalizeJsonValue drops empty strings, lists and objects so that omitted and empty fields compare equal.
+func normalizeJsonValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+	case []any:
+		if len(v) == 0 {
+			return nil
+		}
+		result := []any{}
+		for _, item := range v {
+			result = append(result, normalizeJsonValue(item))
+		}
+		return result
+	case map[string]any:
+		result := map[string]any{}
+		for key, item := range v {
+			if normalized := normalizeJsonValue(item); normalized != nil {
+				result[key] = normalized
+			}
+		}
+		if len(result) == 0 {
+			return nil
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncErrors(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.runSources(PlatformOptions{})
+	dir := t.TempDir()
+	otherTarget := filepath.Join(dir, "other.json")
+	os.WriteFile(otherTarget, []byte(`{"target": "apigee", "actions": []}`), 0644)
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"target": `), 0644)
+
+	tests := []struct {
+		name    string
+		command func(flags *SyncFlags) error
+		flags   SyncFlags
+		err     string
+	}{
+		{"plan for an unknown target", syncPlan, SyncFlags{Target: "unknown"}, "unknown target platform unknown"},
+		{"plan file that can't be written", syncPlan, SyncFlags{File: filepath.Join(dir, "missing", "plan.json")}, "could not write plan file"},
+		{"apply to an unknown target", syncApply, SyncFlags{Target: "unknown"}, "unknown target platform unknown"},
+		{"apply of a missing plan file", syncApply, SyncFlags{File: filepath.Join(dir, "missing.json")}, "could not read plan file"},
+		{"apply of an invalid plan file", syncApply, SyncFlags{File: invalid}, "could not parse plan file"},
+		{"apply of a plan for another target", syncApply, SyncFlags{File: otherTarget}, "was computed for apigee"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.flags.Workspace = s.workspace
+			if err := test.command(&test.flags); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
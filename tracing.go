@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span is a span of the OpenTelemetry tracer. Spans are only recorded if an OTLP endpoint is configured, otherwise
+// all span methods do nothing.
+type Span struct {
+	span trace.Span
+}
+
+// tracerProvider is nil if tracing is off.
+var tracerProvider *sdktrace.TracerProvider
+
+// initTracing turns on tracing if an OTLP endpoint is configured with the standard OpenTelemetry env variables:
+//
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT  the URL to send spans to, e.g. http://localhost:4318/v1/traces
+//	OTEL_EXPORTER_OTLP_ENDPOINT         the base URL of the collector, /v1/traces is appended for http/protobuf
+//	OTEL_EXPORTER_OTLP_PROTOCOL         http/protobuf (the default) or grpc
+//	OTEL_EXPORTER_OTLP_HEADERS          headers to send, e.g. "api-key=secret,x-tenant=apis"
+//	OTEL_SERVICE_NAME                   the service name of the spans, by default apimsync
+//	OTEL_SDK_DISABLED                   set to true to turn tracing off
+//
+// It returns an error if the protocol isn't supported, so that spans aren't silently dropped.
+func initTracing() error {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" || os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		return nil
+	}
+
+	ctx := context.Background()
+	exporter, err := newTraceExporter(ctx)
+	if err != nil {
+		return err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default service name
+	serviceResource, err := resource.New(ctx, resource.WithAttributes(attribute.String("service.name", "apimsync")), resource.WithFromEnv())
+	if err != nil {
+		return errors.New("could not create the tracing resource: " + err.Error())
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(serviceResource))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return nil
+}
+
+// newTraceExporter creates the OTLP exporter of the configured protocol, which reads the endpoint and headers
+// from the env itself.
+func newTraceExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+
+	switch protocol {
+	case "", "http/protobuf":
+		return otlptracehttp.New(ctx)
+	case "grpc":
+		return otlptracegrpc.New(ctx)
+	}
+	return nil, errors.New("OTLP protocol " + protocol + " is not supported, use http/protobuf or grpc")
+}
+
+// shutdownTracing exports the spans that weren't exported yet, before the process exits.
+func shutdownTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		fmt.Println("Could not export the remaining spans: " + err.Error())
+	}
+}
+
+// startSpan starts a span as child of the span in ctx, with attributes given as key and value pairs.
+func startSpan(ctx context.Context, name string, attributes ...any) (context.Context, *Span) {
+	keyValues := []attribute.KeyValue{}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		keyValues = append(keyValues, newAttribute(fmt.Sprint(attributes[i]), attributes[i+1]))
+	}
+	ctx, span := otel.Tracer("apimsync").Start(ctx, name, trace.WithAttributes(keyValues...))
+	return ctx, &Span{span: span}
+}
+
+// traceparentMiddleware continues the trace of a caller that sent a traceparent header, so that jobs it started
+// are traced under its span.
+func traceparentMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Span) setAttribute(key string, value any) {
+	s.span.SetAttributes(newAttribute(key, value))
+}
+
+// TraceId returns the hex trace ID of the span, or an empty string if the span isn't recorded.
+func (s *Span) TraceId() string {
+	if !s.span.IsRecording() {
+		return ""
+	}
+	return s.span.SpanContext().TraceID().String()
+}
+
+// end finishes the span, with an error status if err isn't nil.
+func (s *Span) end(err error) {
+	if err != nil {
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// startStage starts the span of a pipeline stage on a platform.
+func startStage(ctx context.Context, stage string, platform string) (context.Context, *Span) {
+	return startSpan(ctx, platform+" "+stage, "apimsync.stage", stage, "apimsync.platform", platform)
+}
+
+// endStage finishes the span of a stage, with the number of APIs that succeeded and failed.
+func (s *Span) endStage(result StageResult, err error) {
+	s.setAttribute("apimsync.apis.succeeded", len(result.Apis))
+	s.setAttribute("apimsync.apis.failed", len(result.Failed))
+	s.end(err)
+}
+
+// startApi starts the span of one API in a stage, as child of the stage span in ctx.
+func (r *StageResult) startApi(ctx context.Context, api string) (context.Context, *Span) {
+	return startSpan(ctx, r.Platform+" "+r.Stage+" api", "apimsync.stage", r.Stage, "apimsync.platform", r.Platform, "apimsync.api", api)
+}
+
+// clientSpanName names the spans of the calls to the platforms, e.g. GET apihub.
+func clientSpanName(operation string, req *http.Request) string {
+	return req.Method + " " + upstreamPlatform(req.URL.Host)
+}
+
+func newAttribute(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case int:
+		return attribute.Int(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case string:
+		return attribute.String(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
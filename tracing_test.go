@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var testSpans = tracetest.NewInMemoryExporter()
+var testTracing sync.Once
+
+// withTracer records the spans in memory, and returns the spans that were finished since the test started.
+// The global tracer provider can only be set once, so it stays on for the following tests.
+func withTracer(t *testing.T) func() map[string]tracetest.SpanStub {
+	testTracing.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(testSpans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	testSpans.Reset()
+	return func() map[string]tracetest.SpanStub {
+		spans := map[string]tracetest.SpanStub{}
+		for _, span := range testSpans.GetSpans() {
+			spans[span.Name] = span
+		}
+		return spans
+	}
+}
+
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, attribute := range span.Attributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+	return attributes
+}
+
+func TestTracerSpans(t *testing.T) {
+	spans := withTracer(t)
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	ctx, stage := startStage(context.Background(), "export", "azure")
+	stageTraceId := stage.TraceId()
+	result := StageResult{Stage: "export", Platform: "azure", Apis: []string{"petstore"}}
+	apiCtx, api := result.startApi(ctx, "petstore")
+	req, _ := http.NewRequestWithContext(apiCtx, http.MethodGet, server.URL+"/apis", nil)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	api.end(errors.New("petstore not found"))
+	stage.endStage(result, nil)
+
+	recorded := spans()
+	stageSpan, apiSpan, clientSpan := recorded["azure export"], recorded["azure export api"], recorded["GET "+upstreamPlatform(req.URL.Host)]
+	if len(recorded) != 3 {
+		t.Fatalf("expected 3 spans, got %v", recorded)
+	}
+	traceId := stageSpan.SpanContext.TraceID()
+	if apiSpan.SpanContext.TraceID() != traceId || clientSpan.SpanContext.TraceID() != traceId {
+		t.Errorf("expected the spans in one trace")
+	}
+	if stageSpan.Parent.IsValid() || apiSpan.Parent.SpanID() != stageSpan.SpanContext.SpanID() || clientSpan.Parent.SpanID() != apiSpan.SpanContext.SpanID() {
+		t.Errorf("expected the client span under the API span under the stage span")
+	}
+	if expected := "00-" + traceId.String() + "-" + clientSpan.SpanContext.SpanID().String() + "-01"; traceparent != expected {
+		t.Errorf("expected the traceparent %s, got %s", expected, traceparent)
+	}
+	if stageTraceId != traceId.String() {
+		t.Errorf("expected the trace ID %s, got %s", traceId, stageTraceId)
+	}
+
+	if clientSpan.Status.Code != codes.Error || apiSpan.Status.Code != codes.Error || apiSpan.Status.Description != "petstore not found" {
+		t.Errorf("expected failed client and API spans, got %v and %v", clientSpan.Status, apiSpan.Status)
+	}
+	if stageSpan.Status.Code != codes.Unset {
+		t.Errorf("expected a stage span without error, got %v", stageSpan.Status)
+	}
+	attributes := spanAttributes(stageSpan)
+	if attributes["apimsync.apis.succeeded"].AsInt64() != 1 || attributes["apimsync.platform"].AsString() != "azure" {
+		t.Errorf("expected the stage attributes, got %v", attributes)
+	}
+	if api := spanAttributes(apiSpan)["apimsync.api"]; api.AsString() != "petstore" {
+		t.Errorf("expected the API attribute, got %v", api)
+	}
+}
+
+func TestTraceparentMiddleware(t *testing.T) {
+	spans := withTracer(t)
+	tests := []struct {
+		traceparent string
+		traceId     string
+		parentId    string
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"", "", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4zzz-00f067aa0ba902b7-01", "", ""},
+	}
+	for _, test := range tests {
+		testSpans.Reset()
+		var traceId string
+		handler := traceparentMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, span := startSpan(r.Context(), "test")
+			traceId = span.TraceId()
+			span.end(nil)
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/jobs", nil)
+		req.Header.Set("traceparent", test.traceparent)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		span := spans()["test"]
+		if test.traceId == "" {
+			// a new trace is started if the header is missing or invalid
+			if span.Parent.IsValid() || traceId == "" || traceId == "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("traceparent %q: expected a new trace, got %s", test.traceparent, traceId)
+			}
+			continue
+		}
+		if traceId != test.traceId || span.Parent.SpanID().String() != test.parentId || !span.Parent.IsRemote() {
+			t.Errorf("traceparent %q: expected the span under the remote parent, got trace %s and parent %s", test.traceparent, traceId, span.Parent.SpanID())
+		}
+	}
+}
+
+func TestNewAttribute(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected attribute.Value
+	}{
+		{3, attribute.IntValue(3)},
+		{true, attribute.BoolValue(true)},
+		{"azure", attribute.StringValue("azure")},
+		{1.5, attribute.StringValue("1.5")},
+	}
+	for _, test := range tests {
+		if attribute := newAttribute("key", test.value); attribute.Value != test.expected {
+			t.Errorf("newAttribute(%v): expected %v, got %v", test.value, test.expected.Emit(), attribute.Value.Emit())
+		}
+	}
+}
+
+func TestInitTracing(t *testing.T) {
+	tests := []struct {
+		env map[string]string
+		err string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318", "OTEL_SDK_DISABLED": "true"}, ""},
+		{map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318", "OTEL_EXPORTER_OTLP_PROTOCOL": "http/json"}, "OTLP protocol http/json is not supported, use http/protobuf or grpc"},
+		{map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://localhost:4318/v1/traces", "OTEL_EXPORTER_OTLP_PROTOCOL": "grpc", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL": "zipkin"}, "OTLP protocol zipkin is not supported, use http/protobuf or grpc"},
+	}
+	for _, test := range tests {
+		for _, name := range []string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_PROTOCOL", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_SDK_DISABLED"} {
+			t.Setenv(name, test.env[name])
+		}
+		err := initTracing()
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("%v: expected error %q, got %v", test.env, test.err, err)
+		}
+		if tracerProvider != nil {
+			t.Errorf("%v: expected tracing to be off", test.env)
+		}
+	}
+}
+
+func TestNewTraceExporter(t *testing.T) {
+	for _, protocol := range []string{"", "http/protobuf", "grpc"} {
+		t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", protocol)
+		t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+		exporter, err := newTraceExporter(context.Background())
+		if err != nil {
+			t.Errorf("protocol %q: %v", protocol, err)
+			continue
+		}
+		exporter.Shutdown(context.Background())
+	}
+}
@@ -2,88 +2,235 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"strconv"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/danielgtaylor/huma/v2/humacli"
+	"github.com/danielgtaylor/huma/v2/sse"
 	"github.com/go-chi/chi/v5"
 
 	_ "github.com/danielgtaylor/huma/v2/formats/cbor"
 )
 
 type WebServerFlags struct {
-	Port int `name:"port" description:"The port to listen on." help:"The port to listen on." default:"8080"`
+	Port         int    `name:"port" description:"The port to listen on." help:"The port to listen on." default:"8080"`
+	Schedules    string `name:"schedules" description:"A JSON file with cron schedules of syncs to run, or APIMSYNC_SCHEDULES_FILE." help:"A JSON file with cron schedules of syncs to run."`
+	Workspace    string `name:"workspace" description:"The workspace the files are in, a directory or e.g. s3://bucket/prefix, default is APIMSYNC_WORKSPACE or src/main." help:"The workspace the files are in, a directory or e.g. s3://bucket/prefix."`
+	JobWorkspace string `name:"jobworkspace" description:"Where syncs run, shared in the workspace or temp in a new temporary workspace each, or APIMSYNC_JOB_WORKSPACE." help:"Where syncs run, shared or temp."`
+	NoAuth       bool   `name:"noauth" description:"Run without authentication, e.g. locally, or APIMSYNC_AUTH=none." help:"Run without authentication."`
+}
+
+// SourcePlatformName is the name of a registered source platform, the enum is taken from the registry.
+type SourcePlatformName string
+
+func (SourcePlatformName) Schema(r huma.Registry) *huma.Schema {
+	return &huma.Schema{Type: huma.TypeString, Enum: platformEnum(sourcePlatformNames())}
+}
+
+// TargetPlatformName is the name of a registered target platform, the enum is taken from the registry.
+type TargetPlatformName string
+
+func (TargetPlatformName) Schema(r huma.Registry) *huma.Schema {
+	return &huma.Schema{Type: huma.TypeString, Enum: platformEnum(targetPlatformNames())}
+}
+
+func platformEnum(names []string) []any {
+	enum := []any{}
+	for _, name := range names {
+		enum = append(enum, name)
+	}
+	return enum
 }
 
 type ApimStatus struct {
+	Body map[string]PlatformStatus
+}
+
+type ApimOfframpInput struct {
 	Body struct {
-		ApigeeStatus PlatformStatus `json:"apigee"`
-		ApiHubStatus PlatformStatus `json:"apihub"`
-		AzureStatus  PlatformStatus `json:"azure"`
-		AwsStatus    PlatformStatus `json:"aws"`
+		Offramp SourcePlatformName `json:"offramp" doc:"The APIM platform to offramp the APIs from."`
+		OnlyNew bool               `json:"onlyNew" doc:"Default is false, only offramp new APIs. Set to false to offramp all APIs."`
+		Prune   bool               `json:"prune,omitempty" doc:"Remove offramped APIs that no longer exist in the APIM platform."`
 	}
 }
 
-type ApimOfframpInput struct {
+type ApimOnrampInput struct {
 	Body struct {
-		Offramp string `json:"offramp" enum:"azure,aws" doc:"The APIM platform to offramp the APIs from."`
-		OnlyNew bool   `json:"onlyNew" doc:"Default is false, only offramp new APIs. Set to false to offramp all APIs."`
+		Onramp TargetPlatformName `json:"onramp" doc:"The API platform to onramp the APIs to."`
 	}
 }
 
-type ApimOfframpOutput struct {
+type ApimSyncInput struct {
 	Body struct {
-		Result  bool     `json:"result" example:"true" doc:"The result of the offramp operation."`
-		Apis    []string `json:"apis" example:"[\"api1\", \"api2\"]" doc:"The names of the APIs that were offramped."`
-		Message string   `json:"message" example:"Offramp successful!" doc:"The result of the offramp operation."`
+		Offramp SourcePlatformName `json:"offramp" doc:"The APIM platform to offramp the APIs from."`
+		Onramp  TargetPlatformName `json:"onramp" doc:"The APIM platform to onramp the APIs to."`
+		Prune   bool               `json:"prune,omitempty" doc:"Remove APIs created by apimsync whose source no longer exists."`
 	}
 }
 
-type ApimOnrampInput struct {
+// ApimJobOutput is returned when a job is started, the job runs in the background and can be polled at Location.
+type ApimJobOutput struct {
+	Location string `header:"Location" doc:"The URL to poll the job status at."`
+	Body     Job
+}
+
+type ApimJobInput struct {
+	Id string `path:"id" example:"3f2a9c1b7d4e8f60" doc:"The job ID."`
+}
+
+type ApimJobStatusOutput struct {
+	Body Job
+}
+
+type ApimJobsInput struct {
+	Limit int `query:"limit" default:"20" minimum:"1" maximum:"100" doc:"The maximum number of jobs to return."`
+}
+
+type ApimJobsOutput struct {
 	Body struct {
-		Onramp string `json:"onramp" enum:"apihub" doc:"The API platform to onramp the APIs to."`
+		Jobs []Job `json:"jobs" doc:"The most recent jobs, newest first."`
 	}
 }
 
-type ApimOnrampOutput struct {
+type ApimPlanInput struct {
 	Body struct {
-		Result  bool   `json:"result" example:"true" doc:"The result of the onramp operation."`
-		Message string `json:"message" example:"Onramp successful!" doc:"The result of the onramp operation."`
+		Offramp SourcePlatformName `json:"offramp,omitempty" doc:"An optional APIM platform to offramp the APIs from before planning."`
+		Onramp  TargetPlatformName `json:"onramp" doc:"The API platform to plan the sync against."`
+		Prune   bool               `json:"prune,omitempty" doc:"Plan to remove APIs created by apimsync whose source no longer exists."`
 	}
 }
 
-type ApimSyncInput struct {
+type ApimPlanOutput struct {
 	Body struct {
-		Offramp string `json:"offramp" enum:"azure,aws" doc:"The APIM platform to offramp the APIs from."`
-		Onramp  string `json:"onramp" enum:"apihub" doc:"The APIM platform to onramp the APIs to."`
+		SyncPlan
+		Failed []ApiFailure `json:"failed,omitempty" doc:"The APIs that could not be exported or offramped, so they are not in the plan."`
 	}
 }
 
-type ApimSyncOutput struct {
+type ApimStateOutput struct {
 	Body struct {
-		Result  bool   `json:"result" example:"true" doc:"The result of the sync operation."`
-		Message string `json:"message" example:"Sync successful!" doc:"The result of the sync operation."`
+		Apis []SyncStateApi `json:"apis" doc:"The sync state of all general APIs that were synced."`
 	}
 }
 
+type ApimStateApiInput struct {
+	Name string `path:"name" example:"petstore" doc:"The general API name."`
+}
+
+type ApimStateApiOutput struct {
+	Body SyncStateApi
+}
+
+type CatalogApisInput struct {
+	Platform string `query:"platform" example:"azure" doc:"Only list APIs deployed to this platform, by name or platform ID."`
+	Offset   int    `query:"offset" default:"0" minimum:"0" doc:"The number of APIs to skip."`
+	Limit    int    `query:"limit" default:"50" minimum:"1" maximum:"500" doc:"The maximum number of APIs to return."`
+}
+
+type CatalogApisOutput struct {
+	Body struct {
+		Apis  []CatalogApi `json:"apis" doc:"The general APIs, sorted by name."`
+		Total int          `json:"total" doc:"The number of APIs that match, across all pages."`
+	}
+}
+
+type CatalogApiInput struct {
+	Name string `path:"name" example:"petstore" doc:"The general API name."`
+}
+
+type CatalogApiOutput struct {
+	Body CatalogApi
+}
+
+type CatalogSpecInput struct {
+	Name       string `path:"name" example:"petstore" doc:"The general API name."`
+	Platform   string `path:"platform" example:"azure" doc:"The platform of the spec, by name or platform ID."`
+	Deployment string `query:"deployment" example:"petstore-v1-azure" doc:"The platform API, if the API has several on the platform."`
+}
+
+type CatalogSpecOutput struct {
+	ContentType        string `header:"Content-Type"`
+	ContentDisposition string `header:"Content-Disposition"`
+	Body               []byte
+}
+
+type SearchInput struct {
+	Query    string `query:"q" example:"customers" doc:"The words to search for in the API fields and the paths, operations and schemas of their specs. Without a query all APIs match."`
+	Platform string `query:"platform" example:"azure" doc:"Only APIs deployed to this platform, by name or platform ID."`
+	Owner    string `query:"owner" doc:"Only APIs of this owner, by name or email."`
+	Version  string `query:"version" example:"v1" doc:"Only APIs with this version."`
+	Offset   int    `query:"offset" default:"0" minimum:"0" doc:"The number of hits to skip."`
+	Limit    int    `query:"limit" default:"50" minimum:"1" maximum:"500" doc:"The maximum number of hits to return."`
+}
+
+type SearchOutput struct {
+	Body SearchResult
+}
+
+type ApimSchedulesOutput struct {
+	Body struct {
+		Schedules []Schedule `json:"schedules" doc:"The schedules of the web server, by name."`
+	}
+}
+
+type ApimScheduleInput struct {
+	Name string `path:"name" example:"azure-to-apihub" doc:"The schedule name."`
+}
+
+type ApimScheduleOutput struct {
+	Body Schedule
+}
+
 func webServerStart(flags *WebServerFlags) error {
+	schedulesFile := flags.Schedules
+	if schedulesFile == "" {
+		schedulesFile = os.Getenv("APIMSYNC_SCHEDULES_FILE")
+	}
+	// the syncs of the profile, and the schedules of the file
+	configs := append([]ScheduleConfig{}, profile.Syncs...)
+	if schedulesFile != "" {
+		fileConfigs, err := loadScheduleConfigs(schedulesFile)
+		if err != nil {
+			return errors.New("could not load schedules: " + err.Error())
+		}
+		configs = append(configs, fileConfigs...)
+	}
+	loadedSchedules, err := newSchedules(configs)
+	if err != nil {
+		return errors.New("could not load schedules: " + err.Error())
+	}
+
+	webServerWorkspace = workspaceRoot(flags.Workspace)
+	webServerJobWorkspace = flags.JobWorkspace
+	if webServerJobWorkspace == "" {
+		webServerJobWorkspace = os.Getenv("APIMSYNC_JOB_WORKSPACE")
+	}
+	if webServerJobWorkspace == "" {
+		webServerJobWorkspace = JobWorkspaceShared
+	}
+	if err := checkJobWorkspace(webServerJobWorkspace); err != nil {
+		return err
+	}
+	if _, err := webServerStorage(); err != nil {
+		return err
+	}
+	auth, err := newWebServerAuth(flags.NoAuth)
+	if err != nil {
+		return err
+	}
+
 	// Create a CLI app which takes a port option.
 	cli := humacli.New(func(hooks humacli.Hooks, options *WebServerFlags) {
-		// Create a new router & API
-		router := chi.NewMux()
-		api := humachi.New(router, huma.DefaultConfig("Apimsync API", "0.1.6"))
-
-		// Add the operation handler to the API.
-		huma.Get(api, "/v1/apim/status", apimStatus)
-		huma.Post(api, "/v1/apim/offramp", apimOfframp)
-		huma.Post(api, "/v1/apim/onramp", apimOnramp)
-		huma.Post(api, "/v1/apim/sync", apimSync)
+		router := newWebServerRouter(auth)
 
 		hooks.OnStart(func() {
+			startScheduler(context.Background(), loadedSchedules)
+			startStatusMetrics(context.Background(), time.Duration(envInt("APIMSYNC_METRICS_STATUS_INTERVAL", 300))*time.Second)
 			http.ListenAndServe(fmt.Sprintf(":%d", options.Port), router)
 		})
 	})
@@ -92,85 +239,355 @@ func webServerStart(flags *WebServerFlags) error {
 	return nil
 }
 
+// newWebServerRouter creates the router with all operations of the web server, authenticated with auth.
+func newWebServerRouter(auth *WebServerAuth) http.Handler {
+	// Create a new router & API
+	router := chi.NewMux()
+	router.Use(traceparentMiddleware)
+	api := humachi.New(router, huma.DefaultConfig("Apimsync API", "0.1.6"))
+
+	auth.register(api)
+
+	// Add the operation handler to the API.
+	huma.Get(api, "/v1/apim/status", apimStatus, auth.require(ScopeRead))
+	huma.Post(api, "/v1/apim/offramp", apimOfframp, acceptedStatus, auth.require(ScopeSync))
+	huma.Post(api, "/v1/apim/onramp", apimOnramp, acceptedStatus, auth.require(ScopeSync))
+	huma.Post(api, "/v1/apim/sync", apimSync, acceptedStatus, auth.require(ScopeSync))
+	huma.Get(api, "/v1/apim/jobs", apimJobs, auth.require(ScopeRead))
+	huma.Get(api, "/v1/apim/jobs/{id}", apimJob, auth.require(ScopeRead))
+	watchJobOperation := huma.Operation{
+		OperationID: "watch-job",
+		Method:      http.MethodGet,
+		Path:        "/v1/apim/jobs/{id}/events",
+		Summary:     "Watch job events",
+		Description: "Streams the events of a job as Server-Sent Events, starting with the events that already happened, until the job finishes.",
+	}
+	auth.require(ScopeRead)(&watchJobOperation)
+	sse.Register(api, watchJobOperation, jobEventTypes, apimJobEvents)
+	// planning can export and offramp from a source, so it needs the sync scope
+	huma.Post(api, "/v1/apim/plan", apimPlan, auth.require(ScopeSync))
+	huma.Get(api, "/v1/apim/state", apimState, auth.require(ScopeRead))
+	huma.Get(api, "/v1/apim/state/{name}", apimStateApi, auth.require(ScopeRead))
+	huma.Get(api, "/v1/apis", catalogApis, auth.require(ScopeRead))
+	huma.Get(api, "/v1/apis/{name}", catalogApi, auth.require(ScopeRead))
+	huma.Get(api, "/v1/apis/{name}/specs/{platform}", catalogSpec, auth.require(ScopeRead))
+	huma.Get(api, "/v1/search", searchApis, auth.require(ScopeRead))
+	huma.Get(api, "/v1/apim/schedules", apimSchedules, auth.require(ScopeRead))
+	huma.Post(api, "/v1/apim/schedules/{name}/pause", apimSchedulePause, auth.require(ScopeSync))
+	huma.Post(api, "/v1/apim/schedules/{name}/resume", apimScheduleResume, auth.require(ScopeSync))
+	huma.Post(api, "/v1/apim/schedules/{name}/trigger", apimScheduleTrigger, acceptedStatus, auth.require(ScopeSync))
+
+	// the Prometheus metrics, not in the OpenAPI doc, but with the read scope since they list the platforms and their errors
+	metricsOperation := huma.Operation{
+		OperationID: "get-metrics",
+		Method:      http.MethodGet,
+		Path:        "/metrics",
+		Hidden:      true,
+	}
+	auth.require(ScopeRead)(&metricsOperation)
+	huma.Register(api, metricsOperation, apimMetrics)
+
+	return router
+}
+
 func apimStatus(ctx context.Context, input *struct{}) (*ApimStatus, error) {
 	var status ApimStatus
-	apigeeFlags := ApigeeFlags{Project: os.Getenv("APIGEE_PROJECT"), Region: os.Getenv("APIGEE_REGION")}
-	azureFlags := AzureFlags{Subscription: os.Getenv("AZURE_SUBSCRIPTION_ID"), ResourceGroup: os.Getenv("AZURE_RESOURCE_GROUP"), ServiceName: os.Getenv("AZURE_SERVICE_NAME")}
-	awsFlags := AwsFlags{Region: os.Getenv("AWS_REGION"), AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"), AccessSecret: os.Getenv("AWS_SECRET_ACCESS_KEY")}
-	status.Body.ApigeeStatus = apigeeStatus(&apigeeFlags)
-	status.Body.ApiHubStatus = apiHubStatus(&apigeeFlags)
-	status.Body.AzureStatus = azureStatus(&azureFlags)
-	status.Body.AwsStatus = awsStatus(&awsFlags)
+	status.Body = map[string]PlatformStatus{}
+	for _, name := range platformNames() {
+		status.Body[name] = platforms[name].New(PlatformOptions{Workspace: webServerWorkspace}).Status()
+		observePlatformStatus(name, status.Body[name])
+	}
 
 	return &status, nil
 }
 
-func apimOfframp(ctx context.Context, input *ApimOfframpInput) (*ApimOfframpOutput, error) {
-	var result ApimOfframpOutput
+// acceptedStatus makes an operation that starts a job respond with 202 Accepted.
+func acceptedStatus(o *huma.Operation) {
+	o.DefaultStatus = http.StatusAccepted
+}
+
+func jobOutput(job Job) *ApimJobOutput {
+	return &ApimJobOutput{Location: "/v1/apim/jobs/" + job.Id, Body: job}
+}
+
+// apimOfframp and apimOnramp run in the workspace of the web server, since the general APIs an offramp writes
+// are what a later onramp reads.
+func apimOfframp(ctx context.Context, input *ApimOfframpInput) (*ApimJobOutput, error) {
+	source, ok := newSourcePlatform(string(input.Body.Offramp), PlatformOptions{OnlyNew: input.Body.OnlyNew, Prune: input.Body.Prune, Workspace: webServerWorkspace})
+	if !ok {
+		return nil, huma.Error400BadRequest("Unknown offramp platform " + string(input.Body.Offramp) + ".")
+	}
+
+	lockCtx, release, err := acquireRunLock(context.WithoutCancel(ctx), webServerWorkspace, "offramp from "+string(input.Body.Offramp))
+	if err != nil {
+		return nil, runLockError(err)
+	}
+	job := startJob(lockCtx, "offramp", string(input.Body.Offramp), "", webServerWorkspace, func(ctx context.Context, job *Job) error {
+		defer release()
+		return runOfframpJob(ctx, job, source)
+	})
+	return jobOutput(job), nil
+}
+
+func apimOnramp(ctx context.Context, input *ApimOnrampInput) (*ApimJobOutput, error) {
+	target, ok := newTargetPlatform(string(input.Body.Onramp), PlatformOptions{Workspace: webServerWorkspace})
+	if !ok {
+		return nil, huma.Error400BadRequest("Unknown onramp platform " + string(input.Body.Onramp) + ".")
+	}
+
+	lockCtx, release, err := acquireRunLock(context.WithoutCancel(ctx), webServerWorkspace, "onramp to "+string(input.Body.Onramp))
+	if err != nil {
+		return nil, runLockError(err)
+	}
+	job := startJob(lockCtx, "onramp", "", string(input.Body.Onramp), webServerWorkspace, func(ctx context.Context, job *Job) error {
+		defer release()
+		return runOnrampJob(ctx, job, target)
+	})
+	return jobOutput(job), nil
+}
+
+// apimSync runs in a new job workspace, which is a temporary one if the web server runs syncs in temp workspaces.
+func apimSync(ctx context.Context, input *ApimSyncInput) (*ApimJobOutput, error) {
+	workspace, cleanup, err := newJobWorkspace()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Could not create the job workspace.", err)
+	}
+	source, ok := newSourcePlatform(string(input.Body.Offramp), PlatformOptions{Prune: input.Body.Prune, Workspace: workspace})
+	if !ok {
+		cleanup()
+		return nil, huma.Error400BadRequest("Unknown offramp platform " + string(input.Body.Offramp) + ".")
+	}
+	target, ok := newTargetPlatform(string(input.Body.Onramp), PlatformOptions{Prune: input.Body.Prune, PruneSource: jobPruneSource(workspace, string(input.Body.Offramp)), Workspace: workspace})
+	if !ok {
+		cleanup()
+		return nil, huma.Error400BadRequest("Unknown onramp platform " + string(input.Body.Onramp) + ".")
+	}
+
+	// a sync in a temporary workspace still changes the target, so it conflicts with the other runs of the web server
+	lockCtx, release, err := acquireRunLock(context.WithoutCancel(ctx), webServerWorkspace, "sync from "+string(input.Body.Offramp)+" to "+string(input.Body.Onramp))
+	if err != nil {
+		cleanup()
+		return nil, runLockError(err)
+	}
+	job := startJob(lockCtx, "sync", string(input.Body.Offramp), string(input.Body.Onramp), workspace, func(ctx context.Context, job *Job) error {
+		defer cleanup()
+		defer release()
+		return runSyncJob(ctx, job, source, target)
+	})
+	return jobOutput(job), nil
+}
+
+// runLockError returns 409 Conflict if another run holds the run lock.
+func runLockError(err error) error {
+	var lockedErr *RunLockedError
+	if errors.As(err, &lockedErr) {
+		return huma.Error409Conflict("Cannot start, " + lockedErr.Error() + ".")
+	}
+	return huma.Error500InternalServerError("Could not take the run lock.", err)
+}
 
-	azureFlags := AzureFlags{Subscription: os.Getenv("AZURE_SUBSCRIPTION_ID"), ResourceGroup: os.Getenv("AZURE_RESOURCE_GROUP"), ServiceName: os.Getenv("AZURE_SERVICE_NAME")}
-	azureFlags.OnlyNew = input.Body.OnlyNew
-	awsFlags := AwsFlags{Region: os.Getenv("AWS_REGION"), AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"), AccessSecret: os.Getenv("AWS_SECRET_ACCESS_KEY")}
-	awsFlags.OnlyNew = input.Body.OnlyNew
+func apimJobs(ctx context.Context, input *ApimJobsInput) (*ApimJobsOutput, error) {
+	var result ApimJobsOutput
+	result.Body.Jobs = listJobs(input.Limit)
+	return &result, nil
+}
 
-	if input.Body.Offramp == "azure" {
-		azureServiceExport(&azureFlags)
-		result.Body.Apis, _ = azureExport(&azureFlags)
-		azureOfframp(&azureFlags)
-		result.Body.Result = true
-	} else if input.Body.Offramp == "aws" {
-		result.Body.Apis, _ = awsExport(&awsFlags)
-		awsOfframp(&awsFlags)
-		result.Body.Result = true
+func apimJobEvents(ctx context.Context, input *ApimJobInput, send sse.Sender) {
+	found := watchJob(ctx, input.Id, func(event any) error {
+		return send.Data(event)
+	})
+	if !found {
+		send.Data(JobEvent{Job: input.Id, Status: JobFailed, Message: "Job " + input.Id + " not found.", Time: time.Now().UTC()})
 	}
+}
+
+func apimJob(ctx context.Context, input *ApimJobInput) (*ApimJobStatusOutput, error) {
+	job, ok := getJob(input.Id)
+	if !ok {
+		return nil, huma.Error404NotFound("Job " + input.Id + " not found.")
+	}
+	return &ApimJobStatusOutput{Body: job}, nil
+}
 
-	if result.Body.Result {
-		if result.Body.Apis == nil {
-			result.Body.Apis = []string{}
+// apimPlan plans with the general APIs of the web server workspace, or offramps them to a new job workspace first.
+func apimPlan(ctx context.Context, input *ApimPlanInput) (*ApimPlanOutput, error) {
+	var result ApimPlanOutput
+
+	workspace := webServerWorkspace
+	if input.Body.Offramp != "" {
+		jobWorkspace, cleanup, err := newJobWorkspace()
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Could not create the job workspace.", err)
+		}
+		defer cleanup()
+		workspace = jobWorkspace
+
+		source, ok := newSourcePlatform(string(input.Body.Offramp), PlatformOptions{Prune: input.Body.Prune, Workspace: workspace})
+		if !ok {
+			return nil, huma.Error400BadRequest("Unknown offramp platform " + string(input.Body.Offramp) + ".")
+		}
+		// exporting and offramping writes the local files, like a sync
+		lockCtx, release, err := acquireRunLock(ctx, webServerWorkspace, "plan from "+string(input.Body.Offramp)+" to "+string(input.Body.Onramp))
+		if err != nil {
+			return nil, runLockError(err)
 		}
-		result.Body.Message = strconv.Itoa(len(result.Body.Apis)) + " API(s) offramped."
+		defer release()
+		// like jobs, APIs that failed are listed with the plan, and the others are planned
+		exported, err := source.Export(lockCtx)
+		if err != nil && !onlyApisFailed(err) {
+			return nil, huma.Error500InternalServerError("Could not export APIs from "+string(input.Body.Offramp)+".", err)
+		}
+		offramped, err := source.Offramp(lockCtx)
+		if err != nil && !onlyApisFailed(err) {
+			return nil, huma.Error500InternalServerError("Could not offramp APIs from "+string(input.Body.Offramp)+".", err)
+		}
+		result.Body.Failed = append(exported.Failed, offramped.Failed...)
+	}
+
+	target, ok := newTargetPlatform(string(input.Body.Onramp), PlatformOptions{Prune: input.Body.Prune, PruneSource: jobPruneSource(workspace, string(input.Body.Offramp)), Workspace: workspace})
+	if !ok {
+		return nil, huma.Error400BadRequest("Unknown onramp platform " + string(input.Body.Onramp) + ".")
+	}
+	planningTarget, ok := target.(PlanningTarget)
+	if !ok {
+		return nil, huma.Error400BadRequest("Onramp platform " + string(input.Body.Onramp) + " does not support sync plans.")
 	}
 
+	plan, err := planningTarget.Plan(ctx)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Could not compute sync plan.", err)
+	}
+
+	result.Body.SyncPlan = plan
 	return &result, nil
 }
 
-func apimOnramp(ctx context.Context, input *ApimOnrampInput) (*ApimOnrampOutput, error) {
-	var result ApimOnrampOutput
+func apimState(ctx context.Context, input *struct{}) (*ApimStateOutput, error) {
+	var result ApimStateOutput
 
-	apigeeFlags := ApigeeFlags{Project: os.Getenv("APIGEE_PROJECT"), Region: os.Getenv("APIGEE_REGION")}
+	state, err := loadSyncState(webServerWorkspace)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Could not read the sync state.", err)
+	}
+	result.Body.Apis = []SyncStateApi{}
+	for _, name := range sortedKeys(state.Apis) {
+		result.Body.Apis = append(result.Body.Apis, state.Apis[name])
+	}
 
-	if input.Body.Onramp == "apihub" {
-		apiHubOnramp(&apigeeFlags)
-		apiHubImport(&apigeeFlags)
+	return &result, nil
+}
+
+func apimStateApi(ctx context.Context, input *ApimStateApiInput) (*ApimStateApiOutput, error) {
+	var result ApimStateApiOutput
+
+	state, err := loadSyncState(webServerWorkspace)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Could not read the sync state.", err)
+	}
+	api, ok := state.Apis[input.Name]
+	if !ok {
+		return nil, huma.Error404NotFound("API " + input.Name + " has not been synced.")
 	}
 
-	result.Body.Result = true
-	result.Body.Message = "Onramp to " + input.Body.Onramp + " successful!"
+	result.Body = api
 	return &result, nil
 }
 
-func apimSync(ctx context.Context, input *ApimSyncInput) (*ApimSyncOutput, error) {
-	var result ApimSyncOutput
+func catalogApis(ctx context.Context, input *CatalogApisInput) (*CatalogApisOutput, error) {
+	var result CatalogApisOutput
 
-	apigeeFlags := ApigeeFlags{Project: os.Getenv("APIGEE_PROJECT"), Region: os.Getenv("APIGEE_REGION")}
-	azureFlags := AzureFlags{Subscription: os.Getenv("AZURE_SUBSCRIPTION_ID"), ResourceGroup: os.Getenv("AZURE_RESOURCE_GROUP"), ServiceName: os.Getenv("AZURE_SERVICE_NAME")}
-	awsFlags := AwsFlags{Region: os.Getenv("AWS_REGION"), AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"), AccessSecret: os.Getenv("AWS_SECRET_ACCESS_KEY")}
+	storage, err := webServerStorage()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Could not open the workspace.", err)
+	}
+	apis := listCatalogApis(storage, input.Platform)
+	result.Body.Total = len(apis)
+	start := min(input.Offset, len(apis))
+	result.Body.Apis = apis[start:min(start+input.Limit, len(apis))]
 
-	if input.Body.Offramp == "azure" {
-		azureServiceExport(&azureFlags)
-		azureExport(&azureFlags)
-		azureOfframp(&azureFlags)
-	} else if input.Body.Offramp == "aws" {
-		awsExport(&awsFlags)
-		awsOfframp(&awsFlags)
+	return &result, nil
+}
+
+func catalogApi(ctx context.Context, input *CatalogApiInput) (*CatalogApiOutput, error) {
+	storage, err := webServerStorage()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Could not open the workspace.", err)
+	}
+	api, err := loadCatalogApi(storage, input.Name)
+	if err == errCatalogApiNotFound {
+		return nil, huma.Error404NotFound("API " + input.Name + " not found.")
+	} else if err != nil {
+		return nil, huma.Error500InternalServerError("Could not read API "+input.Name+".", err)
 	}
+	return &CatalogApiOutput{Body: api}, nil
+}
 
-	if input.Body.Onramp == "apihub" {
-		apiHubOnramp(&apigeeFlags)
-		apiHubImport(&apigeeFlags)
+func catalogSpec(ctx context.Context, input *CatalogSpecInput) (*CatalogSpecOutput, error) {
+	storage, err := webServerStorage()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Could not open the workspace.", err)
+	}
+	api, err := loadCatalogApi(storage, input.Name)
+	if err == errCatalogApiNotFound {
+		return nil, huma.Error404NotFound("API " + input.Name + " not found.")
+	} else if err != nil {
+		return nil, huma.Error500InternalServerError("Could not read API "+input.Name+".", err)
 	}
 
-	result.Body.Result = true
-	result.Body.Message = "Sync from " + input.Body.Offramp + " to " + input.Body.Onramp + " successful!"
+	name, bytes, err := readCatalogSpec(api, input.Platform, input.Deployment)
+	if err == errCatalogApiNotFound {
+		return nil, huma.Error404NotFound("API " + input.Name + " has no " + input.Platform + " spec.")
+	} else if name == "" {
+		// several specs on the platform and no deployment given
+		return nil, huma.Error400BadRequest(err.Error() + ".")
+	} else if err != nil {
+		return nil, huma.Error500InternalServerError("Could not read the spec of "+name+".", err)
+	}
+
+	return &CatalogSpecOutput{ContentType: "application/json", ContentDisposition: "attachment; filename=\"" + name + "-oas.json\"", Body: bytes}, nil
+}
+
+func searchApis(ctx context.Context, input *SearchInput) (*SearchOutput, error) {
+	storage, err := webServerStorage()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Could not open the workspace.", err)
+	}
+	result := buildSearchIndex(storage).search(input.Query, SearchFilters{Platform: input.Platform, Owner: input.Owner, Version: input.Version})
+	start := min(input.Offset, len(result.Hits))
+	result.Hits = result.Hits[start:min(start+input.Limit, len(result.Hits))]
+	return &SearchOutput{Body: result}, nil
+}
+
+func apimSchedules(ctx context.Context, input *struct{}) (*ApimSchedulesOutput, error) {
+	var result ApimSchedulesOutput
+	result.Body.Schedules = listSchedules()
 	return &result, nil
 }
+
+func apimSchedulePause(ctx context.Context, input *ApimScheduleInput) (*ApimScheduleOutput, error) {
+	schedule, ok := pauseSchedule(input.Name, true)
+	if !ok {
+		return nil, huma.Error404NotFound("Schedule " + input.Name + " not found.")
+	}
+	return &ApimScheduleOutput{Body: schedule}, nil
+}
+
+func apimScheduleResume(ctx context.Context, input *ApimScheduleInput) (*ApimScheduleOutput, error) {
+	schedule, ok := pauseSchedule(input.Name, false)
+	if !ok {
+		return nil, huma.Error404NotFound("Schedule " + input.Name + " not found.")
+	}
+	return &ApimScheduleOutput{Body: schedule}, nil
+}
+
+func apimScheduleTrigger(ctx context.Context, input *ApimScheduleInput) (*ApimJobOutput, error) {
+	job, ok, err := triggerSchedule(input.Name)
+	if !ok {
+		return nil, huma.Error404NotFound("Schedule " + input.Name + " not found.")
+	} else if err == errScheduleRunning {
+		return nil, huma.Error409Conflict("Schedule " + input.Name + " is already running.")
+	} else if err != nil {
+		return nil, runLockError(err)
+	}
+	return jobOutput(job), nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// checkStatus checks that a handler failed with the status code.
+func checkStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var statusErr huma.StatusError
+	if !errors.As(err, &statusErr) || statusErr.GetStatus() != status {
+		t.Errorf("expected status %d, got %v", status, err)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.sync(PlatformOptions{})
+	s.webServer(JobWorkspaceShared)
+	ctx := context.Background()
+
+	apis, err := catalogApis(ctx, &CatalogApisInput{Platform: "azure", Limit: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apis.Body.Total != 2 || len(apis.Body.Apis) != 2 || apis.Body.Apis[0].Name != "orders" || apis.Body.Apis[1].Name != "petstore" {
+		t.Errorf("expected orders and petstore on azure, got %d APIs", apis.Body.Total)
+	}
+	apis, err = catalogApis(ctx, &CatalogApisInput{Offset: 1, Limit: 1})
+	if err != nil || apis.Body.Total != 3 || len(apis.Body.Apis) != 1 || apis.Body.Apis[0].Name != "orders" {
+		t.Errorf("expected the second page to be orders, got %v", err)
+	}
+
+	api, err := catalogApi(ctx, &CatalogApiInput{Name: "petstore"})
+	if err != nil || len(api.Body.Deployments) != 2 {
+		t.Errorf("expected petstore with 2 deployments, got %v", err)
+	}
+	_, err = catalogApi(ctx, &CatalogApiInput{Name: "missing"})
+	checkStatus(t, err, 404)
+
+	spec, err := catalogSpec(ctx, &CatalogSpecInput{Name: "petstore", Platform: "aws-api-gateway"})
+	if err != nil || string(spec.Body) != fakeSpec("Petstore", "v2") {
+		t.Errorf("expected the aws spec of petstore, got %v", err)
+	}
+	_, err = catalogSpec(ctx, &CatalogSpecInput{Name: "petstore", Platform: "apigee"})
+	checkStatus(t, err, 404)
+}
+
+func TestSyncStateEndpoints(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.webServer(JobWorkspaceShared)
+	ctx := context.Background()
+
+	state, err := apimState(ctx, &struct{}{})
+	if err != nil || len(state.Body.Apis) != 0 {
+		t.Errorf("expected no synced APIs, got %v", err)
+	}
+
+	s.sync(PlatformOptions{})
+	state, err = apimState(ctx, &struct{}{})
+	if err != nil || len(state.Body.Apis) != 3 {
+		t.Errorf("expected 3 synced APIs, got %v", err)
+	}
+	api, err := apimStateApi(ctx, &ApimStateApiInput{Name: "petstore"})
+	if err != nil || api.Body.Targets["apihub"].ContentHash == "" {
+		t.Errorf("expected petstore to be synced to apihub, got %v", err)
+	}
+	_, err = apimStateApi(ctx, &ApimStateApiInput{Name: "missing"})
+	checkStatus(t, err, 404)
+}
+
+// TestPlanFailingApi checks that a plan with an offramp lists the APIs that failed, and plans the others.
+func TestPlanFailingApi(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.fakes.Azure.AddApi(AzureApi{Name: "billing", Properties: AzureApiProperties{DisplayName: "Billing", Path: "billing", IsCurrent: true}}, fakeSpec("Billing", "v1"))
+	s.fakes.Azure.FailingSchemas = []string{"billing"}
+	s.webServer(JobWorkspaceShared)
+
+	input := &ApimPlanInput{}
+	input.Body.Offramp, input.Body.Onramp = "azure", "apihub"
+	plan, err := apimPlan(context.Background(), input)
+	if err != nil {
+		t.Fatalf("expected a plan, got %v", err)
+	}
+	if len(plan.Body.Failed) != 1 || plan.Body.Failed[0].Api != "billing" {
+		t.Errorf("expected billing to have failed, got %v", plan.Body.Failed)
+	}
+	apis := map[string]bool{}
+	for _, action := range plan.Body.Actions {
+		apis[action.Api] = true
+	}
+	if !apis["petstore"] || apis["billing"] {
+		t.Errorf("expected a plan for petstore without billing, got %v", apis)
+	}
+}
+
+// TestWebServerAuth checks the credentials and scopes the router requires, also for the metrics.
+func TestWebServerAuth(t *testing.T) {
+	newE2eSuite(t, "").webServer(JobWorkspaceShared)
+	router := newWebServerRouter(&WebServerAuth{Authenticators: []Authenticator{&apiKeyAuthenticator{keys: parseScopeMap("reader=read;syncer=sync")}}})
+
+	tests := []struct {
+		method string
+		path   string
+		key    string
+		status int
+	}{
+		{http.MethodGet, "/metrics", "", http.StatusUnauthorized},
+		{http.MethodGet, "/metrics", "invalid", http.StatusUnauthorized},
+		{http.MethodGet, "/metrics", "syncer", http.StatusForbidden},
+		{http.MethodGet, "/metrics", "reader", http.StatusOK},
+		{http.MethodGet, "/v1/apim/state", "", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/apim/state", "reader", http.StatusOK},
+		{http.MethodPost, "/v1/apim/schedules/missing/trigger", "reader", http.StatusForbidden},
+		{http.MethodPost, "/v1/apim/schedules/missing/trigger", "syncer", http.StatusNotFound},
+		{http.MethodGet, "/openapi.json", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.key != "" {
+			req.Header.Set("X-API-Key", test.key)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%s %s with key %q: expected %d, got %d", test.method, test.path, test.key, test.status, recorder.Code)
+		}
+	}
+}
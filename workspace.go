@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// A workspace is the directory a run keeps its files in: the exported, general and onramped APIs, the sync
+// state and the run lock. It is src/main in the working directory, unless --workspace, APIMSYNC_WORKSPACE or
+// the workspace key of the config profile give another one.
+
+// workspaceRoot returns the workspace directory, the given one or else APIMSYNC_WORKSPACE or src/main.
+func workspaceRoot(workspace string) string {
+	if workspace == "" {
+		workspace = os.Getenv("APIMSYNC_WORKSPACE")
+	}
+	if workspace == "" {
+		workspace = "src/main"
+	}
+	return workspace
+}
+
+// The web server runs all jobs in its workspace, or each job in a new temporary workspace that is removed when
+// the job is done, so that jobs don't write to the same files.
+const (
+	JobWorkspaceShared = "shared"
+	JobWorkspaceTemp   = "temp"
+)
+
+// webServerWorkspace is the workspace of the web server, which the catalog, search and state endpoints read.
+var webServerWorkspace string
+
+// webServerStorage returns the storage of the web server workspace.
+func webServerStorage() (Storage, error) {
+	return workspaceStorage(webServerWorkspace)
+}
+
+// webServerJobWorkspace is JobWorkspaceShared or JobWorkspaceTemp.
+var webServerJobWorkspace = JobWorkspaceShared
+
+func checkJobWorkspace(value string) error {
+	if value != JobWorkspaceShared && value != JobWorkspaceTemp {
+		return errors.New("unknown job workspace " + value + ", use " + JobWorkspaceShared + " or " + JobWorkspaceTemp)
+	}
+	return nil
+}
+
+// jobWorkspaces are the temporary job workspaces of the running jobs.
+var jobWorkspaces = map[string]bool{}
+var jobWorkspacesMutex sync.Mutex
+
+// newJobWorkspace returns the workspace a web server job runs in, and a function that cleans it up when the job is done.
+// Temporary job workspaces are local directories, also if the web server workspace is in an object store, and keep
+// their sync state in the web server workspace, see stateWorkspace.
+func newJobWorkspace() (string, func(), error) {
+	if webServerJobWorkspace != JobWorkspaceTemp {
+		return webServerWorkspace, func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "apimsync-job-")
+	if err != nil {
+		return "", nil, errors.New("could not create job workspace: " + err.Error())
+	}
+
+	jobWorkspacesMutex.Lock()
+	jobWorkspaces[dir] = true
+	jobWorkspacesMutex.Unlock()
+	return dir, func() {
+		jobWorkspacesMutex.Lock()
+		delete(jobWorkspaces, dir)
+		jobWorkspacesMutex.Unlock()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// jobPruneSource returns the source platform that pruning is limited to in a job workspace. A temporary job
+// workspace only has the APIs of the offramp of the job, so the resources of the other sources must not be pruned.
+func jobPruneSource(workspace string, offramp string) string {
+	if workspace == webServerWorkspace {
+		return ""
+	}
+	return offramp
+}
+
+// stateWorkspace returns the workspace the sync state of a workspace is kept in, the web server workspace for
+// temporary job workspaces, so that syncs skip the APIs that are unchanged since the last job.
+func stateWorkspace(workspace string) string {
+	jobWorkspacesMutex.Lock()
+	defer jobWorkspacesMutex.Unlock()
+
+	if jobWorkspaces[workspace] {
+		return webServerWorkspace
+	}
+	return workspace
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+func TestJobWorkspaceTemp(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.sync(PlatformOptions{})
+	state, _ := s.storage.ReadFile("syncstate.json")
+
+	s.fakes.Azure.AddApi(AzureApi{Name: "petstore-v1", Properties: AzureApiProperties{DisplayName: "Petstore", Description: "The new petstore API.", Path: "petstore", ApiVersion: "v1", IsCurrent: true}}, fakeSpec("Petstore", "v1.1"))
+	s.webServer(JobWorkspaceTemp)
+	input := &ApimSyncInput{}
+	input.Body.Offramp, input.Body.Onramp = "azure", "apihub"
+	job := s.waitForJob(apimSync(context.Background(), input))
+	if job.Status != JobSucceeded {
+		t.Fatalf("job %s: %s %v", job.Status, job.Message, job.Errors)
+	}
+	if _, err := os.Stat(job.workspace); job.workspace == s.workspace || err == nil {
+		t.Errorf("expected a removed temporary job workspace, got %s", job.workspace)
+	}
+	s.checkField("deployments/petstore-v1-azure", "description", "The new petstore API.")
+	// the job only has the Azure APIs, and doesn't prune
+	s.checkNames("apis/petstore/versions", "petstore-v1", "petstore-v2")
+
+	// the job keeps its sync state in the web server workspace
+	jobState, _ := s.storage.ReadFile("syncstate.json")
+	if len(jobState) == 0 || string(jobState) == string(state) {
+		t.Errorf("expected the job to update the sync state of the workspace")
+	}
+}
+
+func TestJobWorkspaceTempConflict(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.webServer(JobWorkspaceTemp)
+	_, release, err := acquireRunLock(context.Background(), s.workspace, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	// a sync in a temporary workspace still takes the lock of the web server workspace
+	input := &ApimSyncInput{}
+	input.Body.Offramp, input.Body.Onramp = "azure", "apihub"
+	_, err = apimSync(context.Background(), input)
+	var statusErr huma.StatusError
+	if !errors.As(err, &statusErr) || statusErr.GetStatus() != 409 {
+		t.Errorf("expected 409 Conflict, got %v", err)
+	}
+	jobWorkspacesMutex.Lock()
+	defer jobWorkspacesMutex.Unlock()
+	if len(jobWorkspaces) > 0 {
+		t.Errorf("expected the job workspace to be removed, got %v", jobWorkspaces)
+	}
+}
+
+// TestJobWorkspaceTempPrune checks that a sync with prune in a temporary workspace, which only has the APIs of its
+// offramp, only removes the resources created from that offramp.
+func TestJobWorkspaceTempPrune(t *testing.T) {
+	s := newE2eSuite(t, "")
+	s.sync(PlatformOptions{Prune: true})
+	s.webServer(JobWorkspaceTemp)
+	sync := func(offramp SourcePlatformName) {
+		t.Helper()
+		input := &ApimSyncInput{}
+		input.Body.Offramp, input.Body.Onramp, input.Body.Prune = offramp, "apihub", true
+		if job := s.waitForJob(apimSync(context.Background(), input)); job.Status != JobSucceeded {
+			t.Fatalf("sync from %s: job %s: %s %v", offramp, job.Status, job.Message, job.Errors)
+		}
+	}
+
+	s.fakes.Azure.DeleteApi("orders")
+	s.fakes.Azure.DeleteApi("orders;rev=2")
+	sync("azure")
+	s.checkNames("apis", "inventory", "petstore")
+	s.checkNames("deployments", "inventory-aws", "petstore-v1-azure", "petstore-v2-aws")
+	s.checkNames("apis/petstore/versions", "petstore-v1", "petstore-v2")
+	s.checkSourceAttribute("apis/petstore", apiHubApiSourcePlatformAttribute, "aws-api-gateway")
+
+	s.fakes.Aws.DeleteApi("Inventory")
+	sync("aws")
+	s.checkNames("apis", "petstore")
+	s.checkNames("deployments", "petstore-v1-azure", "petstore-v2-aws")
+	s.checkNames("apis/petstore/versions", "petstore-v1", "petstore-v2")
+	s.checkSourceAttribute("apis/petstore", apiHubApiSourcePlatformAttribute, "azure-api-management")
+}
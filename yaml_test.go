@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestLoadConfigReadme loads the example config file of the README.
+func TestLoadConfigReadme(t *testing.T) {
+	readme, err := os.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, example, found := strings.Cut(string(readme), "```yaml\n")
+	example, _, _ = strings.Cut(example, "```")
+	if !found {
+		t.Fatal("the README has no example config file")
+	}
+	file := filepath.Join(t.TempDir(), "apimsync.yaml")
+	os.WriteFile(file, []byte(example), 0644)
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+	config, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, prod := config.Profiles["dev"], config.Profiles["prod"]
+	if config.DefaultProfile != "dev" || len(config.Profiles) != 2 {
+		t.Errorf("expected the profiles dev and prod, got %v", config)
+	}
+	if dev.Sources.Azure == nil || dev.Sources.Azure.ClientSecret != "secret" {
+		t.Errorf("expected the client secret from the env, got %v", dev.Sources.Azure)
+	}
+	if dev.Targets.ApiHub == nil || !slices.Equal(dev.Targets.ApiHub.Protected, []string{"petstore", "orders-api"}) {
+		t.Errorf("expected the protected APIs, got %v", dev.Targets.ApiHub)
+	}
+	if len(dev.Syncs) != 1 || dev.Syncs[0].Cron != "*/30 * * * *" || !dev.Syncs[0].Prune {
+		t.Errorf("expected the sync, got %v", dev.Syncs)
+	}
+	if !slices.Equal(dev.Mappings.Precedence["*"], []string{"azure", "aws"}) {
+		t.Errorf("expected the precedence, got %v", dev.Mappings.Precedence)
+	}
+	if prod.Workspace != "/var/lib/apimsync/prod" || prod.Sources.Aws == nil || !slices.Equal(prod.Sources.Aws.Regions, []string{"eu-west-1", "us-east-1"}) {
+		t.Errorf("expected the prod workspace and AWS regions, got %v", prod)
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "apimsync.yaml")
+	os.WriteFile(file, []byte("profiles:\n  dev:\n    source:\n      azure: {}\n"), 0644)
+	if _, err := loadConfig(file); err == nil || !strings.Contains(err.Error(), "source") {
+		t.Errorf("expected an error for the unknown field source, got %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"empty", "", ""},
+		{"duplicate key", "defaultProfile: dev\ndefaultProfile: prod", "already set"},
+		{"syntax", "profiles:\n  dev: [", "could not read"},
+		{"type", "profiles:\n  dev:\n    syncs: azure", "could not read"},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "apimsync.yaml")
+		os.WriteFile(file, []byte(test.yaml), 0644)
+		if _, err := loadConfig(file); (test.err == "" && err != nil) || (test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err))) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	// scalars are read as strings where the fields are strings, and variables don't change the structure
+	file := filepath.Join(t.TempDir(), "apimsync.yaml")
+	os.WriteFile(file, []byte("profiles:\n  dev:\n    env:\n      APIMSYNC_HTTP_RETRIES: 6\n      APIMSYNC_HTTP_LOG: true\n    sources:\n      azure:\n        clientSecret: ${AZURE_CLIENT_SECRET}\n"), 0644)
+	t.Setenv("AZURE_CLIENT_SECRET", "a: b # c")
+	config, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := config.Profiles["dev"]
+	if dev.Env["APIMSYNC_HTTP_RETRIES"] != "6" || dev.Env["APIMSYNC_HTTP_LOG"] != "true" {
+		t.Errorf("expected the env values as strings, got %v", dev.Env)
+	}
+	if dev.Sources.Azure == nil || dev.Sources.Azure.ClientSecret != "a: b # c" {
+		t.Errorf("expected the client secret from the env, got %v", dev.Sources.Azure)
+	}
+}